@@ -1,29 +1,83 @@
 package cmd
 
 import (
+	"archive/tar"
 	"context"
+	"debug/buildinfo"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"mime"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
 	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/containerd/containerd/platforms"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/opencontainers/go-digest"
 	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
+	"go.alexhamlin.co/zeroimage/internal/containerdstore"
+	"go.alexhamlin.co/zeroimage/internal/dockerarchive"
+	"go.alexhamlin.co/zeroimage/internal/ignore"
 	"go.alexhamlin.co/zeroimage/internal/image"
 	"go.alexhamlin.co/zeroimage/internal/ociarchive"
 	"go.alexhamlin.co/zeroimage/internal/registry"
+	"go.alexhamlin.co/zeroimage/internal/tarbuild"
 	"go.alexhamlin.co/zeroimage/internal/tarlayer"
 )
 
 var buildCmd = &cobra.Command{
 	Use:   "build [flags] ENTRYPOINT",
 	Short: "Build an image from an entrypoint binary",
-	Args:  cobra.ExactArgs(1),
-	Run:   runBuild,
+	Long: `Build an image from an entrypoint binary.
+
+With --rootfs, the contents of a directory are added to the image in their own
+layer. The ENTRYPOINT argument may then be omitted in favor of
+--entrypoint-path, which names a program that the directory or base image
+already provides.
+
+The ENTRYPOINT argument may also be omitted when --layer adds prebuilt layer
+tarballs or the build has a base image, to compose an image from existing
+layers while still using build for its configuration. Without ENTRYPOINT or
+--rootfs to name the default output file, --output or --push is required.
+
+With --config, build options are read from a YAML or JSON file whose keys are
+the long names of build flags, plus "entrypoint" for the ENTRYPOINT argument.
+For example:
+
+  entrypoint: some-program
+  from: gcr.io/distroless/static:latest
+  env: [LOG_LEVEL=info]
+  expose: [8080]
+  push:
+    - registry.example.com/some-program:latest
+
+Flags and arguments on the command line take precedence over the config file.
+
+With --config-only, the ENTRYPOINT argument is omitted and no layers are added
+to the base image. Only the configuration changes requested by flags like
+--env, --label, --expose, and --entrypoint-path are applied, so the new image
+shares every layer with its base.
+
+The ENTRYPOINT argument may also be an http:// or https:// URL, in which case
+the entrypoint is downloaded using the proxy settings and CA certificates of
+the host, and placed in the image under the final element of the URL's path.`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  runBuild,
 }
 
 var (
@@ -38,146 +92,1513 @@ func init() {
 }
 
 var (
-	buildFrom        string
-	buildFromArchive string
-	buildOutput      string
-	buildPlatform    string
-	buildPush        string
+	buildFrom           string
+	buildFromArchive    string
+	buildOutput         string
+	buildOutputFmt      string
+	buildPlatform       string
+	buildPush           []string
+	buildAnnotations    []string
+	buildArtifactType   string
+	buildConfigMedia    string
+	buildDigestAlg      string
+	buildRefName        string
+	buildCompression    string
+	buildAdd            []string
+	buildManifestFmt    string
+	buildMaxRetries     int
+	buildAlwaysUpload   bool
+	buildSkipAuthCheck  bool
+	buildNoTimestamp    bool
+	buildWithTmp        bool
+	buildScaffold       bool
+	buildCACerts        string
+	buildWithTZData     bool
+	buildConfig         string
+	buildEnv            []string
+	buildEnvPrepend     []string
+	buildEnvAppend      []string
+	buildLabels         []string
+	buildExpose         []string
+	buildAnnotateEP     bool
+	buildSBOM           bool
+	buildLockfile       string
+	buildReportFormat   string
+	buildLayers         []string
+	buildStrict         bool
+	buildRmBase         int
+	buildSquashBase     bool
+	buildMaxLayerSize   int64
+	buildRootFS         string
+	buildExclude        []string
+	buildExcludeFile    string
+	buildEntrypointPath string
+	buildConfigOnly     bool
+
+	buildHealthCmd         string
+	buildHealthInterval    time.Duration
+	buildHealthTimeout     time.Duration
+	buildHealthStartPeriod time.Duration
+	buildHealthRetries     int
 )
 
 func init() {
 	rootCmd.AddCommand(buildCmd)
 
-	buildCmd.Flags().StringVar(&buildFrom, "from", "", "Use an image from a remote registry as a base")
-	buildCmd.Flags().StringVar(&buildFromArchive, "from-archive", "", "Use an existing image archive as a base")
-	buildCmd.Flags().StringVarP(&buildOutput, "output", "o", "", "Write the image archive to this path (default [ENTRYPOINT].tar)")
+	buildCmd.Flags().StringVar(&buildFrom, "from", "", "Use an image from a remote registry, from a containerd content store with containerd://[ROOT@]DIGEST, or from the Docker daemon with docker-daemon:IMAGE, as a base")
+	buildCmd.Flags().StringVar(&buildFromArchive, "from-archive", "", "Use an existing image archive (path, http(s) URL, or - for stdin) as a base, optionally suffixed with @DIGEST to select a manifest")
+	buildCmd.Flags().StringVarP(&buildOutput, "output", "o", "", "Write the image archive to this path, or - for stdout (default [ENTRYPOINT].tar)")
+	buildCmd.Flags().StringVar(&buildOutputFmt, "output-format", ociOutputFormat, "Write the image archive in this format (oci, or docker for docker load)")
 	buildCmd.Flags().StringVar(&buildPlatform, "platform", defaultPlatform, "Select the desired platform for the image")
-	buildCmd.Flags().StringVar(&buildPush, "push", "", "Push the image to this tag in a remote registry")
+	buildCmd.Flags().StringSliceVar(&buildPush, "push", nil, "Push the image to this tag in a remote registry (repeatable or comma-separated)")
+	buildCmd.Flags().StringVar(&buildManifestFmt, "manifest-format", string(registry.OCIManifest), "Push the image with this manifest format (oci or docker)")
+	buildCmd.Flags().IntVar(&buildMaxRetries, "max-retries", registry.DefaultMaxRetries, "Retry each failed upload to the registry up to this many times")
+	buildCmd.Flags().BoolVar(&buildAlwaysUpload, "always-upload", false, "Upload every blob without first checking whether the registry already has it")
+	buildCmd.Flags().BoolVar(&buildSkipAuthCheck, "skip-auth-check", false, "Skip checking that the image can be pushed before building it")
+	buildCmd.Flags().StringArrayVar(&buildAnnotations, "annotation", nil, "Set an annotation on the image manifest (KEY=VALUE, repeatable)")
+	buildCmd.Flags().StringVar(&buildArtifactType, "artifact-type", "", "Set the artifact type of the image manifest, for images that package non-container artifacts")
+	buildCmd.Flags().StringVar(&buildConfigMedia, "config-media-type", "", "Set the media type of the image's config blob, for artifacts that are not runnable images (default "+specsv1.MediaTypeImageConfig+")")
+	buildCmd.Flags().StringVar(&buildRefName, "ref-name", "", "Name the image in the archive's index with an org.opencontainers.image.ref.name annotation (e.g. latest), or with --output-format docker, the tag that docker load applies (e.g. app:latest)")
+	buildCmd.Flags().StringVar(&buildDigestAlg, "digest-algorithm", string(digest.Canonical), "Use this algorithm (sha256, sha384, or sha512) for new blob digests")
+	buildCmd.Flags().BoolVar(&buildSquashBase, "squash-base", false, "Squash the layers of the base image into a single layer before adding new layers")
+	buildCmd.Flags().Int64Var(&buildMaxLayerSize, "max-layer-size", 0, "Split the entrypoint layer into multiple layers of about this many uncompressed bytes (0 for no limit)")
+	buildCmd.Flags().StringVar(&buildCompression, "compression", string(tarlayer.Gzip), "Compress the entrypoint layer with this method (gzip or none)")
+	buildCmd.Flags().BoolVar(&buildWithTmp, "with-tmp", false, "Add a world-writable /tmp directory (mode 1777) to the entrypoint layer")
+	buildCmd.Flags().BoolVar(&buildScaffold, "scaffold", false, "Add a minimal /etc/passwd, /etc/nsswitch.conf, and /tmp to the entrypoint layer")
+	buildCmd.Flags().StringVar(&buildCACerts, "ca-certs", "", "Add the CA certificate bundle at this path (or the host's bundle if no path is given) to the entrypoint layer at "+caCertsTargetPath)
+	buildCmd.Flags().Lookup("ca-certs").NoOptDefVal = hostCACerts
+	buildCmd.Flags().BoolVar(&buildWithTZData, "with-tzdata", false, "Add the host's time zone database to the entrypoint layer at "+tzdataTargetPath)
+	buildCmd.Flags().StringArrayVar(&buildLayers, "layer", nil, "Add an existing layer tarball (gzip, or uncompressed tar) to the image (repeatable)")
+	buildCmd.Flags().StringVar(&buildRootFS, "rootfs", "", "Add the contents of this directory to the root of the image in their own layer")
+	buildCmd.Flags().StringArrayVar(&buildExclude, "exclude", nil, "Exclude files matching this .dockerignore-style pattern from --rootfs (repeatable)")
+	buildCmd.Flags().StringVar(&buildExcludeFile, "exclude-file", "", "Exclude files matching the .dockerignore-style patterns in this file from --rootfs")
+	buildCmd.Flags().StringVar(&buildEntrypointPath, "entrypoint-path", "", "Run the program at this path in the image (default /[ENTRYPOINT base name])")
+	buildCmd.Flags().BoolVar(&buildConfigOnly, "config-only", false, "Change only the configuration of the base image, without adding any layers")
+	buildCmd.Flags().StringArrayVar(&buildAdd, "add", nil, "Add a file to the image in its own layer (SRC:DEST[:COMPRESSION], repeatable)")
+
+	buildCmd.Flags().BoolVar(&buildNoTimestamp, "no-timestamp", false, "Omit creation times from the image configuration and history, and set new file modification times to the Unix epoch")
+	buildCmd.Flags().BoolVar(&buildAnnotateEP, "annotate-entrypoint", false, "Record the entrypoint's SHA-256 digest and Go version in image annotations")
+	buildCmd.Flags().BoolVar(&buildSBOM, "sbom", false, "After pushing, attach an SPDX SBOM listing the entrypoint's digest and Go modules to the image as a referrer")
+	buildCmd.Flags().StringVar(&buildReportFormat, "report", "", "After a successful build, print a summary of the image to standard output in this format (json)")
+	buildCmd.Flags().StringVar(&buildLockfile, "lockfile", "", "After a successful build, write the image's manifest and blob digests to this JSON file")
+	buildCmd.Flags().StringVar(&buildHealthCmd, "healthcheck-cmd", "", `Set the command that checks the container's health (a JSON array to run directly, a string to run with a shell, or "none" to disable)`)
+	buildCmd.Flags().DurationVar(&buildHealthInterval, "healthcheck-interval", 0, "Set the time between healthchecks")
+	buildCmd.Flags().DurationVar(&buildHealthTimeout, "healthcheck-timeout", 0, "Set the time after which a healthcheck is considered to have failed")
+	buildCmd.Flags().DurationVar(&buildHealthStartPeriod, "healthcheck-start-period", 0, "Set the time after container start during which healthcheck failures are ignored")
+	buildCmd.Flags().IntVar(&buildHealthRetries, "healthcheck-retries", 0, "Set the number of consecutive healthcheck failures after which the container is unhealthy")
+	buildCmd.Flags().IntVar(&buildRmBase, "rm-base-layer", 0, "Remove this many layers from the top of the base image before adding new layers")
+	buildCmd.Flags().BoolVar(&buildStrict, "strict", false, "Fail instead of warning when the entrypoint would shadow a file in the base image")
+	buildCmd.Flags().StringVar(&buildConfig, "config", "", "Read build options from this YAML or JSON file")
+	buildCmd.Flags().StringArrayVar(&buildEnv, "env", nil, "Set an environment variable in the image (KEY=VALUE, repeatable)")
+	buildCmd.Flags().StringArrayVar(&buildEnvPrepend, "env-prepend", nil, "Add VALUE to the start of a colon-separated environment variable like PATH (KEY=VALUE, repeatable)")
+	buildCmd.Flags().StringArrayVar(&buildEnvAppend, "env-append", nil, "Add VALUE to the end of a colon-separated environment variable like PATH (KEY=VALUE, repeatable)")
+	buildCmd.Flags().StringArrayVar(&buildLabels, "label", nil, "Set a label in the image configuration (KEY=VALUE, repeatable)")
+	buildCmd.Flags().StringArrayVar(&buildExpose, "expose", nil, "Expose a port from the image (PORT[/PROTOCOL], repeatable)")
 
+	buildCmd.MarkFlagFilename("config", "yaml", "yml", "json")
+	buildCmd.MarkFlagFilename("lockfile", "json")
 	buildCmd.MarkFlagFilename("from-archive", "tar")
 	buildCmd.MarkFlagFilename("output", "tar")
 }
 
-func runBuild(_ *cobra.Command, args []string) {
-	entrypointSourcePath := args[0]
-	entrypointBase := filepath.Base(entrypointSourcePath)
-	entrypointTargetPath := "/" + entrypointBase
+func runBuild(cmd *cobra.Command, args []string) {
+	if buildConfig != "" {
+		var err error
+		args, err = applyBuildConfig(cmd.Flags(), buildConfig, args)
+		if err != nil {
+			log.Fatal("Invalid build config: ", err)
+		}
+	}
+	if buildConfigOnly {
+		if err := checkConfigOnly(cmd.Flags(), args); err != nil {
+			log.Fatal("Invalid use of --config-only: ", err)
+		}
+	} else if len(args) == 0 && (buildRootFS == "" || buildEntrypointPath == "") && !hasLayerSource() {
+		log.Fatal("An ENTRYPOINT argument is required, unless --rootfs and --entrypoint-path, --layer, or a base image are given")
+	}
 
-	if buildOutput == "" {
-		buildOutput = entrypointSourcePath + ".tar"
+	var entrypointSourcePath, entrypointTargetPath, entrypointOutputBase string
+	if len(args) > 0 && isArchiveURL(args[0]) {
+		name, err := entrypointURLName(args[0])
+		if err != nil {
+			log.Fatal("Invalid entrypoint URL: ", err)
+		}
+		log.Printf("Downloading entrypoint: %s", args[0])
+		entrypointSourcePath, err = downloadEntrypoint(context.TODO(), args[0])
+		if err != nil {
+			log.Fatal("Unable to download entrypoint: ", err)
+		}
+		defer os.Remove(entrypointSourcePath)
+		entrypointTargetPath = "/" + name
+		entrypointOutputBase = name
+	} else if len(args) > 0 {
+		entrypointSourcePath = args[0]
+		entrypointTargetPath = "/" + filepath.Base(entrypointSourcePath)
+		entrypointOutputBase = entrypointSourcePath
+	}
+	if buildEntrypointPath != "" {
+		entrypointTargetPath = path.Join("/", buildEntrypointPath)
 	}
 
-	platform, err := platforms.Parse(buildPlatform)
+	if entrypointSourcePath != "" {
+		if err := checkEntrypoint(entrypointSourcePath); err != nil {
+			log.Fatal("Invalid entrypoint: ", err)
+		}
+	}
+	if buildRootFS != "" {
+		if stat, err := os.Stat(buildRootFS); err != nil {
+			log.Fatal("Invalid root filesystem: ", err)
+		} else if !stat.IsDir() {
+			log.Fatalf("Invalid root filesystem: %s is not a directory", buildRootFS)
+		}
+	}
+	rootFSExclusions, err := loadRootFSExclusions()
+	if err != nil {
+		log.Fatal("Invalid exclusions: ", err)
+	}
+
+	if buildOutput == "" && !buildConfigOnly {
+		switch {
+		case entrypointSourcePath != "":
+			buildOutput = entrypointOutputBase + ".tar"
+		case buildRootFS != "":
+			buildOutput = filepath.Clean(buildRootFS) + ".tar"
+		case len(buildPush) == 0:
+			log.Fatal("An --output or --push destination is required without an ENTRYPOINT argument or --rootfs")
+		}
+	}
+
+	if buildCACerts == hostCACerts {
+		path, err := findHostCACerts()
+		if err != nil {
+			log.Fatal("Unable to find CA certificates: ", err)
+		}
+		buildCACerts = path
+	}
+
+	platform, err := parsePlatform(buildPlatform)
 	if err != nil {
 		log.Fatal("Could not parse target platform: ", err)
 	}
 
-	img, err := loadBaseImage(platform)
+	annotations, err := parseKeyValues(buildAnnotations)
+	if err != nil {
+		log.Fatal("Invalid annotation: ", err)
+	}
+
+	labels, err := parseKeyValues(buildLabels)
+	if err != nil {
+		log.Fatal("Invalid label: ", err)
+	}
+
+	exposedPorts, err := parseExposedPorts(buildExpose)
+	if err != nil {
+		log.Fatal("Invalid exposed port: ", err)
+	}
+
+	if !digest.Algorithm(buildDigestAlg).Available() {
+		log.Fatalf("Unsupported digest algorithm: %s", buildDigestAlg)
+	}
+
+	if _, err := registry.ParseManifestFormat(buildManifestFmt); err != nil {
+		log.Fatal("Invalid manifest format: ", err)
+	}
+	if (buildAnnotateEP || buildSBOM) && entrypointSourcePath == "" && (buildRootFS == "" || entrypointTargetPath == "") {
+		log.Fatal("--annotate-entrypoint and --sbom require an ENTRYPOINT argument, or --rootfs with --entrypoint-path")
+	}
+	if buildSBOM && len(buildPush) == 0 {
+		log.Fatal("--sbom requires --push")
+	}
+	if buildConfigMedia != "" {
+		if _, _, err := mime.ParseMediaType(buildConfigMedia); err != nil {
+			log.Fatalf("Invalid config media type %q: %v", buildConfigMedia, err)
+		}
+		if len(buildPush) > 0 && registry.ManifestFormat(buildManifestFmt) == registry.DockerManifest {
+			log.Fatal("--config-media-type cannot be combined with --manifest-format docker")
+		}
+	}
+	if buildMaxRetries < 0 {
+		log.Fatalf("Invalid maximum retries: %d", buildMaxRetries)
+	}
+	if err := checkReportFormat(buildReportFormat); err != nil {
+		log.Fatal("Invalid report format: ", err)
+	}
+	switch buildOutputFmt {
+	case ociOutputFormat:
+	case dockerOutputFormat:
+		if len(buildPush) > 0 {
+			log.Fatal("--output-format docker cannot be combined with --push")
+		}
+		if buildConfigMedia != "" {
+			log.Fatal("--config-media-type cannot be combined with --output-format docker")
+		}
+	default:
+		log.Fatalf("Invalid output format: %q is not oci or docker", buildOutputFmt)
+	}
+	if buildOutput == stdoutArchive && buildReportFormat != "" {
+		log.Fatal("--report cannot be combined with --output -, as both write to stdout")
+	}
+
+	if len(buildPush) > 0 && !buildSkipAuthCheck {
+		if err := checkPushAuth(); err != nil {
+			log.Fatal("Auth check failed: ", err)
+		}
+	}
+
+	img, baseDigest, err := loadBaseImage(platform)
 	if err != nil {
 		log.Fatal("Unable to load base image: ", err)
 	}
+	log.Printf("Building image for platform: %s", describePlatform(img.IndexPlatform()))
+
+	if buildRmBase > 0 {
+		log.Printf("Removing %d layer(s) from base image", buildRmBase)
+		if err := img.RemoveLayers(buildRmBase); err != nil {
+			log.Fatal("Unable to remove base image layers: ", err)
+		}
+	}
 
-	log.Printf("Adding entrypoint: %s", entrypointTargetPath)
-	entrypoint, err := os.Open(entrypointSourcePath)
+	entrypointCompression, err := tarlayer.ParseCompression(buildCompression)
 	if err != nil {
-		log.Fatal("Unable to read entrypoint: ", err)
+		log.Fatal("Invalid entrypoint layer compression: ", err)
 	}
-	builder := tarlayer.NewBuilder()
-	builder.Add(entrypointTargetPath, entrypoint)
-	entrypoint.Close()
-	layer, err := builder.Finish()
+	if buildMaxLayerSize < 0 {
+		log.Fatalf("Invalid maximum layer size: %d", buildMaxLayerSize)
+	}
+
+	adds, err := parseAddSpecs(buildAdd)
 	if err != nil {
-		log.Fatal("Failed to build entrypoint layer: ", err)
+		log.Fatal("Invalid file to add: ", err)
+	}
+	if len(buildPush) == 0 {
+		if err := checkOutputPath(entrypointSourcePath, adds); err != nil {
+			log.Fatal("Invalid output path: ", err)
+		}
 	}
 
-	img.AppendLayer(layer)
-	img.Config.History = append(img.Config.History, specsv1.History{
-		Created:   now(),
-		CreatedBy: layerCreatorName,
-		Comment:   "entrypoint: " + entrypointTargetPath,
+	created := now()
+	if buildNoTimestamp {
+		created = nil
+	}
+	if buildSquashBase && len(img.Layers) > 1 {
+		log.Printf("Squashing %d base image layers", len(img.Layers))
+		if err := squashBaseLayers(&img, entrypointCompression, created); err != nil {
+			log.Fatal("Unable to squash base image layers: ", err)
+		}
+	}
+
+	// Only look up the entries of the base image that the build needs: the
+	// entrypoint, to check whether it shadows a base file, and the parent
+	// directories of every path the new layers add.
+	var baseFiles map[string]image.File
+	if len(img.Layers) > 0 {
+		lookup := parentDirectories(newLayerPaths(entrypointSourcePath, entrypointTargetPath, adds))
+		if entrypointSourcePath != "" {
+			lookup = append(lookup, entrypointTargetPath)
+		}
+		baseFiles, err = img.Lookup(context.TODO(), lookup...)
+		if err != nil {
+			log.Print("Unable to read the base image filesystem: ", err)
+		}
+	}
+	baseDirectories = make(map[string]*tar.Header)
+	for p, f := range baseFiles {
+		if f.Header.Typeflag == tar.TypeDir {
+			baseDirectories[p] = f.Header
+		}
+	}
+
+	if entrypointSourcePath != "" {
+		f, found := baseFiles[strings.TrimPrefix(entrypointTargetPath, "/")]
+		switch {
+		case found && buildStrict:
+			log.Fatalf("Entrypoint %s would shadow an existing entry in base image layer %d", entrypointTargetPath, f.Layer)
+		case found:
+			log.Printf("Warning: entrypoint %s shadows an existing entry in base image layer %d", entrypointTargetPath, f.Layer)
+		}
+	}
+
+	img.BackfillHistory()
+
+	for _, path := range buildLayers {
+		log.Printf("Adding layer: %s", path)
+		layer, err := importLayer(path)
+		if err != nil {
+			log.Fatalf("Failed to import layer from %s: %v", path, err)
+		}
+		img.AppendLayer(layer)
+		img.Config.History = append(img.Config.History, specsv1.History{
+			Created:   created,
+			CreatedBy: layerCreatorName,
+			Comment:   "layer: " + filepath.Base(path),
+		})
+	}
+
+	if buildRootFS != "" {
+		log.Printf("Adding root filesystem: %s", buildRootFS)
+		layers, err := buildRootFSLayers(buildRootFS, rootFSExclusions, entrypointCompression)
+		if err != nil {
+			log.Fatal("Failed to build root filesystem layer: ", err)
+		}
+		appendLayers(&img, layers, created, "rootfs: "+filepath.Base(filepath.Clean(buildRootFS)))
+	}
+
+	for _, add := range adds {
+		log.Printf("Adding file: %s", add.Dest)
+		layer, err := buildFileLayer(add.Source, add.Dest, add.Compression)
+		if err != nil {
+			log.Fatalf("Failed to build layer for %s: %v", add.Dest, err)
+		}
+		img.AppendLayer(layer)
+		img.Config.History = append(img.Config.History, specsv1.History{
+			Created:   created,
+			CreatedBy: layerCreatorName,
+			Comment:   "add: " + add.Dest,
+		})
+	}
+
+	// Without an entrypoint binary, the entrypoint layer only needs to exist
+	// if other flags add entries to it.
+	if entrypointSourcePath != "" || buildScaffold || buildCACerts != "" || buildWithTZData || buildWithTmp {
+		log.Printf("Adding entrypoint: %s", entrypointTargetPath)
+		entrypointLayers, err := buildEntrypointLayer(entrypointSourcePath, entrypointTargetPath, entrypointCompression)
+		if err != nil {
+			log.Fatal("Failed to build entrypoint layer: ", err)
+		}
+		appendLayers(&img, entrypointLayers, created, "entrypoint: "+entrypointTargetPath)
+	}
+
+	if buildConfigOnly {
+		img.Config.History = append(img.Config.History, specsv1.History{
+			Created:    created,
+			CreatedBy:  layerCreatorName,
+			Comment:    "config",
+			EmptyLayer: true,
+		})
+	}
+
+	img.Config.Created = created
+	if entrypointTargetPath != "" {
+		img.Config.Config.Entrypoint = []string{entrypointTargetPath}
+		img.Config.Config.Cmd = nil
+	}
+
+	err = applyConfigChanges(&img.Config, configChanges{
+		Env:          buildEnv,
+		EnvPrepend:   buildEnvPrepend,
+		EnvAppend:    buildEnvAppend,
+		Labels:       labels,
+		ExposedPorts: exposedPorts,
 	})
+	if err != nil {
+		log.Fatal("Invalid environment variable: ", err)
+	}
+	img.Config.Config.Healthcheck, err = buildHealthConfig(img.Config.Config.Healthcheck)
+	if err != nil {
+		log.Fatal("Invalid healthcheck: ", err)
+	}
 
-	img.Config.Created = now()
-	img.Config.Config.Entrypoint = []string{entrypointTargetPath}
-	img.Config.Config.Cmd = nil
+	setDefaultAnnotations(&img, baseDigest)
+	// Without an entrypoint argument, the entrypoint comes from --rootfs.
+	entrypointFile := entrypointSourcePath
+	if entrypointFile == "" {
+		entrypointFile = filepath.Join(buildRootFS, filepath.FromSlash(entrypointTargetPath))
+	}
+	if buildAnnotateEP {
+		err := setEntrypointAnnotations(&img, entrypointFile)
+		if err != nil {
+			log.Fatal("Failed to annotate entrypoint: ", err)
+		}
+	}
+	for k, v := range annotations {
+		img.Annotations[k] = v
+	}
+	img.ArtifactType = buildArtifactType
 
 	err = outputImage(img)
 	if err != nil {
 		log.Fatal("Failed to output image: ", err)
 	}
+
+	if buildSBOM {
+		log.Print("Pushing SBOM to registry")
+		if err := pushSBOM(img, entrypointFile, entrypointTargetPath); err != nil {
+			log.Fatal("Failed to push SBOM: ", err)
+		}
+	}
+
+	if buildLockfile != "" {
+		log.Printf("Writing lockfile: %s", buildLockfile)
+		err = writeLockfile(img, buildLockfile)
+		if err != nil {
+			log.Fatal("Failed to write lockfile: ", err)
+		}
+	}
+
+	if buildReportFormat == jsonReport {
+		if err := writeReport(img); err != nil {
+			log.Fatal("Failed to write report: ", err)
+		}
+	}
 }
 
+// hasLayerSource returns true if the layers of a build without an ENTRYPOINT
+// argument can come from --layer or a base image.
+func hasLayerSource() bool {
+	return len(buildLayers) > 0 || buildFrom != "" || buildFromArchive != ""
+}
+
+// configOnlyConflicts lists the build flags that change the filesystem of the
+// image, which --config-only does not allow.
+var configOnlyConflicts = []string{
+	"add",
+	"annotate-entrypoint",
+	"ca-certs",
+	"layer",
+	"max-layer-size",
+	"rm-base-layer",
+	"rootfs",
+	"sbom",
+	"scaffold",
+	"squash-base",
+	"with-tmp",
+	"with-tzdata",
+}
+
+// checkConfigOnly returns an error if the arguments and flags of a build with
+// --config-only would add content to the image, or if the build has no base
+// image or destination.
+func checkConfigOnly(flags *pflag.FlagSet, args []string) error {
+	if len(args) > 0 {
+		return errors.New("an ENTRYPOINT argument cannot be given")
+	}
+	var conflicts []string
+	for _, name := range configOnlyConflicts {
+		if flags.Changed(name) {
+			conflicts = append(conflicts, "--"+name)
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("cannot be combined with %s", strings.Join(conflicts, ", "))
+	}
+	if buildFrom == "" && buildFromArchive == "" {
+		return errors.New("a base image is required (--from or --from-archive)")
+	}
+	if buildOutput == "" && len(buildPush) == 0 {
+		return errors.New("a destination is required (--output or --push)")
+	}
+	return nil
+}
+
+// checkEntrypoint returns an error if the file at path could not work as the
+// entrypoint of an image, such as the empty output of a failed compile.
+func checkEntrypoint(path string) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !stat.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+	if stat.Size() == 0 {
+		return fmt.Errorf("%s is empty", path)
+	}
+	// Windows does not track executable permissions in file modes.
+	if runtime.GOOS != "windows" && stat.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("%s is not executable", path)
+	}
+	return nil
+}
+
+// entrypointURLName returns the name of the entrypoint downloaded from rawURL,
+// taken from the final element of its path.
+func entrypointURLName(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		return "", fmt.Errorf("%s does not name a file", rawURL)
+	}
+	return name, nil
+}
+
+// downloadEntrypoint downloads the entrypoint at rawURL to an executable
+// temporary file, and returns the file's path. If the server provides a
+// Content-Length, downloadEntrypoint checks that the full content arrived. The
+// caller must remove the file when finished with it.
+func downloadEntrypoint(ctx context.Context, rawURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("downloading %s: %s", rawURL, resp.Status)
+	}
+
+	file, err := os.CreateTemp("", "zeroimage-entrypoint-*")
+	if err != nil {
+		return "", err
+	}
+	cleanup := func() {
+		file.Close()
+		os.Remove(file.Name())
+	}
+
+	n, err := io.Copy(file, resp.Body)
+	if err != nil {
+		cleanup()
+		return "", err
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		cleanup()
+		return "", fmt.Errorf("downloading %s: received %d of %d bytes", rawURL, n, resp.ContentLength)
+	}
+	if err := file.Chmod(0755); err != nil {
+		cleanup()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// newLayerPaths returns the absolute paths that the layers built from the host
+// add explicitly, for the build's entrypoint and --add specs.
+func newLayerPaths(entrypointSourcePath, entrypointTargetPath string, adds []addSpec) []string {
+	paths := []string{"/"}
+	if entrypointSourcePath != "" {
+		paths = append(paths, entrypointTargetPath)
+	}
+	if buildScaffold {
+		paths = append(paths, "/etc/passwd", "/etc/nsswitch.conf")
+	}
+	if buildCACerts != "" {
+		paths = append(paths, caCertsTargetPath)
+	}
+	if buildWithTZData {
+		paths = append(paths, tzdataTargetPath)
+	}
+	if buildWithTmp || buildScaffold {
+		paths = append(paths, "/tmp")
+	}
+	for _, add := range adds {
+		paths = append(paths, path.Join("/", add.Dest))
+	}
+	return paths
+}
+
+// parentDirectories returns the distinct parent directories of paths, up to and
+// including the root.
+func parentDirectories(paths []string) []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, p := range paths {
+		for dir := path.Dir(p); !seen[dir]; dir = path.Dir(dir) {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// epoch is the modification time of new files with --no-timestamp. Tar
+// archives represent it as a modification time of zero.
+var epoch = time.Unix(0, 0).UTC()
+
 func now() *time.Time {
 	now := time.Now().UTC()
 	return &now
 }
 
-func loadBaseImage(platform specsv1.Platform) (image.Image, error) {
+// addSpec represents a file to add to the image in its own layer.
+type addSpec struct {
+	Source      string
+	Dest        string
+	Compression tarlayer.Compression
+}
+
+// checkOutputPath returns an error if writing the image archive to --output
+// would overwrite one of the build's input files, like the entrypoint in
+// "zeroimage build app -o app".
+func checkOutputPath(entrypointPath string, adds []addSpec) error {
+	inputs := append([]string{entrypointPath}, buildLayers...)
+	for _, add := range adds {
+		inputs = append(inputs, add.Source)
+	}
+	for _, input := range inputs {
+		if input != "" && isSameFile(buildOutput, input) {
+			return fmt.Errorf("%s would overwrite build input %s", buildOutput, input)
+		}
+	}
+	return nil
+}
+
+// isSameFile returns true if paths a and b name the same file, either through
+// the same clean absolute path or, for existing files, through links.
+func isSameFile(a, b string) bool {
+	if absA, err := filepath.Abs(a); err == nil {
+		if absB, err := filepath.Abs(b); err == nil && absA == absB {
+			return true
+		}
+	}
+	statA, errA := os.Stat(a)
+	statB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(statA, statB)
+}
+
+// parseAddSpecs parses a list of SRC:DEST[:COMPRESSION] strings, using gzip
+// compression for any layer that does not specify its compression.
+func parseAddSpecs(specs []string) ([]addSpec, error) {
+	adds := make([]addSpec, len(specs))
+	for i, spec := range specs {
+		parts := strings.Split(spec, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("%q is not of the form SRC:DEST[:COMPRESSION]", spec)
+		}
+
+		adds[i] = addSpec{
+			Source:      parts[0],
+			Dest:        "/" + strings.TrimPrefix(parts[1], "/"),
+			Compression: tarlayer.Gzip,
+		}
+		if len(parts) == 3 {
+			compression, err := tarlayer.ParseCompression(parts[2])
+			if err != nil {
+				return nil, err
+			}
+			adds[i].Compression = compression
+		}
+	}
+	return adds, nil
+}
+
+// importLayer creates a layer from an existing layer tarball on the host.
+func importLayer(path string) (image.Layer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return image.Layer{}, err
+	}
+	defer file.Close()
+
+	return tarlayer.Import(file, tarlayer.Options{
+		DigestAlgorithm: digest.Algorithm(buildDigestAlg),
+	})
+}
+
+// buildFileLayer builds a layer containing a single file from the host.
+func buildFileLayer(sourcePath, targetPath string, compression tarlayer.Compression) (image.Layer, error) {
+	file, err := os.Open(sourcePath)
+	if err != nil {
+		return image.Layer{}, err
+	}
+	defer file.Close()
+
+	builder := newLayerBuilder(compression)
+	builder.Add(targetPath, file)
+	return builder.Finish()
+}
+
+// scaffoldPasswd is the /etc/passwd file added by --scaffold, which defines
+// root along with the same unprivileged "nonroot" user as distroless images.
+const scaffoldPasswd = `root:x:0:0:root:/root:/sbin/nologin
+nobody:x:65534:65534:nobody:/nonexistent:/sbin/nologin
+nonroot:x:65532:65532:nonroot:/home/nonroot:/sbin/nologin
+`
+
+// scaffoldNSSwitch is the /etc/nsswitch.conf file added by --scaffold, which
+// directs name lookups to consult local files like /etc/hosts before DNS.
+const scaffoldNSSwitch = `passwd: files
+group: files
+hosts: files dns
+`
+
+const (
+	// hostCACerts is the value of --ca-certs when given without a path, which
+	// selects the host's CA certificate bundle.
+	hostCACerts = "host"
+
+	caCertsTargetPath = "/etc/ssl/certs/ca-certificates.crt"
+)
+
+// hostCACertsPaths lists the usual locations of the system CA certificate
+// bundle across common operating systems, in the order that we search them.
+var hostCACertsPaths = []string{
+	"/etc/ssl/certs/ca-certificates.crt",                // Debian, Ubuntu, Alpine, Arch
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem", // RHEL, CentOS, Fedora
+	"/etc/pki/tls/certs/ca-bundle.crt",                  // Older RHEL and Fedora
+	"/etc/ssl/ca-bundle.pem",                            // openSUSE
+	"/etc/ssl/cert.pem",                                 // macOS, Alpine
+}
+
+// findHostCACerts returns the path to the host's CA certificate bundle.
+func findHostCACerts() (string, error) {
+	for _, path := range hostCACertsPaths {
+		if stat, err := os.Stat(path); err == nil && stat.Mode().IsRegular() {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no CA certificate bundle in any of %s; pass a path to --ca-certs", strings.Join(hostCACertsPaths, ", "))
+}
+
+const tzdataTargetPath = "/usr/share/zoneinfo"
+
+// hostTZDataPaths lists the usual locations of the system time zone database,
+// in the order that we search them.
+var hostTZDataPaths = []string{
+	"/usr/share/zoneinfo",
+	"/usr/share/lib/zoneinfo",
+	"/usr/lib/locale/TZ",
+}
+
+// findHostTZData returns the path to the host's time zone database directory.
+func findHostTZData() (string, error) {
+	for _, path := range hostTZDataPaths {
+		if stat, err := os.Stat(path); err == nil && stat.IsDir() {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no time zone database in any of %s", strings.Join(hostTZDataPaths, ", "))
+}
+
+// appendLayers appends layers to img along with a history entry for each,
+// numbering the entries when a single step produced multiple layers.
+func appendLayers(img *image.Image, layers []image.Layer, created *time.Time, comment string) {
+	if len(layers) > 1 {
+		log.Printf("Split layer into %d layers", len(layers))
+	}
+	for i, layer := range layers {
+		layerComment := comment
+		if len(layers) > 1 {
+			layerComment += fmt.Sprintf(" (part %d of %d)", i+1, len(layers))
+		}
+		img.AppendLayer(layer)
+		img.Config.History = append(img.Config.History, specsv1.History{
+			Created:   created,
+			CreatedBy: layerCreatorName,
+			Comment:   layerComment,
+		})
+	}
+}
+
+// squashBaseLayers replaces the layers of img with a single layer containing
+// the filesystem that they produce. The existing history entries are kept as
+// empty layer entries, followed by one entry for the squashed layer.
+func squashBaseLayers(img *image.Image, compression tarlayer.Compression, created *time.Time) error {
+	layer, err := tarlayer.Squash(context.TODO(), img.Layers, tarlayer.Options{
+		DigestAlgorithm: digest.Algorithm(buildDigestAlg),
+		Compression:     compression,
+	})
+	if err != nil {
+		return err
+	}
+
+	squashed := len(img.Layers)
+	for i := range img.Config.History {
+		img.Config.History[i].EmptyLayer = true
+	}
+	img.Config.History = append(img.Config.History, specsv1.History{
+		Created:   created,
+		CreatedBy: layerCreatorName,
+		Comment:   fmt.Sprintf("squashed %d base image layers", squashed),
+	})
+
+	img.Layers = nil
+	img.Config.RootFS.DiffIDs = nil
+	img.AppendLayer(layer)
+	return nil
+}
+
+// buildRootFSLayers builds the layers containing the contents of the host
+// directory at dir, which become the root of the image's filesystem, skipping
+// any entries excluded by exclusions if it is not nil.
+//
+// When --max-layer-size is set, buildRootFSLayers may split the contents
+// across multiple layers.
+func buildRootFSLayers(dir string, exclusions *ignore.Matcher, compression tarlayer.Compression) ([]image.Layer, error) {
+	fsys := os.DirFS(dir)
+	if exclusions != nil {
+		fsys = ignore.Filter(fsys, exclusions)
+	}
+
+	builder := newSplitLayerBuilder(compression)
+	if err := builder.AddFS("/", fsys); err != nil {
+		return nil, err
+	}
+	return builder.Finish()
+}
+
+// loadRootFSExclusions returns a matcher for the patterns given by --exclude and
+// --exclude-file, or nil if there are none.
+func loadRootFSExclusions() (*ignore.Matcher, error) {
+	patterns := buildExclude
+	if buildExcludeFile != "" {
+		filePatterns, err := ignore.ReadFile(buildExcludeFile)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(filePatterns, patterns...)
+	}
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	if buildRootFS == "" {
+		return nil, errors.New("--exclude and --exclude-file require --rootfs")
+	}
+	return ignore.New(patterns)
+}
+
+// buildEntrypointLayer builds the layer containing the entrypoint from the
+// host, if sourcePath is not empty, along with any extra entries requested by
+// build flags.
+//
+// When --max-layer-size is set, buildEntrypointLayer may split these entries
+// across multiple layers.
+func buildEntrypointLayer(sourcePath, targetPath string, compression tarlayer.Compression) ([]image.Layer, error) {
+	builder := newSplitLayerBuilder(compression)
+	if sourcePath != "" {
+		file, err := os.Open(sourcePath)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		builder.Add(targetPath, file)
+	}
+	if buildScaffold {
+		builder.AddContent("/etc/passwd", []byte(scaffoldPasswd))
+		builder.AddContent("/etc/nsswitch.conf", []byte(scaffoldNSSwitch))
+	}
+	if buildCACerts != "" {
+		certs, err := os.Open(buildCACerts)
+		if err != nil {
+			return nil, err
+		}
+		defer certs.Close()
+		builder.Add(caCertsTargetPath, certs)
+	}
+	if buildWithTZData {
+		tzdataPath, err := findHostTZData()
+		if err != nil {
+			return nil, err
+		}
+		builder.AddFS(tzdataTargetPath, os.DirFS(tzdataPath))
+	}
+	if buildWithTmp || buildScaffold {
+		builder.Add("/tmp", tarbuild.Dir{
+			Mode:    fs.ModeDir | fs.ModeSticky | 0777,
+			ModTime: builder.DefaultModTime,
+		})
+	}
+	return builder.Finish()
+}
+
+// baseDirectories holds the headers of the directories in the base image's
+// filesystem, keyed by their clean relative paths, so that the parent
+// directories of new layers can match them.
+var baseDirectories map[string]*tar.Header
+
+// baseParentHeader returns the header of the directory at path in the base
+// image, if there is one. New layers use it as their ParentHeader, so that
+// applying them does not change the modes or ownership of base directories
+// (for example, the sticky bit of /tmp).
+func baseParentHeader(path string) *tar.Header {
+	return baseDirectories[path]
+}
+
+func newSplitLayerBuilder(compression tarlayer.Compression) *tarlayer.SplitBuilder {
+	builder := tarlayer.NewSplitBuilder(buildMaxLayerSize, tarlayer.Options{
+		DigestAlgorithm: digest.Algorithm(buildDigestAlg),
+		Compression:     compression,
+	})
+	builder.ParentHeader = baseParentHeader
+	if buildNoTimestamp {
+		builder.DefaultModTime = epoch
+		builder.FixedModTime = true
+	}
+	return builder
+}
+
+func newLayerBuilder(compression tarlayer.Compression) *tarlayer.Builder {
+	builder := tarlayer.NewBuilderWithOptions(tarlayer.Options{
+		DigestAlgorithm: digest.Algorithm(buildDigestAlg),
+		Compression:     compression,
+	})
+	builder.ParentHeader = baseParentHeader
+	if buildNoTimestamp {
+		builder.DefaultModTime = epoch
+		builder.FixedModTime = true
+	}
+	return builder
+}
+
+// configChanges holds the changes to an image configuration requested by the
+// environment, label, and port flags shared by the build and config commands.
+type configChanges struct {
+	// Env, EnvPrepend, and EnvAppend are KEY=VALUE strings, as accepted by
+	// mergeEnv and extendEnv.
+	Env, EnvPrepend, EnvAppend []string
+	// Labels are the labels to set, as returned by parseKeyValues.
+	Labels map[string]string
+	// ExposedPorts are the ports to expose, as returned by parseExposedPorts.
+	ExposedPorts []string
+}
+
+// applyConfigChanges applies changes to config, and returns an error if any of
+// the environment variables in changes are invalid.
+func applyConfigChanges(config *image.Config, changes configChanges) error {
+	env, err := mergeEnv(config.Config.Env, changes.Env)
+	if err == nil {
+		env, err = extendEnv(env, changes.EnvPrepend, true)
+	}
+	if err == nil {
+		env, err = extendEnv(env, changes.EnvAppend, false)
+	}
+	if err != nil {
+		return err
+	}
+	config.Config.Env = env
+
+	if len(changes.Labels) > 0 && config.Config.Labels == nil {
+		config.Config.Labels = make(map[string]string)
+	}
+	for k, v := range changes.Labels {
+		config.Config.Labels[k] = v
+	}
+	if len(changes.ExposedPorts) > 0 && config.Config.ExposedPorts == nil {
+		config.Config.ExposedPorts = make(map[string]struct{})
+	}
+	for _, port := range changes.ExposedPorts {
+		config.Config.ExposedPorts[port] = struct{}{}
+	}
+	return nil
+}
+
+// parseKeyValues parses a list of KEY=VALUE strings into a map.
+func parseKeyValues(specs []string) (map[string]string, error) {
+	annotations := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		i := strings.Index(spec, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%q is not of the form KEY=VALUE", spec)
+		}
+		annotations[spec[:i]] = spec[i+1:]
+	}
+	return annotations, nil
+}
+
+// mergeEnv returns env with each of the KEY=VALUE strings in specs added to it,
+// replacing any existing variables with the same key.
+func mergeEnv(env []string, specs []string) ([]string, error) {
+	for _, spec := range specs {
+		i := strings.Index(spec, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%q is not of the form KEY=VALUE", spec)
+		}
+		key := spec[:i+1]
+
+		replaced := false
+		for j := range env {
+			if strings.HasPrefix(env[j], key) {
+				env[j] = spec
+				replaced = true
+			}
+		}
+		if !replaced {
+			env = append(env, spec)
+		}
+	}
+	return env, nil
+}
+
+// defaultPath is the PATH that common container runtimes set for processes in
+// images whose configuration does not set one.
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// extendEnv returns env with the value of each of the KEY=VALUE strings in specs
+// joined to the existing value of KEY with a colon, at the start of the existing
+// value if prepend is true or at the end otherwise. A KEY that env does not set,
+// or sets to an empty value, is set to VALUE alone, except that an unset PATH is
+// first given the runtime default so that extending it does not hide the usual
+// system directories.
+func extendEnv(env []string, specs []string, prepend bool) ([]string, error) {
+	for _, spec := range specs {
+		i := strings.Index(spec, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%q is not of the form KEY=VALUE", spec)
+		}
+		key, value := spec[:i], spec[i+1:]
+
+		existing, ok := lookupEnv(env, key)
+		if !ok && key == "PATH" {
+			existing = defaultPath
+		}
+		switch {
+		case existing == "":
+		case prepend:
+			value = value + ":" + existing
+		default:
+			value = existing + ":" + value
+		}
+
+		var err error
+		if env, err = mergeEnv(env, []string{key + "=" + value}); err != nil {
+			return nil, err
+		}
+	}
+	return env, nil
+}
+
+// lookupEnv returns the value of the variable named key in env, and whether env
+// sets it at all.
+func lookupEnv(env []string, key string) (value string, ok bool) {
+	for _, entry := range env {
+		if v := strings.TrimPrefix(entry, key+"="); v != entry {
+			value, ok = v, true
+		}
+	}
+	return value, ok
+}
+
+// buildHealthConfig returns the healthcheck for the image, by applying any
+// healthcheck flags to the healthcheck inherited from the base image.
+func buildHealthConfig(base *image.HealthConfig) (*image.HealthConfig, error) {
+	if buildHealthCmd == "" && buildHealthInterval == 0 && buildHealthTimeout == 0 &&
+		buildHealthStartPeriod == 0 && buildHealthRetries == 0 {
+		return base, nil
+	}
+
+	var hc image.HealthConfig
+	if base != nil {
+		hc = *base
+	}
+
+	if buildHealthCmd != "" {
+		test, err := parseHealthcheckCmd(buildHealthCmd)
+		if err != nil {
+			return nil, err
+		}
+		hc.Test = test
+	}
+	if len(hc.Test) == 0 {
+		return nil, errors.New("--healthcheck-cmd is required without a healthcheck from the base image")
+	}
+
+	if buildHealthInterval != 0 {
+		hc.Interval = buildHealthInterval
+	}
+	if buildHealthTimeout != 0 {
+		hc.Timeout = buildHealthTimeout
+	}
+	if buildHealthStartPeriod != 0 {
+		hc.StartPeriod = buildHealthStartPeriod
+	}
+	if buildHealthRetries != 0 {
+		hc.Retries = buildHealthRetries
+	}
+	return &hc, nil
+}
+
+// parseHealthcheckCmd parses the value of --healthcheck-cmd into the "Test"
+// value of a healthcheck, following the forms of the Dockerfile HEALTHCHECK
+// instruction. Because zeroimage images often lack a shell, the JSON array
+// form is usually the one that works.
+func parseHealthcheckCmd(cmd string) ([]string, error) {
+	if strings.EqualFold(cmd, "none") {
+		return []string{"NONE"}, nil
+	}
+	if !strings.HasPrefix(strings.TrimSpace(cmd), "[") {
+		return []string{"CMD-SHELL", cmd}, nil
+	}
+
+	var args []string
+	if err := json.Unmarshal([]byte(cmd), &args); err != nil {
+		return nil, fmt.Errorf("%q is not a JSON array of strings", cmd)
+	}
+	if len(args) == 0 {
+		return nil, errors.New("healthcheck command must not be empty")
+	}
+	return append([]string{"CMD"}, args...), nil
+}
+
+// parseExposedPorts parses a list of PORT[/PROTOCOL] strings into the form used
+// by the image configuration, defaulting to TCP when no protocol is given.
+func parseExposedPorts(specs []string) ([]string, error) {
+	ports := make([]string, len(specs))
+	for i, spec := range specs {
+		port, protocol := spec, "tcp"
+		if j := strings.Index(spec, "/"); j >= 0 {
+			port, protocol = spec[:j], strings.ToLower(spec[j+1:])
+		}
+		if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+			return nil, fmt.Errorf("%q does not have a valid port number", spec)
+		}
+		switch protocol {
+		case "tcp", "udp", "sctp":
+		default:
+			return nil, fmt.Errorf("%q does not have a valid protocol (tcp, udp, or sctp)", spec)
+		}
+		ports[i] = port + "/" + protocol
+	}
+	return ports, nil
+}
+
+// setDefaultAnnotations replaces any standard OCI annotations inherited from
+// the base image's manifest with values describing the new image.
+func setDefaultAnnotations(img *image.Image, baseDigest digest.Digest) {
+	if img.Annotations == nil {
+		img.Annotations = make(map[string]string)
+	}
+
+	delete(img.Annotations, specsv1.AnnotationBaseImageName)
+	delete(img.Annotations, specsv1.AnnotationBaseImageDigest)
+	delete(img.Annotations, annotationEntrypointSHA256)
+	delete(img.Annotations, annotationEntrypointGoVersion)
+
+	setCreatedAnnotation(img)
+	if isRegistryBase() {
+		img.Annotations[specsv1.AnnotationBaseImageName] = buildFrom
+	}
+	if baseDigest != "" {
+		img.Annotations[specsv1.AnnotationBaseImageDigest] = baseDigest.String()
+	}
+}
+
+// setCreatedAnnotation sets the standard OCI creation time annotation of img to
+// match the creation time in its configuration, or removes the annotation if
+// the configuration has no creation time.
+func setCreatedAnnotation(img *image.Image) {
+	if img.Config.Created == nil {
+		delete(img.Annotations, specsv1.AnnotationCreated)
+		return
+	}
+	if img.Annotations == nil {
+		img.Annotations = make(map[string]string)
+	}
+	img.Annotations[specsv1.AnnotationCreated] = img.Config.Created.Format(time.RFC3339)
+}
+
+// Annotations describing the entrypoint binary, set by --annotate-entrypoint.
+const (
+	annotationEntrypointSHA256    = "co.alexhamlin.zeroimage.entrypoint.sha256"
+	annotationEntrypointGoVersion = "co.alexhamlin.zeroimage.entrypoint.goversion"
+)
+
+// setEntrypointAnnotations records the SHA-256 digest of the entrypoint binary
+// and, if it is a Go binary, the version of Go that built it.
+func setEntrypointAnnotations(img *image.Image, entrypointPath string) error {
+	file, err := os.Open(entrypointPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	dgst, err := digest.SHA256.FromReader(file)
+	if err != nil {
+		return err
+	}
+	img.Annotations[annotationEntrypointSHA256] = dgst.Encoded()
+
+	// buildinfo.Read fails for binaries that Go did not build, which we expect
+	// for entrypoints written in other languages.
+	if info, err := buildinfo.Read(file); err == nil {
+		img.Annotations[annotationEntrypointGoVersion] = info.GoVersion
+	}
+	return nil
+}
+
+// loadBaseImage returns the base image for the build, along with the digest of
+// its manifest. When building without a base image, the digest is empty.
+func loadBaseImage(platform specsv1.Platform) (image.Image, digest.Digest, error) {
 	if buildFromArchive == "" && buildFrom == "" {
 		var img image.Image
 		img.SetPlatform(platform)
-		return img, nil
+		return img, "", nil
 	}
 
 	var (
-		index image.Index
-		err   error
+		index        image.Index
+		manifestDgst digest.Digest
+		err          error
 	)
 	if buildFromArchive != "" {
-		index, err = loadBaseFromArchive()
+		index, manifestDgst, err = loadBaseFromArchive()
 	}
-	if buildFrom != "" {
+	switch {
+	case strings.HasPrefix(buildFrom, containerdReferencePrefix):
+		index, err = loadBaseFromContainerd()
+	case strings.HasPrefix(buildFrom, dockerDaemonReferencePrefix):
+		index, err = loadBaseFromDockerDaemon()
+	case buildFrom != "":
 		index, err = loadBaseFromRegistry()
 	}
 	if err != nil {
-		return image.Image{}, err
+		return image.Image{}, "", err
 	}
 
-	index = index.SelectByPlatform(platform)
-	if len(index) == 0 {
-		return image.Image{}, fmt.Errorf("image does not support %s", platforms.Format(platform))
+	if manifestDgst != "" {
+		index = index.SelectByDigest(manifestDgst)
+		if len(index) == 0 {
+			return image.Image{}, "", fmt.Errorf("image does not contain manifest %s", manifestDgst)
+		}
+		log.Printf("Selecting base image manifest: %s (%s)", manifestDgst, describePlatform(index[0].Platform))
+	} else {
+		entry, err := selectPlatform("base image", index, platform)
+		if err != nil {
+			return image.Image{}, "", err
+		}
+		index = image.Index{entry}
+		log.Printf("Selecting base image platform: %s", describePlatform(index[0].Platform))
 	}
 
-	log.Printf("Selecting base image platform: %s", platforms.Format(index[0].Platform))
-	return index[0].GetImage(context.TODO())
+	img, err := index[0].GetImage(context.TODO())
+	if err != nil {
+		return image.Image{}, "", err
+	}
+	if img.Platform.OS == "" && img.Platform.Architecture == "" {
+		log.Printf("Base image does not specify a platform; assuming %s", platforms.Format(platform))
+		img.SetPlatform(platform)
+	}
+	return img, index[0].Digest, nil
 }
 
-func loadBaseFromArchive() (image.Index, error) {
-	log.Printf("Loading base image archive: %s", buildFromArchive)
+func loadBaseFromArchive() (image.Index, digest.Digest, error) {
+	archivePath, manifestDgst, err := splitArchiveDigest(buildFromArchive)
+	if err != nil {
+		return nil, "", err
+	}
+
+	log.Printf("Loading base image archive: %s", archivePath)
 
-	base, err := os.Open(buildFromArchive)
+	base, err := openArchive(archivePath)
 	if err != nil {
 		log.Fatal("Unable to load base archive: ", err)
 	}
 	defer base.Close()
 
-	return ociarchive.Load(base)
+	index, err := loadArchive(base)
+	return index, manifestDgst, err
+}
+
+// splitArchiveDigest splits an archive path with an optional "@DIGEST" suffix
+// into its component parts. The returned digest is empty if no suffix was
+// provided.
+func splitArchiveDigest(archive string) (string, digest.Digest, error) {
+	i := strings.LastIndex(archive, "@")
+	if i < 0 || !strings.Contains(archive[i+1:], ":") || strings.Contains(archive[i+1:], "/") {
+		return archive, "", nil
+	}
+
+	dgst, err := digest.Parse(archive[i+1:])
+	if err != nil {
+		return "", "", fmt.Errorf("invalid manifest digest in %q: %w", archive, err)
+	}
+	return archive[:i], dgst, nil
+}
+
+// buildAuthReferences returns the references whose registries receive the
+// credentials given by --username or --registry-token: those of --push, or of
+// --from if the image is not pushed.
+func buildAuthReferences() []string {
+	if len(buildPush) > 0 {
+		return buildPush
+	}
+	return []string{buildFrom}
+}
+
+// checkPushAuth checks that the build can push to each repository in --push, so
+// that a build with missing or insufficient credentials fails before doing any
+// work.
+func checkPushAuth() error {
+	client := newRegistryClient(buildAuthReferences()...)
+	checked := make(map[string]bool)
+	for _, reference := range buildPush {
+		// References that do not parse here, like those for registries on Unix
+		// sockets, are checked on their own.
+		repository := reference
+		if ref, err := name.ParseReference(reference); err == nil {
+			repository = ref.Context().Name()
+		}
+		if checked[repository] {
+			continue
+		}
+		checked[repository] = true
+
+		log.Printf("Checking push access: %s", repository)
+		if err := client.CheckPushAuth(context.TODO(), reference); err != nil {
+			return fmt.Errorf("not authorized to push to %s: %w", repository, err)
+		}
+	}
+	return nil
+}
+
+// isRegistryBase returns true if --from names a base image in a remote
+// registry, rather than one stored on the local host.
+func isRegistryBase() bool {
+	return buildFrom != "" &&
+		!strings.HasPrefix(buildFrom, containerdReferencePrefix) &&
+		!strings.HasPrefix(buildFrom, dockerDaemonReferencePrefix)
 }
 
 func loadBaseFromRegistry() (image.Index, error) {
 	log.Printf("Loading base image from registry: %s", buildFrom)
-	return registry.Load(context.TODO(), buildFrom)
+	return newRegistryClient(buildAuthReferences()...).LoadWithOptions(context.TODO(), buildFrom, registry.LoadOptions{Concurrency: pullConcurrency})
+}
+
+// containerdReferencePrefix marks a --from reference to an image in the content
+// store of a local containerd daemon, like "containerd://sha256:..." for a
+// daemon with the default root directory, or
+// "containerd:///data/containerd@sha256:..." for another root directory.
+const containerdReferencePrefix = "containerd://"
+
+func loadBaseFromContainerd() (image.Index, error) {
+	ref := strings.TrimPrefix(buildFrom, containerdReferencePrefix)
+	root, rawDigest := containerdstore.DefaultRoot, ref
+	if i := strings.LastIndex(ref, "@"); i >= 0 {
+		root, rawDigest = ref[:i], ref[i+1:]
+	}
+	dgst, err := digest.Parse(rawDigest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid digest in %q: %w", buildFrom, err)
+	}
+
+	log.Printf("Loading base image from containerd content store: %s", root)
+	return containerdstore.Load(context.TODO(), root, dgst)
 }
 
 func outputImage(img image.Image) error {
-	if buildPush != "" {
+	if len(buildPush) > 0 {
 		return outputImageToRegistry(img)
 	}
 	return outputImageToArchive(img)
 }
 
 func outputImageToRegistry(img image.Image) error {
-	log.Printf("Pushing image to registry: %s", buildPush)
-	return registry.PushImage(context.TODO(), img, buildPush)
+	log.Printf("Pushing image to registry: %s", strings.Join(buildPush, ", "))
+
+	var (
+		mu                               sync.Mutex
+		pushed, skipped, retried, failed int
+	)
+	opts := buildPushOptions()
+	opts.BlobPushed = func(result registry.BlobResult) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		pushed++
+		switch {
+		case result.Err != nil:
+			failed++
+			log.Printf("Failed to push blob %s to %s after %d retries: %v", result.Descriptor.Digest, result.Repository, result.Retries, result.Err)
+		case result.Skipped:
+			skipped++
+		case result.Retries > 0:
+			log.Printf("Pushed blob %s to %s after %d retries", result.Descriptor.Digest, result.Repository, result.Retries)
+		}
+		if result.Retries > 0 {
+			retried++
+		}
+	}
+	opts.ManifestPushed = func(result registry.ManifestResult) {
+		if result.Skipped {
+			log.Printf("Manifest %s already present at %s", result.Descriptor.Digest, result.Tag)
+		}
+	}
+	err := newRegistryClient(buildAuthReferences()...).PushImageToTags(context.TODO(), img, buildPush, opts)
+	log.Printf("Pushed %d blob(s): %d already present, %d retried, %d failed", pushed, skipped, retried, failed)
+	return err
 }
 
+// buildPushOptions returns the options for pushing the built image to the
+// registry, as selected by flags.
+func buildPushOptions() registry.PushOptions {
+	return registry.PushOptions{
+		DigestAlgorithm: digest.Algorithm(buildDigestAlg),
+		ManifestFormat:  registry.ManifestFormat(buildManifestFmt),
+		ConfigMediaType: buildConfigMedia,
+		MaxRetries:      pushMaxRetries(),
+		AlwaysUpload:    buildAlwaysUpload,
+	}
+}
+
+// buildWriteOptions returns the options for writing the built image to an OCI
+// archive, as selected by flags.
+func buildWriteOptions() ociarchive.WriteOptions {
+	return ociarchive.WriteOptions{
+		DigestAlgorithm: digest.Algorithm(buildDigestAlg),
+		RefName:         buildRefName,
+		ConfigMediaType: buildConfigMedia,
+	}
+}
+
+// outputManifest returns the manifest of img as outputImage writes it, along
+// with its descriptor. Docker archives have no manifest, so the manifest of an
+// OCI archive stands in for them.
+func outputManifest(img image.Image) (image.Manifest, specsv1.Descriptor, error) {
+	if len(buildPush) > 0 {
+		return registry.ManifestDescriptor(img, buildPushOptions())
+	}
+	return ociarchive.ManifestDescriptor(img, buildWriteOptions())
+}
+
+// pushMaxRetries returns the value of --max-retries in the form expected by
+// PushOptions, which treats zero retries as a request for the default.
+func pushMaxRetries() int {
+	if buildMaxRetries == 0 {
+		return -1
+	}
+	return buildMaxRetries
+}
+
+// Values of --output-format.
+const (
+	ociOutputFormat    = "oci"
+	dockerOutputFormat = "docker"
+)
+
+// stdoutArchive is the --output path that selects standard output as the
+// destination of the image archive.
+const stdoutArchive = "-"
+
 func outputImageToArchive(img image.Image) error {
-	log.Printf("Writing image archive: %s", buildOutput)
-	output, err := os.Create(buildOutput)
-	if err != nil {
-		return err
+	return writeImageArchive(img, buildOutput, buildOutputFmt, buildWriteOptions())
+}
+
+// writeImageArchive writes img to an archive at path, or to standard output if
+// path is stdoutArchive, in the provided --output-format. With the Docker
+// format, the RefName in opts is the tag that "docker load" applies, and the
+// other options do not apply.
+func writeImageArchive(img image.Image, path, format string, opts ociarchive.WriteOptions) error {
+	output := os.Stdout
+	if path == stdoutArchive {
+		log.Print("Writing image archive to stdout")
+	} else {
+		log.Printf("Writing image archive: %s", path)
+		var err error
+		output, err = os.Create(path)
+		if err != nil {
+			return err
+		}
 	}
-	if err := ociarchive.WriteImage(img, output); err != nil {
+
+	var err error
+	if format == dockerOutputFormat {
+		var dockerOpts dockerarchive.WriteOptions
+		if opts.RefName != "" {
+			dockerOpts.RepoTags = []string{opts.RefName}
+		}
+		err = dockerarchive.WriteImageWithOptions(img, output, dockerOpts)
+	} else {
+		err = ociarchive.WriteImageWithOptions(img, output, opts)
+	}
+	if err != nil {
 		return err
 	}
 	return output.Close()
@@ -2,8 +2,16 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"log"
+	"net"
+	"net/http"
+	"os"
 
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/opencontainers/go-digest"
 	"github.com/spf13/cobra"
 
 	"go.alexhamlin.co/zeroimage/internal/registry"
@@ -12,29 +20,143 @@ import (
 var checkAuthCmd = &cobra.Command{
 	Use:   "check-auth [flags] IMAGE",
 	Short: "Check the current permissions for a remote registry",
-	Args:  cobra.ExactArgs(1),
-	Run:   runCheckAuth,
+	Long: `Check the current permissions for a remote registry.
+
+--pull checks that the manifest of IMAGE can be read, and reports the number of
+platforms when IMAGE is an image index. --push checks that blobs can be uploaded
+to the repository of IMAGE, which does not depend on whether IMAGE names an
+index. When a check fails, the exit status identifies the kind of failure:
+
+  1  any other failure
+  2  the registry denied access with the current credentials
+  3  the registry could not be reached
+  4  IMAGE is not a valid reference, or does not name an existing image`,
+	Args: cobra.ExactArgs(1),
+	Run:  runCheckAuth,
 }
 
 var (
+	checkAuthPull bool
 	checkAuthPush bool
+	checkAuthJSON bool
+)
+
+// Exit codes of check-auth for each kind of failure.
+const (
+	checkAuthExitFailed    = 1
+	checkAuthExitDenied    = 2
+	checkAuthExitNetwork   = 3
+	checkAuthExitReference = 4
 )
 
 func init() {
 	rootCmd.AddCommand(checkAuthCmd)
 
+	checkAuthCmd.Flags().BoolVar(&checkAuthPull, "pull", false, "Check that the image can be pulled")
 	checkAuthCmd.Flags().BoolVar(&checkAuthPush, "push", false, "Check that the image can be pushed")
+	checkAuthCmd.Flags().BoolVar(&checkAuthJSON, "json", false, "Print the result as a JSON object on stdout")
+}
+
+// checkAuthResult is the structure printed by check-auth in JSON mode.
+type checkAuthResult struct {
+	Reference string `json:"reference"`
+	// Push and Pull are the results of each check, and are omitted for checks
+	// that were not requested or did not run because an earlier check failed.
+	Push *bool `json:"push,omitempty"`
+	Pull *bool `json:"pull,omitempty"`
+	// Platforms is the number of platforms in the image index named by the
+	// reference, if the pull check found an index.
+	Platforms int    `json:"platforms,omitempty"`
+	Error     string `json:"error,omitempty"`
 }
 
 func runCheckAuth(_ *cobra.Command, args []string) {
-	if !checkAuthPush {
+	if !checkAuthPull && !checkAuthPush {
 		log.Fatal("Must provide at least one scope to check")
 	}
 
-	err := registry.CheckPushAuth(context.Background(), args[0])
+	reference := args[0]
+	client := newRegistryClient(reference)
+	result := checkAuthResult{Reference: reference}
+
+	var err error
+	if checkAuthPull {
+		result.Platforms, err = client.CheckPullAuth(context.Background(), reference, registry.LoadOptions{DialRetryWait: -1})
+		result.Pull = checkAuthPassed(err)
+	}
+	if err == nil && checkAuthPush {
+		err = client.CheckPushAuth(context.Background(), reference)
+		result.Push = checkAuthPassed(err)
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	if checkAuthJSON {
+		if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
+			log.Fatal("Unable to write result: ", err)
+		}
+	} else if err != nil {
+		log.Print("Auth check failed: ", err)
+	}
 	if err != nil {
-		// TODO: Separate different kinds of errors with different exit codes.
-		log.Fatal("Auth check failed: ", err)
+		os.Exit(checkAuthExitCode(err))
+	}
+
+	if !checkAuthJSON {
+		switch {
+		case checkAuthPull && result.Platforms == 1:
+			log.Printf("Verified pull access for %s, an image index with 1 platform", reference)
+		case checkAuthPull && result.Platforms > 0:
+			log.Printf("Verified pull access for %s, an image index with %d platforms", reference, result.Platforms)
+		case checkAuthPull:
+			log.Print("Verified pull access for ", reference)
+		}
+		if checkAuthPush {
+			log.Print("Verified push access for ", reference)
+		}
+	}
+}
+
+// checkAuthPassed returns a pointer to the result of a check that failed with
+// err, for use in checkAuthResult.
+func checkAuthPassed(err error) *bool {
+	passed := err == nil
+	return &passed
+}
+
+// checkAuthExitCode returns the exit code of check-auth for a failed check.
+func checkAuthExitCode(err error) int {
+	var transportErr *transport.Error
+	if errors.As(err, &transportErr) {
+		switch transportErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return checkAuthExitDenied
+		case http.StatusNotFound:
+			return checkAuthExitReference
+		}
+		for _, diag := range transportErr.Errors {
+			if diag.Code == transport.UnauthorizedErrorCode || diag.Code == transport.DeniedErrorCode {
+				return checkAuthExitDenied
+			}
+		}
+		return checkAuthExitFailed
+	}
+
+	var (
+		opErr  *net.OpError
+		dnsErr *net.DNSError
+		netErr net.Error
+	)
+	if errors.As(err, &opErr) || errors.As(err, &dnsErr) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return checkAuthExitNetwork
+	}
+
+	if name.IsErrBadName(err) ||
+		errors.Is(err, digest.ErrDigestInvalidFormat) ||
+		errors.Is(err, digest.ErrDigestInvalidLength) ||
+		errors.Is(err, digest.ErrDigestUnsupported) {
+		return checkAuthExitReference
 	}
-	log.Print("Verified push access for ", args[0])
+	return checkAuthExitFailed
 }
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestCheckAuthExitCode(t *testing.T) {
+	_, badName := name.ParseReference("Not A Reference!")
+	dialErr := &url.Error{Op: "Get", URL: "https://registry.invalid/v2/", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+
+	testCases := []struct {
+		Description string
+		Err         error
+		Want        int
+	}{
+		{"unauthorized", &transport.Error{StatusCode: http.StatusUnauthorized}, checkAuthExitDenied},
+		{"forbidden", fmt.Errorf("pushing: %w", &transport.Error{StatusCode: http.StatusForbidden}), checkAuthExitDenied},
+		{"denied code", &transport.Error{StatusCode: http.StatusBadRequest, Errors: []transport.Diagnostic{{Code: transport.DeniedErrorCode}}}, checkAuthExitDenied},
+		{"not found", &transport.Error{StatusCode: http.StatusNotFound}, checkAuthExitReference},
+		{"server error", &transport.Error{StatusCode: http.StatusInternalServerError}, checkAuthExitFailed},
+		{"dial error", dialErr, checkAuthExitNetwork},
+		{"dns error", &net.DNSError{Err: "no such host", Name: "registry.invalid"}, checkAuthExitNetwork},
+		{"bad name", badName, checkAuthExitReference},
+		{"bad digest", fmt.Errorf("parsing: %w", digest.ErrDigestInvalidLength), checkAuthExitReference},
+		{"other", errors.New("something else"), checkAuthExitFailed},
+	}
+	for _, tc := range testCases {
+		if got := checkAuthExitCode(tc.Err); got != tc.Want {
+			t.Errorf("%s: got exit code %d, want %d", tc.Description, got, tc.Want)
+		}
+	}
+}
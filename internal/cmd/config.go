@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+// configEntrypointKey is the key of a build config file that sets the
+// ENTRYPOINT argument, as opposed to the value of a flag.
+const configEntrypointKey = "entrypoint"
+
+// applyBuildConfig sets build flags from the YAML or JSON config file at path,
+// and returns the build command's arguments with any entrypoint from the file.
+//
+// The config file is a single YAML mapping or JSON object whose keys are the
+// long names of build flags, plus "entrypoint" for the ENTRYPOINT argument.
+// Values may be strings, numbers, or booleans, or arrays of these for
+// repeatable flags. A flag or argument given on the command line takes
+// precedence over the config file, which in turn takes precedence over the
+// flag's default. For repeatable flags, any values on the command line replace
+// all of those in the config file.
+func applyBuildConfig(flags *pflag.FlagSet, path string, args []string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON is a subset of YAML, so converting the file to JSON handles both, and
+	// leaves the rest of the parsing to encoding/json.
+	content, err = yaml.YAMLToJSON(content)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	for key, raw := range config {
+		values, err := parseConfigValues(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %q: %w", path, key, err)
+		}
+
+		if key == configEntrypointKey {
+			if len(values) != 1 {
+				return nil, fmt.Errorf("%s: key %q must have a single value", path, key)
+			}
+			if len(args) == 0 {
+				args = []string{values[0]}
+			}
+			continue
+		}
+
+		flag := flags.Lookup(key)
+		if flag == nil || key == "config" {
+			return nil, fmt.Errorf("%s: unknown key %q", path, key)
+		}
+		if flag.Changed {
+			continue
+		}
+		for _, value := range values {
+			if err := flags.Set(key, value); err != nil {
+				return nil, fmt.Errorf("%s: key %q: %w", path, key, err)
+			}
+		}
+	}
+
+	return args, nil
+}
+
+// parseConfigValues returns the flag values represented by a single JSON value
+// or an array of JSON values.
+func parseConfigValues(raw json.RawMessage) ([]string, error) {
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err != nil {
+		value, err := parseConfigValue(raw)
+		if err != nil {
+			return nil, err
+		}
+		return []string{value}, nil
+	}
+
+	values := make([]string, len(list))
+	for i, raw := range list {
+		value, err := parseConfigValue(raw)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func parseConfigValue(raw json.RawMessage) (string, error) {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", err
+	}
+
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	default:
+		return "", errors.New("value must be a string, number, boolean, or array of these")
+	}
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/pflag"
+)
+
+// newTestBuildFlags returns a flag set with a few of the kinds of flags that
+// the build command has, along with the variables they set.
+func newTestBuildFlags() (*pflag.FlagSet, *string, *[]string, *[]string, *bool, *int) {
+	flags := pflag.NewFlagSet("build", pflag.ContinueOnError)
+	from := flags.String("from", "", "")
+	env := flags.StringArray("env", nil, "")
+	expose := flags.StringArray("expose", nil, "")
+	strict := flags.Bool("strict", false, "")
+	retries := flags.Int("max-retries", 0, "")
+	flags.String("config", "", "")
+	return flags, from, env, expose, strict, retries
+}
+
+func TestApplyBuildConfig(t *testing.T) {
+	flags, from, env, expose, strict, retries := newTestBuildFlags()
+	args, err := applyBuildConfig(flags, filepath.Join("testdata", "zeroimage.yaml"), nil)
+	if err != nil {
+		t.Fatalf("failed to apply config: %v", err)
+	}
+
+	if diff := cmp.Diff([]string{"some-program"}, args); diff != "" {
+		t.Errorf("unexpected args (-want +got):\n%s", diff)
+	}
+	if *from != "gcr.io/distroless/static:latest" {
+		t.Errorf("got from %q", *from)
+	}
+	if diff := cmp.Diff([]string{"LOG_LEVEL=info", "MODE=prod"}, *env); diff != "" {
+		t.Errorf("unexpected env (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"8080", "9090/udp"}, *expose); diff != "" {
+		t.Errorf("unexpected expose (-want +got):\n%s", diff)
+	}
+	if !*strict || *retries != 3 {
+		t.Errorf("got strict %v and max-retries %d, want true and 3", *strict, *retries)
+	}
+}
+
+func TestApplyBuildConfigJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zeroimage.json")
+	content := `{"entrypoint": "some-program", "env": ["A=1"], "strict": true}`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	flags, _, env, _, strict, _ := newTestBuildFlags()
+	args, err := applyBuildConfig(flags, path, nil)
+	if err != nil {
+		t.Fatalf("failed to apply config: %v", err)
+	}
+	if len(args) != 1 || args[0] != "some-program" || !*strict {
+		t.Errorf("got args %v and strict %v", args, *strict)
+	}
+	if diff := cmp.Diff([]string{"A=1"}, *env); diff != "" {
+		t.Errorf("unexpected env (-want +got):\n%s", diff)
+	}
+}
+
+func TestApplyBuildConfigPrecedence(t *testing.T) {
+	flags, from, env, expose, _, _ := newTestBuildFlags()
+	if err := flags.Parse([]string{"--from", "example.com/base", "--env", "ONLY=cli"}); err != nil {
+		t.Fatal(err)
+	}
+
+	args, err := applyBuildConfig(flags, filepath.Join("testdata", "zeroimage.yaml"), []string{"other-program"})
+	if err != nil {
+		t.Fatalf("failed to apply config: %v", err)
+	}
+	if diff := cmp.Diff([]string{"other-program"}, args); diff != "" {
+		t.Errorf("unexpected args (-want +got):\n%s", diff)
+	}
+	if *from != "example.com/base" {
+		t.Errorf("got from %q, want the command line value", *from)
+	}
+	if diff := cmp.Diff([]string{"ONLY=cli"}, *env); diff != "" {
+		t.Errorf("unexpected env (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"8080", "9090/udp"}, *expose); diff != "" {
+		t.Errorf("unexpected expose (-want +got):\n%s", diff)
+	}
+}
+
+func TestApplyBuildConfigErrors(t *testing.T) {
+	testCases := []struct {
+		Description string
+		Content     string
+	}{
+		{"unknown key", "from: example.com/base\nno-such-flag: true\n"},
+		{"config key", "config: other.yaml\n"},
+		{"nested value", "env:\n  LOG_LEVEL: info\n"},
+		{"multiple entrypoints", "entrypoint: [a, b]\n"},
+		{"invalid YAML", "from: [unterminated\n"},
+		{"not a mapping", "- from\n"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Description, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "zeroimage.yaml")
+			if err := os.WriteFile(path, []byte(tc.Content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			flags, _, _, _, _, _ := newTestBuildFlags()
+			if _, err := applyBuildConfig(flags, path, nil); err == nil {
+				t.Error("applied invalid config without error")
+			}
+		})
+	}
+}
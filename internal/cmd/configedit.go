@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"log"
+	"path"
+
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/zeroimage/internal/ociarchive"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config [flags] IMAGE",
+	Short: "Change the configuration of an existing image",
+	Long: `Change the configuration of an existing image.
+
+IMAGE is the path to an image archive, "-" to read an archive from standard
+input, or a reference to an image in a remote registry. The image that best
+matches --platform is loaded, the configuration changes requested by flags are
+applied, and the result is written to a new image archive. The layers of the
+image are copied as they are, so no entrypoint binary or other build inputs are
+needed.
+
+The flags follow the semantics of the build flags of the same names, with
+--set-env in place of build's --env and --entrypoint in place of
+--entrypoint-path. Setting --entrypoint clears any CMD arguments inherited
+from the image. The new image's creation time replaces any
+org.opencontainers.image.created annotation inherited from IMAGE.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runConfig,
+}
+
+var (
+	configPlatform   string
+	configOutput     string
+	configEntrypoint string
+	configEnv        []string
+	configEnvPrepend []string
+	configEnvAppend  []string
+	configLabels     []string
+	configExpose     []string
+	configOutputFmt  string
+	configRefName    string
+	configDigestAlg  string
+	configAnnotation []string
+)
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+
+	configCmd.Flags().StringVar(&configPlatform, "platform", defaultPlatform, "Select the desired platform for the image")
+	configCmd.Flags().StringVarP(&configOutput, "output", "o", "", "Write the new image archive to this path, or - for stdout (required)")
+	configCmd.Flags().StringVar(&configOutputFmt, "output-format", ociOutputFormat, "Write the image archive in this format (oci, or docker for docker load)")
+	configCmd.Flags().StringVar(&configEntrypoint, "entrypoint", "", "Run the program at this path in the image")
+	configCmd.Flags().StringArrayVar(&configEnv, "set-env", nil, "Set an environment variable in the image (KEY=VALUE, repeatable)")
+	configCmd.Flags().StringArrayVar(&configEnvPrepend, "env-prepend", nil, "Add VALUE to the start of a colon-separated environment variable like PATH (KEY=VALUE, repeatable)")
+	configCmd.Flags().StringArrayVar(&configEnvAppend, "env-append", nil, "Add VALUE to the end of a colon-separated environment variable like PATH (KEY=VALUE, repeatable)")
+	configCmd.Flags().StringArrayVar(&configLabels, "label", nil, "Set a label in the image configuration (KEY=VALUE, repeatable)")
+	configCmd.Flags().StringArrayVar(&configExpose, "expose", nil, "Expose a port from the image (PORT[/PROTOCOL], repeatable)")
+	configCmd.Flags().StringArrayVar(&configAnnotation, "annotation", nil, "Set an annotation on the image manifest (KEY=VALUE, repeatable)")
+	configCmd.Flags().StringVar(&configRefName, "ref-name", "", "Name the image in the archive's index with an org.opencontainers.image.ref.name annotation (e.g. latest), or with --output-format docker, the tag that docker load applies (e.g. app:latest)")
+	configCmd.Flags().StringVar(&configDigestAlg, "digest-algorithm", string(digest.Canonical), "Use this algorithm (sha256, sha384, or sha512) for new blob digests")
+	configCmd.MarkFlagRequired("output")
+}
+
+func runConfig(_ *cobra.Command, args []string) {
+	platform, err := parsePlatform(configPlatform)
+	if err != nil {
+		log.Fatal("Could not parse target platform: ", err)
+	}
+	labels, err := parseKeyValues(configLabels)
+	if err != nil {
+		log.Fatal("Invalid label: ", err)
+	}
+	exposedPorts, err := parseExposedPorts(configExpose)
+	if err != nil {
+		log.Fatal("Invalid exposed port: ", err)
+	}
+	annotations, err := parseKeyValues(configAnnotation)
+	if err != nil {
+		log.Fatal("Invalid annotation: ", err)
+	}
+	if !digest.Algorithm(configDigestAlg).Available() {
+		log.Fatalf("Unsupported digest algorithm: %s", configDigestAlg)
+	}
+	if configOutputFmt != ociOutputFormat && configOutputFmt != dockerOutputFormat {
+		log.Fatalf("Invalid output format: %q is not oci or docker", configOutputFmt)
+	}
+	if configOutput != stdoutArchive && isSameFile(configOutput, args[0]) {
+		log.Fatalf("Output %s would overwrite the input image", configOutput)
+	}
+
+	img, err := loadImage(args[0], platform)
+	if err != nil {
+		log.Fatal("Unable to load image: ", err)
+	}
+
+	if configEntrypoint != "" {
+		img.Config.Config.Entrypoint = []string{path.Join("/", configEntrypoint)}
+		img.Config.Config.Cmd = nil
+	}
+	err = applyConfigChanges(&img.Config, configChanges{
+		Env:          configEnv,
+		EnvPrepend:   configEnvPrepend,
+		EnvAppend:    configEnvAppend,
+		Labels:       labels,
+		ExposedPorts: exposedPorts,
+	})
+	if err != nil {
+		log.Fatal("Invalid environment variable: ", err)
+	}
+	created := now()
+	img.Config.Created = created
+	img.Config.History = append(img.Config.History, specsv1.History{
+		Created:    created,
+		CreatedBy:  layerCreatorName,
+		Comment:    "config",
+		EmptyLayer: true,
+	})
+
+	setCreatedAnnotation(&img)
+	for k, v := range annotations {
+		if img.Annotations == nil {
+			img.Annotations = make(map[string]string)
+		}
+		img.Annotations[k] = v
+	}
+
+	opts := ociarchive.WriteOptions{
+		DigestAlgorithm: digest.Algorithm(configDigestAlg),
+		RefName:         configRefName,
+	}
+	if err := writeImageArchive(img, configOutput, configOutputFmt, opts); err != nil {
+		log.Fatal("Failed to write image archive: ", err)
+	}
+}
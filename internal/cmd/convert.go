@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/zeroimage/internal/dockerarchive"
+	"go.alexhamlin.co/zeroimage/internal/ociarchive"
+)
+
+var convertCmd = &cobra.Command{
+	Use:   "convert [flags] DOCKER-ARCHIVE",
+	Short: "Convert a Docker image archive to an OCI image archive",
+	Long: `Convert a Docker image archive to an OCI image archive.
+
+DOCKER-ARCHIVE is the path to an archive produced by "docker save", "-" to read
+the archive from standard input, or an http(s) URL to download it from. If the
+archive contains multiple images, the one that best matches --platform is
+converted. The resulting archive complies with the OCI Image Layout
+Specification, and may be used with --from-archive or with other tools.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runConvert,
+}
+
+var (
+	convertPlatform string
+	convertOutput   string
+)
+
+func init() {
+	rootCmd.AddCommand(convertCmd)
+
+	convertCmd.Flags().StringVar(&convertPlatform, "platform", defaultPlatform, "Select the desired platform for the image")
+	convertCmd.Flags().StringVarP(&convertOutput, "output", "o", "", "Write the OCI image archive to this path (required)")
+	convertCmd.MarkFlagRequired("output")
+}
+
+func runConvert(_ *cobra.Command, args []string) {
+	platform, err := parsePlatform(convertPlatform)
+	if err != nil {
+		log.Fatal("Could not parse target platform: ", err)
+	}
+
+	log.Printf("Loading Docker image archive: %s", args[0])
+	archive, err := openArchive(args[0])
+	if err != nil {
+		log.Fatal("Unable to open archive: ", err)
+	}
+	index, err := dockerarchive.Load(archive)
+	archive.Close()
+	if err != nil {
+		log.Fatal("Unable to load archive: ", err)
+	}
+
+	entry, err := selectPlatform(args[0], index, platform)
+	if err != nil {
+		log.Fatal(err)
+	}
+	img, err := entry.GetImage(context.TODO())
+	if err != nil {
+		log.Fatal("Unable to load image: ", err)
+	}
+
+	log.Printf("Writing image archive: %s", convertOutput)
+	output, err := os.Create(convertOutput)
+	if err != nil {
+		log.Fatal("Unable to create output file: ", err)
+	}
+	if err := ociarchive.WriteImage(img, output); err != nil {
+		log.Fatal("Failed to write image archive: ", err)
+	}
+	if err := output.Close(); err != nil {
+		log.Fatal("Failed to write image archive: ", err)
+	}
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+
+	"go.alexhamlin.co/zeroimage/internal/registry"
+)
+
+// registryPassword is the password read from standard input for
+// --password-stdin.
+var registryPassword string
+
+// readRegistryCredentials checks the consistency of the flags that provide
+// registry credentials for a single command, and reads the password from
+// standard input if requested.
+func readRegistryCredentials() error {
+	switch {
+	case registryPasswordStdin && registryUsername == "":
+		return errors.New("--password-stdin requires --username")
+	case registryToken != "" && registryUsername != "":
+		return errors.New("--registry-token cannot be combined with --username")
+	case registryOIDCTokenFile != "" && (registryToken != "" || registryUsername != ""):
+		return errors.New("--oidc-token-file cannot be combined with --registry-token or --username")
+	case !registryPasswordStdin:
+		return nil
+	}
+
+	rawPassword, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	password := strings.TrimSuffix(string(rawPassword), "\n")
+	registryPassword = strings.TrimSuffix(password, "\r")
+	return nil
+}
+
+// dockerConfigPath returns the directory of the Docker configuration that
+// holds registry credentials, or the empty string to select Docker's default.
+func dockerConfigPath() string {
+	if dockerConfigDir != "" {
+		return dockerConfigDir
+	}
+	return os.Getenv("DOCKER_CONFIG")
+}
+
+// newRegistryClient returns a registry client that reads credentials from the
+// Docker configuration selected by --docker-config, if it is set, and logs
+// debugging messages with --debug.
+//
+// If --username, --registry-token, or --oidc-token-file provide credentials for
+// this command, the client uses them for the registries named by
+// authReferences, without consulting or changing any Docker configuration.
+// Other registries use saved credentials as usual, so that the provided
+// credentials are not sent to registries they were not meant for.
+func newRegistryClient(authReferences ...string) *registry.Client {
+	var keychain authn.Keychain = authn.DefaultKeychain
+	if dockerConfigDir != "" {
+		keychain = registry.DockerConfigKeychain(dockerConfigDir)
+	}
+
+	var auth authn.Authenticator
+	switch {
+	case registryToken != "":
+		auth = authn.FromConfig(authn.AuthConfig{RegistryToken: registryToken})
+	case registryUsername != "":
+		auth = authn.FromConfig(authn.AuthConfig{Username: registryUsername, Password: registryPassword})
+	case registryOIDCTokenFile != "":
+		auth = registry.OIDCTokenFile(registryOIDCTokenFile)
+	}
+	if auth != nil {
+		registries := make(map[string]bool)
+		for _, reference := range authReferences {
+			if ref, err := name.ParseReference(reference); err == nil {
+				registries[ref.Context().RegistryStr()] = true
+			}
+		}
+		keychain = providedKeychain{auth, registries, keychain}
+	}
+	client := &registry.Client{Keychain: keychain, UserAgent: registryUserAgent}
+	if debugLogging {
+		client.Debugf = debugf
+	}
+	return client
+}
+
+// debugf logs a debugging message.
+func debugf(format string, v ...interface{}) {
+	log.Printf("Debug: "+format, v...)
+}
+
+// providedKeychain resolves credentials provided on the command line for a set
+// of registries, and falls back to another keychain for all others.
+type providedKeychain struct {
+	auth       authn.Authenticator
+	registries map[string]bool
+	fallback   authn.Keychain
+}
+
+func (k providedKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	if k.registries[target.RegistryStr()] {
+		return k.auth, nil
+	}
+	return k.fallback.Resolve(target)
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/zeroimage/internal/image"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [flags] IMAGE_A IMAGE_B",
+	Short: "Compare the layers, config, and annotations of two images",
+	Long: `Compare the layers, config, and annotations of two images.
+
+Each image may be the path to an image archive or a reference to an image in a
+remote registry.`,
+	Args: cobra.ExactArgs(2),
+	Run:  runDiff,
+}
+
+var (
+	diffPlatform string
+)
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringVar(&diffPlatform, "platform", defaultPlatform, "Select the desired platform for both images")
+}
+
+func runDiff(_ *cobra.Command, args []string) {
+	platform, err := parsePlatform(diffPlatform)
+	if err != nil {
+		log.Fatal("Could not parse target platform: ", err)
+	}
+
+	a, err := loadImage(args[0], platform)
+	if err != nil {
+		log.Fatal("Unable to load first image: ", err)
+	}
+	b, err := loadImage(args[1], platform)
+	if err != nil {
+		log.Fatal("Unable to load second image: ", err)
+	}
+
+	diff := image.Diff(a, b)
+	if diff.Empty() {
+		log.Print("Images are identical")
+		return
+	}
+
+	for _, ld := range diff.Layers {
+		fmt.Printf("layer %d: %s -> %s\n", ld.Index, formatDiffValue(ld.A.Digest.String()), formatDiffValue(ld.B.Digest.String()))
+	}
+	for _, vd := range diff.Config {
+		fmt.Printf("config %s: %s -> %s\n", vd.Name, formatDiffValue(vd.A), formatDiffValue(vd.B))
+	}
+	for _, vd := range diff.Annotations {
+		fmt.Printf("annotation %s: %s -> %s\n", vd.Name, formatDiffValue(vd.A), formatDiffValue(vd.B))
+	}
+}
+
+func formatDiffValue(v string) string {
+	if v == "" {
+		return "(none)"
+	}
+	return v
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+
+	"go.alexhamlin.co/zeroimage/internal/dockerarchive"
+	"go.alexhamlin.co/zeroimage/internal/image"
+)
+
+// dockerDaemonReferencePrefix marks a --from reference to an image in the local
+// Docker daemon, like "docker-daemon:ubuntu:latest".
+const dockerDaemonReferencePrefix = "docker-daemon:"
+
+// loadBaseFromDockerDaemon loads the --from image from the local Docker daemon
+// by streaming the output of "docker save" into dockerarchive.Load. Running the
+// docker CLI, rather than calling the daemon's API directly, picks up the
+// user's DOCKER_HOST, contexts, and TLS settings.
+func loadBaseFromDockerDaemon() (image.Index, error) {
+	ref := strings.TrimPrefix(buildFrom, dockerDaemonReferencePrefix)
+	if ref == "" {
+		return nil, fmt.Errorf("%q does not name an image", buildFrom)
+	}
+
+	log.Printf("Loading base image from Docker daemon: %s", ref)
+	var stderr bytes.Buffer
+	cmd := exec.Command("docker", "save", ref)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("running docker save: %w", err)
+	}
+
+	index, loadErr := dockerarchive.Load(stdout)
+	// docker save blocks if it cannot write the rest of an archive that Load
+	// rejected, so it must be drained before it can exit.
+	io.Copy(io.Discard, stdout)
+	if err := cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("docker save %s: %s", ref, msg)
+		}
+		return nil, fmt.Errorf("docker save %s: %w", ref, err)
+	}
+	return index, loadErr
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var extractCmd = &cobra.Command{
+	Use:   "extract [flags] IMAGE PATH",
+	Short: "Extract a single file from an image",
+	Long: `Extract a single file from an image.
+
+The image may be the path to an image archive or a reference to an image in a
+remote registry. The final version of the file at PATH, after applying all of
+the image's layers, is written to standard output or to the file given by
+--output.`,
+	Args: cobra.ExactArgs(2),
+	Run:  runExtract,
+}
+
+var (
+	extractPlatform string
+	extractOutput   string
+)
+
+func init() {
+	rootCmd.AddCommand(extractCmd)
+
+	extractCmd.Flags().StringVar(&extractPlatform, "platform", defaultPlatform, "Select the desired platform for the image")
+	extractCmd.Flags().StringVarP(&extractOutput, "output", "o", "-", `Write the file to this path ("-" for standard output)`)
+}
+
+func runExtract(_ *cobra.Command, args []string) {
+	platform, err := parsePlatform(extractPlatform)
+	if err != nil {
+		log.Fatal("Could not parse target platform: ", err)
+	}
+
+	img, err := loadImage(args[0], platform)
+	if err != nil {
+		log.Fatal("Unable to load image: ", err)
+	}
+
+	file, err := img.OpenFile(context.TODO(), args[1])
+	if err != nil {
+		log.Fatal("Unable to open file: ", err)
+	}
+	defer file.Close()
+
+	output := os.Stdout
+	if extractOutput != "-" {
+		output, err = os.Create(extractOutput)
+		if err != nil {
+			log.Fatal("Unable to create output file: ", err)
+		}
+		defer output.Close()
+	}
+
+	if _, err := io.Copy(output, file); err != nil {
+		log.Fatal("Failed to extract file: ", err)
+	}
+}
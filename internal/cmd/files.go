@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var filesCmd = &cobra.Command{
+	Use:     "files [flags] IMAGE",
+	Aliases: []string{"ls", "tree"},
+	Short:   "List the files in an image",
+	Long: `List the files in an image.
+
+The image may be the path to an image archive or a reference to an image in a
+remote registry. The layers of the image are applied in order, respecting
+whiteouts, and each entry of the resulting filesystem is printed with its mode
+and size, along with the target of any link.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runFiles,
+}
+
+var (
+	filesPlatform string
+)
+
+func init() {
+	rootCmd.AddCommand(filesCmd)
+
+	filesCmd.Flags().StringVar(&filesPlatform, "platform", defaultPlatform, "Select the desired platform for the image")
+}
+
+func runFiles(_ *cobra.Command, args []string) {
+	platform, err := parsePlatform(filesPlatform)
+	if err != nil {
+		log.Fatal("Could not parse target platform: ", err)
+	}
+
+	img, err := loadImage(args[0], platform)
+	if err != nil {
+		log.Fatal("Unable to load image: ", err)
+	}
+
+	files, err := img.Files(context.TODO())
+	if err != nil {
+		log.Fatal("Unable to read image filesystem: ", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	for _, f := range files {
+		name := "/" + f.Path
+		switch f.Header.Typeflag {
+		case tar.TypeDir:
+			name += "/"
+		case tar.TypeSymlink:
+			name += " -> " + f.Header.Linkname
+		case tar.TypeLink:
+			name += " => /" + f.Header.Linkname
+		}
+		fmt.Fprintf(tw, "%s\t%d\t  %s\n", f.Header.FileInfo().Mode(), f.Header.Size, name)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history [flags] IMAGE",
+	Short: "Show the history of an image",
+	Long: `Show the history of an image.
+
+The image may be the path to an image archive or a reference to an image in a
+remote registry. Each entry in the history of the image's config is printed in
+order from oldest to newest, alongside the compressed size of the layer that it
+created, if any.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runHistory,
+}
+
+var (
+	historyPlatform string
+)
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().StringVar(&historyPlatform, "platform", defaultPlatform, "Select the desired platform for the image")
+}
+
+func runHistory(_ *cobra.Command, args []string) {
+	platform, err := parsePlatform(historyPlatform)
+	if err != nil {
+		log.Fatal("Could not parse target platform: ", err)
+	}
+
+	img, err := loadImage(args[0], platform)
+	if err != nil {
+		log.Fatal("Unable to load image: ", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CREATED\tCREATED BY\tLAYER\tSIZE\tCOMMENT")
+
+	// Like image.Image.BackfillHistory, assume that the entries describe the
+	// layers from the bottom up.
+	layer := 0
+	for _, h := range img.Config.History {
+		created := "-"
+		if h.Created != nil {
+			created = h.Created.Format(time.RFC3339)
+		}
+
+		layerIndex, size := "(empty)", "-"
+		if !h.EmptyLayer {
+			layerIndex, size = "?", "?"
+			if layer < len(img.Layers) {
+				layerIndex = strconv.Itoa(layer)
+				size = formatSize(img.Layers[layer].Descriptor.Size)
+			}
+			layer++
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", created, formatHistoryValue(h.CreatedBy), layerIndex, size, formatHistoryValue(h.Comment))
+	}
+	tw.Flush()
+
+	if layer < len(img.Layers) {
+		log.Printf("History does not account for %d layer(s)", len(img.Layers)-layer)
+	}
+}
+
+func formatHistoryValue(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+// formatSize formats a size in bytes using binary units.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/zeroimage/internal/image"
+)
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect [flags] IMAGE",
+	Short: "Show the manifest details and configuration of an image",
+	Long: `Show the manifest details and configuration of an image.
+
+The image may be the path to an image archive or a reference to an image in a
+remote registry. The digest, platform, annotations, layers, and configuration
+of the image are printed as a JSON object.
+
+If the image is an index of multiple platforms and --platform is not given,
+the platforms in the index are listed instead, so that one may be selected
+with --platform.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runInspect,
+}
+
+var (
+	inspectPlatform string
+)
+
+func init() {
+	rootCmd.AddCommand(inspectCmd)
+
+	inspectCmd.Flags().StringVar(&inspectPlatform, "platform", defaultPlatform, "Select the desired platform for the image")
+}
+
+// inspectResult is the structure printed by inspect for a single image.
+type inspectResult struct {
+	Digest       digest.Digest        `json:"digest"`
+	Platform     string               `json:"platform"`
+	ArtifactType string               `json:"artifactType,omitempty"`
+	Annotations  map[string]string    `json:"annotations,omitempty"`
+	Layers       []specsv1.Descriptor `json:"layers"`
+	Config       image.Config         `json:"config"`
+}
+
+func runInspect(cmd *cobra.Command, args []string) {
+	platform, err := parsePlatform(inspectPlatform)
+	if err != nil {
+		log.Fatal("Could not parse target platform: ", err)
+	}
+
+	index, err := loadIndex(args[0])
+	if err != nil {
+		log.Fatal("Unable to load image: ", err)
+	}
+
+	if len(index) > 1 && !cmd.Flags().Changed("platform") {
+		log.Printf("%s is an image index with %d platforms; select one with --platform for details", args[0], len(index))
+		printIndexPlatforms(index)
+		return
+	}
+
+	selected := index.SelectByPlatform(platform)
+	if len(selected) == 0 {
+		log.Printf("%s does not support %s; it supports:", args[0], platforms.Format(platform))
+		printIndexPlatforms(index)
+		os.Exit(1)
+	}
+	img, err := selected[0].GetImage(context.TODO())
+	if err != nil {
+		log.Fatal("Unable to load image: ", err)
+	}
+
+	result := inspectResult{
+		Digest:       selected[0].Digest,
+		Platform:     platforms.Format(img.IndexPlatform()),
+		ArtifactType: img.ArtifactType,
+		Annotations:  img.Annotations,
+		Layers:       make([]specsv1.Descriptor, len(img.Layers)),
+		Config:       img.Config,
+	}
+	for i, layer := range img.Layers {
+		result.Layers[i] = layer.Descriptor
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		log.Fatal("Unable to write result: ", err)
+	}
+}
+
+// printIndexPlatforms prints the platform, OS version, and manifest digest of
+// each entry in index.
+func printIndexPlatforms(index image.Index) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PLATFORM\tOS VERSION\tDIGEST")
+	for _, entry := range index {
+		osVersion := entry.Platform.OSVersion
+		if osVersion == "" {
+			osVersion = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", platforms.Format(entry.Platform), osVersion, entry.Digest)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/zeroimage/internal/tarlayer"
+)
+
+var layerDigestCmd = &cobra.Command{
+	Use:   "layer-digest [flags] BINARY",
+	Short: "Print the digests of the layer that build would create for a binary",
+	Long: `Print the digests of the layer that build would create for a binary.
+
+BINARY is placed in a layer exactly as "zeroimage build" places an entrypoint
+with no base image or other files, and the layer's compressed digest,
+uncompressed diff ID, and compressed size are printed without writing an image.
+The flags select the same options as the build flags of the same names.
+
+Without --no-timestamp, the layer records the modification time of BINARY, and
+the current time for any parent directories of --entrypoint-path, so its
+digests change along with them.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runLayerDigest,
+}
+
+var (
+	layerDigestEntrypointPath string
+	layerDigestCompression    string
+	layerDigestAlg            string
+	layerDigestNoTimestamp    bool
+)
+
+func init() {
+	rootCmd.AddCommand(layerDigestCmd)
+
+	layerDigestCmd.Flags().StringVar(&layerDigestEntrypointPath, "entrypoint-path", "", "Place the binary at this path in the layer (default /[BINARY base name])")
+	layerDigestCmd.Flags().StringVar(&layerDigestCompression, "compression", string(tarlayer.Gzip), "Compress the layer with this method (gzip or none)")
+	layerDigestCmd.Flags().StringVar(&layerDigestAlg, "digest-algorithm", string(digest.Canonical), "Use this algorithm (sha256, sha384, or sha512) for the layer digests")
+	layerDigestCmd.Flags().BoolVar(&layerDigestNoTimestamp, "no-timestamp", false, "Set file modification times in the layer to the Unix epoch")
+}
+
+func runLayerDigest(_ *cobra.Command, args []string) {
+	if err := checkEntrypoint(args[0]); err != nil {
+		log.Fatal("Invalid binary: ", err)
+	}
+	compression, err := tarlayer.ParseCompression(layerDigestCompression)
+	if err != nil {
+		log.Fatal("Invalid layer compression: ", err)
+	}
+	if !digest.Algorithm(layerDigestAlg).Available() {
+		log.Fatalf("Unsupported digest algorithm: %s", layerDigestAlg)
+	}
+
+	targetPath := "/" + filepath.Base(args[0])
+	if layerDigestEntrypointPath != "" {
+		targetPath = path.Join("/", layerDigestEntrypointPath)
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		log.Fatal("Unable to open binary: ", err)
+	}
+	defer file.Close()
+
+	builder := tarlayer.NewBuilderWithOptions(tarlayer.Options{
+		DigestAlgorithm: digest.Algorithm(layerDigestAlg),
+		Compression:     compression,
+	})
+	if layerDigestNoTimestamp {
+		builder.DefaultModTime = epoch
+		builder.FixedModTime = true
+	}
+	builder.Add(targetPath, file)
+	layer, err := builder.Finish()
+	if err != nil {
+		log.Fatal("Failed to build layer: ", err)
+	}
+
+	fmt.Printf("Digest:  %s\n", layer.Descriptor.Digest)
+	fmt.Printf("DiffID:  %s\n", layer.DiffID)
+	fmt.Printf("Size:    %d\n", layer.Descriptor.Size)
+}
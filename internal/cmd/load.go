@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"go.alexhamlin.co/zeroimage/internal/image"
+	"go.alexhamlin.co/zeroimage/internal/ociarchive"
+	"go.alexhamlin.co/zeroimage/internal/registry"
+)
+
+// loadIndex loads an image index from source, which may be the path to an
+// existing image archive, "-" to read an archive from standard input, or a
+// reference to an image in a remote registry. Paths to existing files take
+// precedence over registry references.
+func loadIndex(source string) (image.Index, error) {
+	if _, err := os.Stat(source); err != nil && !isArchiveURL(source) && source != stdinArchive {
+		log.Printf("Loading image from registry: %s", source)
+		return newRegistryClient(source).LoadWithOptions(context.TODO(), source, registry.LoadOptions{Concurrency: pullConcurrency})
+	}
+
+	log.Printf("Loading image archive: %s", source)
+	archive, err := openArchive(source)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	return loadArchive(archive)
+}
+
+// loadArchive loads an image index from an open image archive, checking it
+// for missing and unreferenced blobs if --strict-archives is set.
+func loadArchive(archive io.Reader) (image.Index, error) {
+	return ociarchive.LoadWithOptions(archive, ociarchive.LoadOptions{
+		Strict: strictArchives,
+		UnreferencedBlob: func(dgst digest.Digest) {
+			log.Printf("Warning: archive contains unreferenced blob %s", dgst)
+		},
+	})
+}
+
+// stdinArchive is the path that selects standard input as the source of an
+// image archive.
+const stdinArchive = "-"
+
+// openArchive opens an image archive at the provided path, reads it from
+// standard input if the path is "-", or downloads it if the path is an http or
+// https URL. If the archive is compressed with gzip, openArchive transparently
+// decompresses it.
+//
+// Clients must read archives sequentially, as the returned reader does not
+// support seeking.
+func openArchive(path string) (io.ReadCloser, error) {
+	var (
+		rc  io.ReadCloser
+		err error
+	)
+	switch {
+	case path == stdinArchive && registryPasswordStdin:
+		return nil, errors.New("cannot read an archive from stdin along with --password-stdin")
+	case path == stdinArchive:
+		rc = io.NopCloser(os.Stdin)
+	case isArchiveURL(path):
+		rc, err = downloadArchive(context.TODO(), path)
+	default:
+		rc, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(rc)
+	if magic, _ := br.Peek(2); !bytes.Equal(magic, gzipMagic) {
+		return readCloser{br, rc}, nil
+	}
+
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return readCloser{zr, rc}, nil
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+func isArchiveURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// downloadClient is the HTTP client for downloads from URLs given on the
+// command line. It uses the proxy settings from the environment and follows any
+// redirects, like http.DefaultClient, but gives up on servers that stop
+// responding instead of waiting for them forever.
+var downloadClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+	// Archives and entrypoints can be large, so this only bounds the total time
+	// of a download loosely. The transport's timeouts catch servers that never
+	// respond.
+	Timeout: 30 * time.Minute,
+}
+
+// downloadArchive streams the body of an HTTP GET request for url using
+// downloadClient.
+func downloadArchive(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("downloading %s: %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// readCloser combines a Reader with the Closer for an underlying source.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// loadImage loads the image from source that best matches the provided
+// platform, following the semantics of loadIndex and selectPlatform.
+func loadImage(source string, platform specsv1.Platform) (image.Image, error) {
+	index, err := loadIndex(source)
+	if err != nil {
+		return image.Image{}, err
+	}
+
+	entry, err := selectPlatform(source, index, platform)
+	if err != nil {
+		return image.Image{}, err
+	}
+	if len(index) > 1 {
+		log.Printf("Selecting platform from image index: %s", platforms.Format(entry.Platform))
+	}
+	return entry.GetImage(context.TODO())
+}
+
+// selectPlatform returns the entry of index that best matches platform, for
+// commands that need a single image from a source that may be a multi-platform
+// index. These commands default platform to the host platform when --platform
+// is unset. If no entry matches, the error lists the platforms that source
+// does support.
+func selectPlatform(source string, index image.Index, platform specsv1.Platform) (image.IndexEntry, error) {
+	if len(index) == 0 {
+		return image.IndexEntry{}, fmt.Errorf("%s does not contain any images", source)
+	}
+
+	selected := index.SelectByPlatform(platform)
+	if len(selected) == 0 {
+		supported := make([]string, len(index))
+		for i, entry := range index {
+			supported[i] = platforms.Format(entry.Platform)
+		}
+		return image.IndexEntry{}, fmt.Errorf(
+			"%s does not support %s; select one of its platforms with --platform: %s",
+			source, platforms.Format(platform), strings.Join(supported, ", "))
+	}
+	return selected[0], nil
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/opencontainers/go-digest"
+
+	"go.alexhamlin.co/zeroimage/internal/image"
+)
+
+// lockfile describes the exact content of a built image, for review of changes
+// to that content over time.
+type lockfile struct {
+	ManifestDigest digest.Digest   `json:"manifestDigest"`
+	Manifest       image.Manifest  `json:"manifest"`
+	Config         lockfileBlob    `json:"config"`
+	Layers         []lockfileLayer `json:"layers"`
+}
+
+type lockfileBlob struct {
+	Digest digest.Digest `json:"digest"`
+	Size   int64         `json:"size"`
+}
+
+type lockfileLayer struct {
+	MediaType string        `json:"mediaType"`
+	Digest    digest.Digest `json:"digest"`
+	DiffID    digest.Digest `json:"diffID"`
+	Size      int64         `json:"size"`
+}
+
+// writeLockfile writes a lockfile for img to path, describing the manifest in
+// the same form as outputImage.
+func writeLockfile(img image.Image, path string) error {
+	manifest, manifestDesc, err := outputManifest(img)
+	if err != nil {
+		return err
+	}
+
+	lock := lockfile{
+		ManifestDigest: manifestDesc.Digest,
+		Manifest:       manifest,
+		Config:         lockfileBlob{Digest: manifest.Config.Digest, Size: manifest.Config.Size},
+	}
+	for i, layer := range img.Layers {
+		lock.Layers = append(lock.Layers, lockfileLayer{
+			MediaType: manifest.Layers[i].MediaType,
+			Digest:    layer.Descriptor.Digest,
+			DiffID:    layer.DiffID,
+			Size:      layer.Descriptor.Size,
+		})
+	}
+
+	encoded, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(encoded, '\n'), 0644)
+}
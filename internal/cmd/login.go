@@ -1,10 +1,7 @@
 package cmd
 
 import (
-	"io/ioutil"
 	"log"
-	"os"
-	"strings"
 
 	"github.com/docker/cli/cli/config"
 	"github.com/docker/cli/cli/config/types"
@@ -20,23 +17,17 @@ var loginCmd = &cobra.Command{
 	Run:   runLogin,
 }
 
-var (
-	loginUsername      string
-	loginPasswordStdin bool
-)
-
 func init() {
 	rootCmd.AddCommand(loginCmd)
-
-	loginCmd.Flags().StringVarP(&loginUsername, "username", "u", "", "The username to log in with")
-	loginCmd.Flags().BoolVar(&loginPasswordStdin, "password-stdin", false, "Take the password from stdin")
 }
 
+// runLogin saves the credentials given by the global --username and
+// --password-stdin flags.
 func runLogin(_ *cobra.Command, args []string) {
-	if loginUsername == "" {
+	if registryUsername == "" {
 		log.Fatal("Must provide a username to log in with")
 	}
-	if !loginPasswordStdin {
+	if !registryPasswordStdin {
 		log.Fatal("Must provide password via stdin")
 	}
 
@@ -53,14 +44,7 @@ func runLogin(_ *cobra.Command, args []string) {
 		serverAddress = authn.DefaultAuthKey
 	}
 
-	rawPassword, err := ioutil.ReadAll(os.Stdin)
-	if err != nil {
-		log.Fatal("Unable to read password: ", err)
-	}
-	password := strings.TrimSuffix(string(rawPassword), "\n")
-	password = strings.TrimSuffix(password, "\r")
-
-	conf, err := config.Load(os.Getenv("DOCKER_CONFIG"))
+	conf, err := config.Load(dockerConfigPath())
 	if err != nil {
 		log.Fatal("Unable to read Docker configuration: ", err)
 	}
@@ -68,8 +52,8 @@ func runLogin(_ *cobra.Command, args []string) {
 	creds := conf.GetCredentialsStore(serverAddress)
 	err = creds.Store(types.AuthConfig{
 		ServerAddress: serverAddress,
-		Username:      loginUsername,
-		Password:      password,
+		Username:      registryUsername,
+		Password:      registryPassword,
 	})
 	if err != nil {
 		log.Fatal("Unable to save login credentials: ", err)
@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"log"
-	"os"
 
 	"github.com/docker/cli/cli/config"
 	"github.com/google/go-containerregistry/pkg/authn"
@@ -35,7 +34,7 @@ func runLogout(_ *cobra.Command, args []string) {
 		serverAddress = authn.DefaultAuthKey
 	}
 
-	conf, err := config.Load(os.Getenv("DOCKER_CONFIG"))
+	conf, err := config.Load(dockerConfigPath())
 	if err != nil {
 		log.Fatal("Unable to read Docker configuration: ", err)
 	}
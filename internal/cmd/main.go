@@ -10,6 +10,8 @@ import (
 	_ "crypto/sha512"
 
 	"github.com/spf13/cobra"
+
+	"go.alexhamlin.co/zeroimage/internal/image"
 )
 
 var rootCmd = &cobra.Command{
@@ -18,9 +20,39 @@ var rootCmd = &cobra.Command{
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
 		log.SetPrefix("[zeroimage] ")
 		log.SetFlags(0)
+
+		if err := readRegistryCredentials(); err != nil {
+			log.Fatal("Invalid registry credentials: ", err)
+		}
 	},
 }
 
+var (
+	strictArchives        bool
+	debugLogging          bool
+	dockerConfigDir       string
+	registryUsername      string
+	registryPasswordStdin bool
+	registryToken         string
+	registryOIDCTokenFile string
+	pullConcurrency       int
+	registryUserAgent     string
+)
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&strictArchives, "strict-archives", false, "Fail if an image archive is missing any referenced blob, and warn about unreferenced blobs")
+	rootCmd.PersistentFlags().BoolVar(&debugLogging, "debug", false, "Log debugging details, such as failures of best-effort registry requests")
+	rootCmd.PersistentFlags().StringVar(&dockerConfigDir, "docker-config", "", "Read and write registry credentials in the Docker configuration in this directory (default $DOCKER_CONFIG or ~/.docker)")
+	rootCmd.PersistentFlags().StringVarP(&registryUsername, "username", "u", "", "Authenticate to the registry with this username instead of saved credentials")
+	rootCmd.PersistentFlags().BoolVar(&registryPasswordStdin, "password-stdin", false, "Take the password for --username from stdin")
+	rootCmd.PersistentFlags().StringVar(&registryToken, "registry-token", "", "Authenticate to the registry with this bearer token instead of saved credentials")
+	rootCmd.PersistentFlags().StringVar(&registryOIDCTokenFile, "oidc-token-file", "", "Authenticate to the registry by exchanging the OIDC ID token in this file at its token service, instead of saved credentials")
+	rootCmd.PersistentFlags().StringVar(&registryUserAgent, "user-agent", "", "Send this User-Agent header with registry requests (default zeroimage/VERSION)")
+	rootCmd.PersistentFlags().IntVar(&pullConcurrency, "pull-concurrency", image.DefaultLoadConcurrency, "Fetch up to this many manifests at once when loading a multi-platform image from a registry")
+}
+
+// Execute runs the zeroimage command line interface, and is the only entry
+// point to it. Execute exits with a non-zero status if the command fails.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
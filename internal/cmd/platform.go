@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/platforms"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// armArchAliases maps architectures with an attached ARM variant, as reported
+// by uname, to the equivalent OCI architecture and variant.
+var armArchAliases = map[string]string{
+	"armv5":  "arm/v5",
+	"armv5l": "arm/v5",
+	"armv6":  "arm/v6",
+	"armv6l": "arm/v6",
+	"armv7":  "arm/v7",
+	"armv7l": "arm/v7",
+}
+
+// parsePlatform parses a platform specifier like platforms.Parse, which already
+// normalizes common architecture aliases like "x86_64" and "aarch64" to their
+// OCI names. parsePlatform additionally accepts 32-bit ARM architectures with
+// an attached variant, like "linux/armv7".
+func parsePlatform(specifier string) (specsv1.Platform, error) {
+	i := strings.LastIndex(specifier, "/")
+	prefix, arch := specifier[:i+1], specifier[i+1:]
+	if alias, ok := armArchAliases[strings.ToLower(arch)]; ok {
+		specifier = prefix + alias
+	}
+	return platforms.Parse(specifier)
+}
+
+// describePlatform formats platform like platforms.Format, followed by the OS
+// version and OS features that platforms.Format leaves out, so that logs show
+// exactly which platform a build selected.
+func describePlatform(platform specsv1.Platform) string {
+	desc := platforms.Format(platform)
+	if platform.OSVersion != "" {
+		desc += fmt.Sprintf(" (os.version %s)", platform.OSVersion)
+	}
+	if len(platform.OSFeatures) > 0 {
+		desc += fmt.Sprintf(" (os.features %s)", strings.Join(platform.OSFeatures, ","))
+	}
+	return desc
+}
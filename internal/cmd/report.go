@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"go.alexhamlin.co/zeroimage/internal/image"
+)
+
+// jsonReport is the value of --report that selects a JSON build summary.
+const jsonReport = "json"
+
+// buildReport summarizes the result of a build for consumption by scripts.
+type buildReport struct {
+	Output         string        `json:"output,omitempty"`
+	Pushed         []string      `json:"pushed,omitempty"`
+	ManifestDigest digest.Digest `json:"manifestDigest"`
+	ConfigDigest   digest.Digest `json:"configDigest"`
+	Layers         int           `json:"layers"`
+	Size           int64         `json:"size"`
+	Platform       string        `json:"platform"`
+	// PlatformDetails is the full platform of the image, including the OS
+	// version and OS features that Platform leaves out.
+	PlatformDetails specsv1.Platform `json:"platformDetails"`
+}
+
+// checkReportFormat returns an error if format does not name a supported
+// --report format.
+func checkReportFormat(format string) error {
+	if format != "" && format != jsonReport {
+		return fmt.Errorf("unsupported report format %q", format)
+	}
+	return nil
+}
+
+// writeReport prints a single-line JSON summary of img, as output by
+// outputImage, to standard output. The size in the summary is the total size
+// of the manifest, configuration, and layer blobs.
+func writeReport(img image.Image) error {
+	manifest, manifestDesc, err := outputManifest(img)
+	if err != nil {
+		return err
+	}
+
+	report := buildReport{
+		ManifestDigest:  manifestDesc.Digest,
+		ConfigDigest:    manifest.Config.Digest,
+		Layers:          len(img.Layers),
+		Size:            manifestDesc.Size + manifest.Config.Size,
+		Platform:        platforms.Format(img.IndexPlatform()),
+		PlatformDetails: img.IndexPlatform(),
+	}
+	if len(buildPush) > 0 {
+		report.Pushed = buildPush
+	} else {
+		report.Output = buildOutput
+	}
+	for _, layer := range img.Layers {
+		report.Size += layer.Descriptor.Size
+	}
+	return json.NewEncoder(os.Stdout).Encode(report)
+}
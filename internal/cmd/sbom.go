@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"context"
+	"debug/buildinfo"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"runtime/debug"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+
+	"go.alexhamlin.co/zeroimage/internal/image"
+	"go.alexhamlin.co/zeroimage/internal/registry"
+)
+
+// mediaTypeSPDX is the media type and artifact type of the SBOM that --sbom
+// attaches to a pushed image.
+const mediaTypeSPDX = "application/spdx+json"
+
+// spdxDocument is the subset of an SPDX 2.3 JSON document that --sbom writes.
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID           string            `json:"SPDXID"`
+	Name             string            `json:"name"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	Checksums        []spdxChecksum    `json:"checksums,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// newSBOMDocument returns an SPDX document describing the entrypoint binary at
+// sourcePath, which is placed in the image at targetPath. The document lists
+// the binary's SHA-256 digest and, if Go built the binary, the modules that
+// went into it. created is the creation time of the image, or nil to use the
+// Unix epoch.
+func newSBOMDocument(sourcePath, targetPath string, created *time.Time) (spdxDocument, error) {
+	file, err := os.Open(sourcePath)
+	if err != nil {
+		return spdxDocument{}, err
+	}
+	defer file.Close()
+
+	dgst, err := digest.SHA256.FromReader(file)
+	if err != nil {
+		return spdxDocument{}, err
+	}
+
+	createdTime := time.Unix(0, 0)
+	if created != nil {
+		createdTime = *created
+	}
+
+	const entrypointID = "SPDXRef-Package-entrypoint"
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              targetPath,
+		DocumentNamespace: "https://spdx.org/spdxdocs/zeroimage/" + dgst.Encoded(),
+		CreationInfo: spdxCreationInfo{
+			Created:  createdTime.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: " + layerCreatorName},
+		},
+		Packages: []spdxPackage{{
+			SPDXID:           entrypointID,
+			Name:             path.Base(targetPath),
+			DownloadLocation: "NOASSERTION",
+			Checksums:        []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: dgst.Encoded()}},
+		}},
+		Relationships: []spdxRelationship{{
+			SPDXElementID:      "SPDXRef-DOCUMENT",
+			RelationshipType:   "DESCRIBES",
+			RelatedSPDXElement: entrypointID,
+		}},
+	}
+
+	// As with --annotate-entrypoint, buildinfo.Read fails for binaries that Go
+	// did not build, which leaves the entrypoint as the only package.
+	info, err := buildinfo.Read(file)
+	if err != nil {
+		return doc, nil
+	}
+	modules := []*debug.Module{&info.Main}
+	modules = append(modules, info.Deps...)
+	for i, mod := range modules {
+		if mod.Replace != nil {
+			mod = mod.Replace
+		}
+		if mod.Path == "" {
+			continue
+		}
+		id := fmt.Sprintf("SPDXRef-Package-go-module-%d", i)
+		pkg := spdxPackage{
+			SPDXID:           id,
+			Name:             mod.Path,
+			VersionInfo:      mod.Version,
+			DownloadLocation: "NOASSERTION",
+		}
+		if mod.Version != "" && mod.Version != "(devel)" {
+			pkg.ExternalRefs = []spdxExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  "pkg:golang/" + mod.Path + "@" + mod.Version,
+			}}
+		}
+		doc.Packages = append(doc.Packages, pkg)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			SPDXElementID:      entrypointID,
+			RelationshipType:   "CONTAINS",
+			RelatedSPDXElement: id,
+		})
+	}
+	return doc, nil
+}
+
+// pushSBOM pushes an SBOM for the entrypoint binary at sourcePath to each
+// repository in --push, as a referrer of img's manifest.
+func pushSBOM(img image.Image, sourcePath, targetPath string) error {
+	doc, err := newSBOMDocument(sourcePath, targetPath, img.Config.Created)
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	_, subject, err := registry.ManifestDescriptor(img, buildPushOptions())
+	if err != nil {
+		return err
+	}
+
+	desc, err := newRegistryClient(buildAuthReferences()...).PushReferrer(context.TODO(), buildPush, subject, registry.Referrer{
+		ArtifactType: mediaTypeSPDX,
+		MediaType:    mediaTypeSPDX,
+		Content:      content,
+	}, registry.PushOptions{
+		DigestAlgorithm: digest.Algorithm(buildDigestAlg),
+		MaxRetries:      pushMaxRetries(),
+		AlwaysUpload:    buildAlwaysUpload,
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Pushed SBOM %s referring to %s", desc.Digest, subject.Digest)
+	return nil
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"os"
+	"testing"
+)
+
+func TestNewSBOMDocument(t *testing.T) {
+	// The test binary is a Go binary, so its SBOM should list the entrypoint
+	// along with at least the main module.
+	binary, err := os.Executable()
+	if err != nil {
+		t.Fatalf("failed to find test binary: %v", err)
+	}
+
+	doc, err := newSBOMDocument(binary, "/app", nil)
+	if err != nil {
+		t.Fatalf("failed to build SBOM: %v", err)
+	}
+	if doc.Name != "/app" {
+		t.Errorf("SBOM is named %q, want %q", doc.Name, "/app")
+	}
+	if len(doc.Packages) < 2 {
+		t.Fatalf("SBOM lists %d package(s), want the entrypoint and its modules", len(doc.Packages))
+	}
+	if doc.Packages[0].Name != "app" || len(doc.Packages[0].Checksums) != 1 {
+		t.Errorf("SBOM describes entrypoint as %+v", doc.Packages[0])
+	}
+	if len(doc.Relationships) != len(doc.Packages) {
+		t.Errorf("SBOM has %d relationship(s) for %d package(s)", len(doc.Relationships), len(doc.Packages))
+	}
+}
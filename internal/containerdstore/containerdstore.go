@@ -0,0 +1,90 @@
+// Package containerdstore reads container images from the local content store
+// of a containerd daemon.
+package containerdstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/go-digest"
+
+	"go.alexhamlin.co/zeroimage/internal/image"
+)
+
+// DefaultRoot is the default root directory of a containerd daemon, which
+// contains its content store.
+const DefaultRoot = "/var/lib/containerd"
+
+// contentBlobsDir is the directory under a containerd root that holds the
+// blobs of the content store, in subdirectories named for their digest
+// algorithms.
+const contentBlobsDir = "io.containerd.content.v1.content/blobs"
+
+// Load loads an image index from the content store of the containerd daemon
+// whose root directory is root, starting from the index or manifest with the
+// provided digest. Methods on the returned Index read blobs from the content
+// store as they are needed, without contacting the daemon or any registry.
+//
+// The content store only records blobs by digest. Image names are kept in
+// containerd's metadata database, which Load does not read; use a command
+// like "ctr images ls" to find the digest of a named image.
+//
+// containerd only fetches the content for the platforms that it pulls, so an
+// index may refer to manifests that are missing from the store. Loading an
+// image whose manifest or blobs are missing returns an error.
+func Load(ctx context.Context, root string, dgst digest.Digest) (image.Index, error) {
+	if err := dgst.Validate(); err != nil {
+		return nil, err
+	}
+	s := store{root: root, rootDigest: dgst}
+	if _, err := os.Stat(s.blobPath(dgst)); err != nil {
+		return nil, s.blobError(dgst, err)
+	}
+	return image.Load(ctx, s)
+}
+
+type store struct {
+	root       string
+	rootDigest digest.Digest
+}
+
+func (s store) RootDigest() (dgst digest.Digest, ok bool) {
+	return s.rootDigest, true
+}
+
+func (s store) OpenRootManifest(ctx context.Context) (io.ReadCloser, error) {
+	return s.OpenBlob(ctx, s.rootDigest)
+}
+
+func (s store) OpenManifest(ctx context.Context, dgst digest.Digest) (io.ReadCloser, error) {
+	return s.OpenBlob(ctx, dgst)
+}
+
+func (s store) OpenBlob(_ context.Context, dgst digest.Digest) (io.ReadCloser, error) {
+	// Validation ensures that the digest cannot name a path outside of the
+	// content store.
+	if err := dgst.Validate(); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.blobPath(dgst))
+	if err != nil {
+		return nil, s.blobError(dgst, err)
+	}
+	return f, nil
+}
+
+func (s store) blobPath(dgst digest.Digest) string {
+	return filepath.Join(s.root, filepath.FromSlash(contentBlobsDir), dgst.Algorithm().String(), dgst.Encoded())
+}
+
+func (s store) blobError(dgst digest.Digest, err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("content store in %s is missing blob %s", s.root, dgst)
+	}
+	return err
+}
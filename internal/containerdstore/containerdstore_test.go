@@ -0,0 +1,119 @@
+package containerdstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"go.alexhamlin.co/zeroimage/internal/tarlayer"
+)
+
+func TestLoad(t *testing.T) {
+	root := t.TempDir()
+
+	builder := tarlayer.NewBuilder()
+	builder.AddContent("hello.txt", []byte("hello world"))
+	layer, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build test layer: %v", err)
+	}
+	blob, err := layer.OpenBlob(context.Background())
+	if err != nil {
+		t.Fatalf("failed to open test layer: %v", err)
+	}
+	layerBlob, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatalf("failed to read test layer: %v", err)
+	}
+	writeBlob(t, root, layerBlob)
+
+	config := specsv1.Image{
+		Architecture: "arm64",
+		OS:           "linux",
+		RootFS:       specsv1.RootFS{Type: "layers", DiffIDs: []digest.Digest{layer.DiffID}},
+	}
+	configBlob := mustMarshal(t, config)
+	manifest := specsv1.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: specsv1.MediaTypeImageManifest,
+		Config: specsv1.Descriptor{
+			MediaType: specsv1.MediaTypeImageConfig,
+			Digest:    writeBlob(t, root, configBlob),
+			Size:      int64(len(configBlob)),
+		},
+		Layers: []specsv1.Descriptor{layer.Descriptor},
+	}
+	manifestDgst := writeBlob(t, root, mustMarshal(t, manifest))
+
+	index, err := Load(context.Background(), root, manifestDgst)
+	if err != nil {
+		t.Fatalf("failed to load image: %v", err)
+	}
+	if len(index) != 1 {
+		t.Fatalf("loaded %d image(s), want 1", len(index))
+	}
+	if index[0].Digest != manifestDgst {
+		t.Errorf("loaded manifest %s, want %s", index[0].Digest, manifestDgst)
+	}
+	if got, want := platforms.Format(index[0].Platform), "linux/arm64"; got != want {
+		t.Errorf("loaded image for %s, want %s", got, want)
+	}
+
+	img, err := index[0].GetImage(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load image: %v", err)
+	}
+	if len(img.Layers) != 1 {
+		t.Fatalf("loaded %d layer(s), want 1", len(img.Layers))
+	}
+	blob, err = img.Layers[0].OpenBlob(context.Background())
+	if err != nil {
+		t.Fatalf("failed to open layer: %v", err)
+	}
+	defer blob.Close()
+	got, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatalf("failed to read layer: %v", err)
+	}
+	if !bytes.Equal(got, layerBlob) {
+		t.Error("layer blob does not match the content store")
+	}
+}
+
+func TestLoadMissingBlob(t *testing.T) {
+	_, err := Load(context.Background(), t.TempDir(), digest.FromString("missing"))
+	if err == nil {
+		t.Fatal("loaded an image that is missing from the content store")
+	}
+}
+
+func writeBlob(t *testing.T, root string, content []byte) digest.Digest {
+	t.Helper()
+	dgst := digest.FromBytes(content)
+	dir := filepath.Join(root, filepath.FromSlash(contentBlobsDir), dgst.Algorithm().String())
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, dgst.Encoded()), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dgst
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	content, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
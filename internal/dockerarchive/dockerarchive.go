@@ -0,0 +1,201 @@
+// Package dockerarchive reads and writes the tar archives of container images
+// produced by "docker save" and accepted by "docker load".
+package dockerarchive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"go.alexhamlin.co/zeroimage/internal/image"
+	"go.alexhamlin.co/zeroimage/internal/tarlayer"
+)
+
+// manifestFile is the name of the file at the root of a "docker save" archive
+// that lists the images in the archive.
+const manifestFile = "manifest.json"
+
+// manifestEntry represents a single image in the manifest of a "docker save"
+// archive. Config and Layers are paths to files in the archive.
+type manifestEntry struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// Load loads an image index from a tar archive produced by "docker save",
+// containing one entry for each image in the archive's manifest.json.
+//
+// Docker archives do not contain image manifests, so the digest of each entry
+// in the index is that of the OCI image manifest that ociarchive.WriteImage
+// would write for the image with its default options. The layers of each image
+// keep the media types of their blobs, which are usually uncompressed tar
+// archives.
+//
+// Like ociarchive.Load, Load buffers the entire content of the archive in
+// memory.
+func Load(r io.Reader) (image.Index, error) {
+	da := dockerArchive{
+		files: make(map[string][]byte),
+		links: make(map[string]string),
+	}
+	if err := da.populateFromTar(tar.NewReader(r)); err != nil {
+		return nil, fmt.Errorf("invalid archive: %w", err)
+	}
+
+	manifestJSON, err := da.file(manifestFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive: %w", err)
+	}
+	var manifest []manifestEntry
+	if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid archive: %s: %w", manifestFile, err)
+	}
+
+	idx := make(image.Index, len(manifest))
+	for i, entry := range manifest {
+		img, err := da.loadImage(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive: image %d: %w", i, err)
+		}
+		dgst, err := manifestDigest(img)
+		if err != nil {
+			return nil, err
+		}
+		idx[i] = image.IndexEntry{
+			Platform: img.Platform,
+			Digest:   dgst,
+			GetImage: func(_ context.Context) (image.Image, error) {
+				return img, nil
+			},
+		}
+	}
+	return idx, nil
+}
+
+// maxLinkHops is the maximum number of symbolic links that dockerArchive will
+// follow while resolving a path. Older versions of Docker represent repeated
+// layers as links to the first copy.
+const maxLinkHops = 40
+
+type dockerArchive struct {
+	files map[string][]byte
+	links map[string]string
+}
+
+func (da *dockerArchive) populateFromTar(tr *tar.Reader) error {
+	for {
+		header, err := tr.Next()
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil
+		case err != nil:
+			return err
+		}
+
+		name := cleanPath(header.Name)
+		switch header.Typeflag {
+		case tar.TypeReg:
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				return fmt.Errorf("reading %q: %w", header.Name, err)
+			}
+			da.files[name] = content
+		case tar.TypeSymlink:
+			da.links[name] = cleanPath(path.Join(path.Dir(name), header.Linkname))
+		case tar.TypeLink:
+			da.links[name] = cleanPath(header.Linkname)
+		}
+	}
+}
+
+// file returns the content of the regular file at name in the archive,
+// following any links.
+func (da *dockerArchive) file(name string) ([]byte, error) {
+	name = cleanPath(name)
+	for hops := 0; hops <= maxLinkHops; hops++ {
+		if content, ok := da.files[name]; ok {
+			return content, nil
+		}
+		target, ok := da.links[name]
+		if !ok {
+			return nil, fmt.Errorf("archive is missing %s", name)
+		}
+		name = target
+	}
+	return nil, fmt.Errorf("%s: too many levels of links", name)
+}
+
+func (da *dockerArchive) loadImage(entry manifestEntry) (image.Image, error) {
+	configJSON, err := da.file(entry.Config)
+	if err != nil {
+		return image.Image{}, err
+	}
+	if err := image.CheckConfigBlob(configJSON); err != nil {
+		return image.Image{}, fmt.Errorf("%s: %w", entry.Config, err)
+	}
+	var config image.Config
+	if err := json.Unmarshal(configJSON, &config); err != nil {
+		return image.Image{}, fmt.Errorf("%s: %w", entry.Config, err)
+	}
+	if len(entry.Layers) != len(config.RootFS.DiffIDs) {
+		return image.Image{}, errors.New("manifest layer count does not match diff ID count")
+	}
+
+	img := image.Image{
+		Config: config,
+		Platform: specsv1.Platform{
+			OS:           config.OS,
+			Architecture: config.Architecture,
+			Variant:      config.Variant,
+			OSVersion:    config.OSVersion,
+			OSFeatures:   config.OSFeatures,
+		},
+	}
+	for i, layerPath := range entry.Layers {
+		blob, err := da.file(layerPath)
+		if err != nil {
+			return image.Image{}, err
+		}
+		layer, err := tarlayer.Import(bytes.NewReader(blob), tarlayer.Options{})
+		if err != nil {
+			return image.Image{}, fmt.Errorf("%s: %w", layerPath, err)
+		}
+		if layer.DiffID != config.RootFS.DiffIDs[i] {
+			return image.Image{}, fmt.Errorf("%s: content has diff ID %s but configuration has %s", layerPath, layer.DiffID, config.RootFS.DiffIDs[i])
+		}
+		img.Layers = append(img.Layers, layer)
+	}
+	return img, nil
+}
+
+// manifestDigest returns the digest of the OCI image manifest for img, with a
+// configuration blob encoded as JSON.
+func manifestDigest(img image.Image) (digest.Digest, error) {
+	configJSON, err := image.EncodeConfig(img.Config)
+	if err != nil {
+		return "", err
+	}
+	manifestJSON, err := json.Marshal(img.Manifest(specsv1.Descriptor{
+		MediaType: specsv1.MediaTypeImageConfig,
+		Digest:    digest.FromBytes(configJSON),
+		Size:      int64(len(configJSON)),
+	}))
+	if err != nil {
+		return "", err
+	}
+	return digest.FromBytes(manifestJSON), nil
+}
+
+// cleanPath returns the clean relative form of a path in the archive.
+func cleanPath(p string) string {
+	return path.Clean("/" + p)[1:]
+}
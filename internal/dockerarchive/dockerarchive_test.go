@@ -0,0 +1,200 @@
+package dockerarchive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/opencontainers/go-digest"
+
+	"go.alexhamlin.co/zeroimage/internal/image"
+	"go.alexhamlin.co/zeroimage/internal/ociarchive"
+	"go.alexhamlin.co/zeroimage/internal/tarbuild"
+	"go.alexhamlin.co/zeroimage/internal/tarlayer"
+)
+
+func TestLoad(t *testing.T) {
+	// Build an archive in the layout of older versions of "docker save", where
+	// a repeated layer is a symbolic link to its first copy.
+	builder := tarlayer.NewBuilderWithOptions(tarlayer.Options{Compression: tarlayer.Uncompressed})
+	builder.AddContent("hello.txt", []byte("hello world"))
+	layer, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build test layer: %v", err)
+	}
+	blob, err := layer.OpenBlob(context.Background())
+	if err != nil {
+		t.Fatalf("failed to open test layer: %v", err)
+	}
+	layerTar, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatalf("failed to read test layer: %v", err)
+	}
+
+	config := []byte(fmt.Sprintf(
+		`{"architecture":"arm64","variant":"v8","os":"linux","rootfs":{"type":"layers","diff_ids":[%q,%q]}}`,
+		layer.DiffID, layer.DiffID,
+	))
+	configName := digest.FromBytes(config).Encoded() + ".json"
+	manifest, _ := json.Marshal([]manifestEntry{{
+		Config:   configName,
+		RepoTags: []string{"example:latest"},
+		Layers:   []string{"one/layer.tar", "two/layer.tar"},
+	}})
+
+	var buf bytes.Buffer
+	tb := tarbuild.NewBuilder(&buf)
+	tb.AddContent(configName, config)
+	tb.AddContent("one/layer.tar", layerTar)
+	tb.AddContent(manifestFile, manifest)
+	if err := tb.Close(); err != nil {
+		t.Fatalf("failed to build test archive: %v", err)
+	}
+	// tarbuild cannot write symbolic links, so append one by hand in place of
+	// the footer.
+	archive := buf.Bytes()[:buf.Len()-1024]
+	var linkBuf bytes.Buffer
+	tw := tar.NewWriter(&linkBuf)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "two/layer.tar", Linkname: "../one/layer.tar"})
+	tw.Close()
+	archive = append(archive, linkBuf.Bytes()...)
+
+	index, err := Load(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("failed to load archive: %v", err)
+	}
+	if len(index) != 1 {
+		t.Fatalf("loaded %d image(s), want 1", len(index))
+	}
+	if got, want := platforms.Format(index[0].Platform), "linux/arm64/v8"; got != want {
+		t.Errorf("loaded image for %s, want %s", got, want)
+	}
+	img, err := index[0].GetImage(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load image: %v", err)
+	}
+	if len(img.Layers) != 2 {
+		t.Fatalf("loaded %d layer(s), want 2", len(img.Layers))
+	}
+	for i, l := range img.Layers {
+		if l.Descriptor.Digest != layer.Descriptor.Digest || l.Descriptor.MediaType != layer.Descriptor.MediaType {
+			t.Errorf("layer %d has descriptor %v, want %v", i, l.Descriptor, layer.Descriptor)
+		}
+	}
+
+	// The converted archive should reference the manifest digest that Load
+	// reported.
+	var converted bytes.Buffer
+	if err := ociarchive.WriteImage(img, &converted); err != nil {
+		t.Fatalf("failed to write OCI archive: %v", err)
+	}
+	ociIndex, err := ociarchive.Load(&converted)
+	if err != nil {
+		t.Fatalf("failed to load OCI archive: %v", err)
+	}
+	if ociIndex[0].Digest != index[0].Digest {
+		t.Errorf("OCI archive has manifest %s, want %s", ociIndex[0].Digest, index[0].Digest)
+	}
+}
+
+func TestLoadInvalidConfig(t *testing.T) {
+	config := []byte(`["linux"]`)
+	configName := digest.FromBytes(config).Encoded() + ".json"
+	manifest, _ := json.Marshal([]manifestEntry{{Config: configName}})
+
+	var buf bytes.Buffer
+	tb := tarbuild.NewBuilder(&buf)
+	tb.AddContent(configName, config)
+	tb.AddContent(manifestFile, manifest)
+	if err := tb.Close(); err != nil {
+		t.Fatalf("failed to build test archive: %v", err)
+	}
+
+	index, err := Load(&buf)
+	if err == nil {
+		_, err = index[0].GetImage(context.Background())
+	}
+	if !errors.Is(err, image.ErrInvalidConfigBlob) {
+		t.Errorf("got error %v, want %v", err, image.ErrInvalidConfigBlob)
+	}
+}
+
+func TestWriteImage(t *testing.T) {
+	// Ensure that a written archive names its image as requested, holds one
+	// copy of a repeated layer, and loads back with the same platform and
+	// layers.
+	builder := tarlayer.NewBuilder()
+	builder.AddContent("hello.txt", []byte("hello world"))
+	layer, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build test layer: %v", err)
+	}
+	var img image.Image
+	img.SetPlatform(platforms.MustParse("linux/arm64/v8"))
+	img.AppendLayer(layer)
+	img.AppendLayer(layer)
+
+	var buf bytes.Buffer
+	err = WriteImageWithOptions(img, &buf, WriteOptions{RepoTags: []string{"example.com/app:latest"}})
+	if err != nil {
+		t.Fatalf("failed to write archive: %v", err)
+	}
+
+	var (
+		entries  []manifestEntry
+		layerTar int
+	)
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read archive: %v", err)
+		}
+		switch hdr.Name {
+		case manifestFile:
+			if err := json.NewDecoder(tr).Decode(&entries); err != nil {
+				t.Fatalf("failed to decode %s: %v", manifestFile, err)
+			}
+		case blobPath(layer.Descriptor.Digest):
+			layerTar++
+		}
+	}
+	if len(entries) != 1 || len(entries[0].RepoTags) != 1 || entries[0].RepoTags[0] != "example.com/app:latest" {
+		t.Errorf("archive has manifest %+v, want one image tagged example.com/app:latest", entries)
+	}
+	if layerTar != 1 {
+		t.Errorf("archive has %d copies of the layer, want 1", layerTar)
+	}
+
+	index, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("failed to load written archive: %v", err)
+	}
+	if len(index) != 1 {
+		t.Fatalf("loaded %d image(s), want 1", len(index))
+	}
+	if got, want := platforms.Format(index[0].Platform), "linux/arm64/v8"; got != want {
+		t.Errorf("loaded image for %s, want %s", got, want)
+	}
+	loaded, err := index[0].GetImage(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load image: %v", err)
+	}
+	if len(loaded.Layers) != 2 {
+		t.Fatalf("loaded %d layer(s), want 2", len(loaded.Layers))
+	}
+	for i, l := range loaded.Layers {
+		if l.Descriptor.Digest != layer.Descriptor.Digest {
+			t.Errorf("layer %d has digest %s, want %s", i, l.Descriptor.Digest, layer.Descriptor.Digest)
+		}
+	}
+}
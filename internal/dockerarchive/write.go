@@ -0,0 +1,111 @@
+package dockerarchive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/opencontainers/go-digest"
+
+	"go.alexhamlin.co/zeroimage/internal/image"
+	"go.alexhamlin.co/zeroimage/internal/tarbuild"
+)
+
+// WriteOptions customizes the archives produced by WriteImageWithOptions.
+type WriteOptions struct {
+	// RepoTags, if set, are the names, like "example.com/app:latest", that
+	// "docker load" gives to the image. Without any, Docker loads the image
+	// without a name and prints its ID.
+	RepoTags []string
+}
+
+// WriteImage writes a single container image as a tar archive in the format
+// produced by "docker save", which "docker load" accepts, using the default
+// WriteOptions.
+func WriteImage(img image.Image, w io.Writer) error {
+	return WriteImageWithOptions(img, w, WriteOptions{})
+}
+
+// WriteImageWithOptions writes a single container image as a tar archive in
+// the format produced by "docker save", as customized by opts.
+// WriteImageWithOptions checks img with image.Image.Validate before writing
+// anything.
+//
+// Layer blobs are written as they are, without decompressing them, as "docker
+// load" accepts compressed layers. Docker archives have no image manifests, so
+// the annotations and artifact type of img are not written.
+func WriteImageWithOptions(img image.Image, w io.Writer, opts WriteOptions) error {
+	if err := img.Validate(); err != nil {
+		return err
+	}
+
+	tb := tarbuild.NewBuilder(w)
+	entry := manifestEntry{
+		RepoTags: opts.RepoTags,
+		Layers:   make([]string, len(img.Layers)),
+	}
+	written := make(map[digest.Digest]bool)
+	for i, layer := range img.Layers {
+		dgst := layer.Descriptor.Digest
+		if err := dgst.Validate(); err != nil {
+			return err
+		}
+		entry.Layers[i] = blobPath(dgst)
+		// An image may repeat a layer, but the archive only needs one copy of its
+		// blob.
+		if written[dgst] {
+			continue
+		}
+		written[dgst] = true
+		if err := addLayer(tb, entry.Layers[i], layer); err != nil {
+			return err
+		}
+	}
+
+	configJSON, err := image.EncodeConfig(img.Config)
+	if err != nil {
+		return err
+	}
+	// Docker identifies images by the SHA-256 digest of their configuration.
+	entry.Config = blobPath(digest.SHA256.FromBytes(configJSON))
+	tb.AddContent(entry.Config, configJSON)
+
+	manifestJSON, err := json.Marshal([]manifestEntry{entry})
+	if err != nil {
+		return err
+	}
+	tb.AddContent(manifestFile, manifestJSON)
+	return tb.Close()
+}
+
+// blobPath returns the path in the archive of the blob with the provided
+// digest, following the layout of recent versions of "docker save".
+func blobPath(dgst digest.Digest) string {
+	return "blobs/" + dgst.Algorithm().String() + "/" + dgst.Encoded()
+}
+
+// addLayer adds the blob of layer to the archive at path, and returns an error
+// if its content does not match the layer's descriptor.
+func addLayer(tb *tarbuild.Builder, path string, layer image.Layer) error {
+	blob, err := layer.OpenBlob(context.TODO())
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	desc := layer.Descriptor
+	verifier := desc.Digest.Verifier()
+	err = tb.Add(path, tarbuild.File{
+		Reader: io.TeeReader(blob, verifier),
+		Mode:   0644,
+		Size:   desc.Size,
+	})
+	if err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("content of layer %s does not match its digest", desc.Digest)
+	}
+	return nil
+}
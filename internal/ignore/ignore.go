@@ -0,0 +1,256 @@
+// Package ignore excludes files from directory trees using patterns with the
+// semantics of .dockerignore files.
+package ignore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Matcher decides whether paths are excluded by a list of patterns.
+//
+// Each pattern is matched against slash-separated paths relative to the root of
+// a directory tree. In addition to the syntax of path.Match, a "**" matches any
+// number of directories, including none. A pattern matches a path if it
+// matches the path itself or any of its parent directories. A pattern starting
+// with "!" is an exception that re-includes the paths that it matches. When
+// several patterns match a path, the last one wins.
+type Matcher struct {
+	patterns   []pattern
+	exceptions bool
+}
+
+type pattern struct {
+	re        *regexp.Regexp
+	exception bool
+}
+
+// New returns a Matcher for the provided patterns. Empty patterns are ignored.
+func New(patterns []string) (*Matcher, error) {
+	var m Matcher
+	for _, raw := range patterns {
+		text := strings.TrimSpace(raw)
+		exception := strings.HasPrefix(text, "!")
+		if exception {
+			text = strings.TrimSpace(text[1:])
+		}
+		if text == "" {
+			continue
+		}
+
+		text = strings.TrimPrefix(path.Clean("/"+text), "/")
+		if text == "" {
+			continue
+		}
+		re, err := compilePattern(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", raw, err)
+		}
+		m.patterns = append(m.patterns, pattern{re: re, exception: exception})
+		m.exceptions = m.exceptions || exception
+	}
+	return &m, nil
+}
+
+// ReadFile returns the patterns in the .dockerignore-style file at path, one
+// per line, skipping blank lines and comments that start with "#".
+func ReadFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+// Match returns true if the patterns of m exclude the slash-separated path
+// name.
+func (m *Matcher) Match(name string) bool {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	excluded := false
+	for _, p := range m.patterns {
+		// A pattern can only change the outcome if it is an exception to an
+		// exclusion, or an exclusion of a path not yet excluded.
+		if p.exception != excluded {
+			continue
+		}
+		if p.matches(name) {
+			excluded = !p.exception
+		}
+	}
+	return excluded
+}
+
+// matches returns true if p matches name or any of its parent directories.
+func (p pattern) matches(name string) bool {
+	for ; name != "." && name != ""; name = path.Dir(name) {
+		if p.re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// compilePattern converts a single pattern into an anchored regular
+// expression.
+func compilePattern(text string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '*':
+			if i+1 < len(text) && text[i+1] == '*' {
+				i++
+				if i+1 < len(text) && text[i+1] == '/' {
+					i++
+					sb.WriteString("(.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '\\':
+			if i+1 == len(text) {
+				return nil, errors.New("trailing backslash")
+			}
+			i++
+			sb.WriteString(regexp.QuoteMeta(text[i : i+1]))
+		case '[':
+			end := strings.IndexByte(text[i+1:], ']')
+			if end < 0 {
+				return nil, errors.New("unterminated character class")
+			}
+			class := text[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+// Filter returns a file system that hides the entries of fsys excluded by m
+// from Open and ReadDir, so that fs.WalkDir skips them. Directories that
+// become empty because all of their contents are excluded are hidden as well,
+// while directories that were empty to begin with are kept. An excluded
+// directory remains visible if an exception pattern re-includes any of its
+// contents.
+func Filter(fsys fs.FS, m *Matcher) fs.FS {
+	return &filterFS{
+		fsys:    fsys,
+		matcher: m,
+		visible: make(map[string]bool),
+	}
+}
+
+type filterFS struct {
+	fsys    fs.FS
+	matcher *Matcher
+
+	mu      sync.Mutex
+	visible map[string]bool
+}
+
+func (f *filterFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name != "." {
+		stat, err := fs.Stat(f.fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		visible, err := f.isVisible(name, stat.IsDir())
+		if err != nil {
+			return nil, err
+		}
+		if !visible {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+	}
+	return f.fsys.Open(name)
+}
+
+func (f *filterFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(f.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	kept := entries[:0]
+	for _, entry := range entries {
+		visible, err := f.isVisible(path.Join(name, entry.Name()), entry.IsDir())
+		if err != nil {
+			return nil, err
+		}
+		if visible {
+			kept = append(kept, entry)
+		}
+	}
+	return kept, nil
+}
+
+// isVisible returns true if the entry at name should appear in the filtered
+// file system.
+func (f *filterFS) isVisible(name string, isDir bool) (bool, error) {
+	excluded := f.matcher.Match(name)
+	if !isDir {
+		return !excluded, nil
+	}
+	if excluded && !f.matcher.exceptions {
+		return false, nil
+	}
+
+	f.mu.Lock()
+	visible, ok := f.visible[name]
+	f.mu.Unlock()
+	if ok {
+		return visible, nil
+	}
+
+	entries, err := f.ReadDir(name)
+	if err != nil {
+		return false, err
+	}
+	if excluded {
+		visible = len(entries) > 0
+	} else {
+		visible = len(entries) > 0 || !f.hadEntries(name)
+	}
+
+	f.mu.Lock()
+	f.visible[name] = visible
+	f.mu.Unlock()
+	return visible, nil
+}
+
+// hadEntries returns true if the directory at name in the unfiltered file
+// system has any entries.
+func (f *filterFS) hadEntries(name string) bool {
+	entries, err := fs.ReadDir(f.fsys, name)
+	return err == nil && len(entries) > 0
+}
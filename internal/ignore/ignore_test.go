@@ -0,0 +1,125 @@
+package ignore
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMatch(t *testing.T) {
+	testCases := []struct {
+		Patterns []string
+		Path     string
+		Want     bool
+	}{
+		{[]string{".git"}, ".git", true},
+		{[]string{".git"}, ".git/config", true},
+		{[]string{".git"}, "sub/.git", false},
+		{[]string{"/.git/"}, ".git/config", true},
+		{[]string{"*.log"}, "error.log", true},
+		{[]string{"*.log"}, "logs/error.log", false},
+		{[]string{"**/*.log"}, "logs/error.log", true},
+		{[]string{"**/*.log"}, "error.log", true},
+		{[]string{"logs/**"}, "logs/a/b.txt", true},
+		{[]string{"file?.txt"}, "file1.txt", true},
+		{[]string{"file?.txt"}, "file10.txt", false},
+		{[]string{"file[0-9].txt"}, "file5.txt", true},
+		{[]string{"file[!0-9].txt"}, "file5.txt", false},
+		{[]string{`\*.txt`}, "*.txt", true},
+		{[]string{`\*.txt`}, "a.txt", false},
+		{[]string{"*.md", "!README.md"}, "README.md", false},
+		{[]string{"*.md", "!README.md"}, "CHANGES.md", true},
+		{[]string{"!README.md", "*.md"}, "README.md", true},
+		{[]string{"docs", "!docs/keep"}, "docs/keep/a.txt", false},
+		{[]string{"docs", "!docs/keep"}, "docs/drop.txt", true},
+		{[]string{"", "  "}, "anything", false},
+	}
+
+	for _, tc := range testCases {
+		m, err := New(tc.Patterns)
+		if err != nil {
+			t.Fatalf("New(%q) failed: %v", tc.Patterns, err)
+		}
+		if got := m.Match(tc.Path); got != tc.Want {
+			t.Errorf("patterns %q: Match(%q) = %v, want %v", tc.Patterns, tc.Path, got, tc.Want)
+		}
+	}
+}
+
+func TestNewInvalidPattern(t *testing.T) {
+	for _, p := range []string{"file[0-9", `trailing\`} {
+		if _, err := New([]string{p}); err == nil {
+			t.Errorf("New accepted invalid pattern %q", p)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app":                  &fstest.MapFile{Data: []byte("app")},
+		"debug.log":            &fstest.MapFile{Data: []byte("log")},
+		".git/config":          &fstest.MapFile{Data: []byte("config")},
+		"logs/today.log":       &fstest.MapFile{Data: []byte("log")},
+		"data/empty":           &fstest.MapFile{Mode: fs.ModeDir | 0755},
+		"data/file.txt":        &fstest.MapFile{Data: []byte("data")},
+		"docs/guide.md":        &fstest.MapFile{Data: []byte("guide")},
+		"docs/keep/README.md":  &fstest.MapFile{Data: []byte("readme")},
+		"docs/drop/notes.md":   &fstest.MapFile{Data: []byte("notes")},
+		"docs/drop/nested/x.y": &fstest.MapFile{Data: []byte("x")},
+	}
+	m, err := New([]string{".git", "**/*.log", "docs", "!docs/keep"})
+	if err != nil {
+		t.Fatalf("failed to create matcher: %v", err)
+	}
+
+	var got []string
+	err = fs.WalkDir(Filter(fsys, m), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		got = append(got, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk filtered file system: %v", err)
+	}
+
+	want := []string{
+		".",
+		"app",
+		"data",
+		"data/empty",
+		"data/file.txt",
+		"docs",
+		"docs/keep",
+		"docs/keep/README.md",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected walk of filtered file system (-want +got):\n%s", diff)
+	}
+
+	if _, err := Filter(fsys, m).Open("debug.log"); err == nil {
+		t.Errorf("opened excluded file")
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".dockerignore")
+	content := "# Version control\n.git\n\n  *.log  \n!keep.log\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write ignore file: %v", err)
+	}
+
+	got, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read ignore file: %v", err)
+	}
+	want := []string{".git", "*.log", "!keep.log"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected patterns (-want +got):\n%s", diff)
+	}
+}
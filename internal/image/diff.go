@@ -0,0 +1,155 @@
+package image
+
+import (
+	"encoding/json"
+	"sort"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Differences describes the ways in which two images differ.
+type Differences struct {
+	Layers      []LayerDifference
+	Config      []ValueDifference
+	Annotations []ValueDifference
+}
+
+// LayerDifference describes a pair of layers at the same position in two
+// images whose digests do not match. When one image has fewer layers than the
+// other, the descriptor for the missing layer is the zero value.
+type LayerDifference struct {
+	Index int
+	A, B  specsv1.Descriptor
+}
+
+// ValueDifference describes a named value that differs between two images.
+// Values are represented in their JSON encoding, and a value that is missing
+// from one of the images is represented by the empty string.
+type ValueDifference struct {
+	Name string
+	A, B string
+}
+
+// Empty returns true if d does not contain any differences.
+func (d Differences) Empty() bool {
+	return len(d.Layers) == 0 && len(d.Config) == 0 && len(d.Annotations) == 0
+}
+
+// Diff compares the layer digests, configurations, and manifest annotations of
+// two images.
+func Diff(a, b Image) Differences {
+	var d Differences
+
+	for i := 0; i < len(a.Layers) || i < len(b.Layers); i++ {
+		var la, lb specsv1.Descriptor
+		if i < len(a.Layers) {
+			la = a.Layers[i].Descriptor
+		}
+		if i < len(b.Layers) {
+			lb = b.Layers[i].Descriptor
+		}
+		if la.Digest != lb.Digest {
+			d.Layers = append(d.Layers, LayerDifference{Index: i, A: la, B: lb})
+		}
+	}
+
+	ca, cb := a.Config, b.Config
+	d.Config = diffValues(map[string][2]interface{}{
+		"Created":      {ca.Created, cb.Created},
+		"Author":       {ca.Author, cb.Author},
+		"Architecture": {ca.Architecture, cb.Architecture},
+		"OS":           {ca.OS, cb.OS},
+		"OSVersion":    {ca.OSVersion, cb.OSVersion},
+		"OSFeatures":   {ca.OSFeatures, cb.OSFeatures},
+		"Variant":      {ca.Variant, cb.Variant},
+		"User":         {ca.Config.User, cb.Config.User},
+		"ExposedPorts": {ca.Config.ExposedPorts, cb.Config.ExposedPorts},
+		"Env":          {ca.Config.Env, cb.Config.Env},
+		"Entrypoint":   {ca.Config.Entrypoint, cb.Config.Entrypoint},
+		"Cmd":          {ca.Config.Cmd, cb.Config.Cmd},
+		"Volumes":      {ca.Config.Volumes, cb.Config.Volumes},
+		"WorkingDir":   {ca.Config.WorkingDir, cb.Config.WorkingDir},
+		"Labels":       {ca.Config.Labels, cb.Config.Labels},
+		"StopSignal":   {ca.Config.StopSignal, cb.Config.StopSignal},
+		"Healthcheck":  {ca.Config.Healthcheck, cb.Config.Healthcheck},
+		"History":      {ca.History, cb.History},
+	})
+
+	d.Annotations = diffAnnotations(a.Annotations, b.Annotations)
+
+	return d
+}
+
+// diffValues returns a ValueDifference for each named pair of values whose JSON
+// encodings differ, sorted by name.
+func diffValues(values map[string][2]interface{}) []ValueDifference {
+	var diffs []ValueDifference
+	for name, pair := range values {
+		a, b := encodeDiffValue(pair[0]), encodeDiffValue(pair[1])
+		if a != b {
+			diffs = append(diffs, ValueDifference{Name: name, A: a, B: b})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Name < diffs[j].Name })
+	return diffs
+}
+
+// diffAnnotations returns a ValueDifference for each annotation whose value
+// differs between a and b, sorted by name. Unlike diffValues, it compares
+// annotations by the presence of their keys, so that an annotation set to the
+// empty string differs from a missing one.
+func diffAnnotations(a, b map[string]string) []ValueDifference {
+	var diffs []ValueDifference
+	add := func(name string) {
+		va, oka := a[name]
+		vb, okb := b[name]
+		if oka == okb && va == vb {
+			return
+		}
+		diff := ValueDifference{Name: name}
+		if oka {
+			diff.A = encodeAnnotation(va)
+		}
+		if okb {
+			diff.B = encodeAnnotation(vb)
+		}
+		diffs = append(diffs, diff)
+	}
+	for name := range a {
+		add(name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			add(name)
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Name < diffs[j].Name })
+	return diffs
+}
+
+// encodeAnnotation returns the JSON encoding of an annotation value, which is
+// never the empty string, even when the value itself is empty.
+func encodeAnnotation(v string) string {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(encoded)
+}
+
+func encodeDiffValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		// The values we compare come from JSON documents to begin with.
+		panic(err)
+	}
+	switch s := string(encoded); s {
+	case "null", `""`, "[]", "{}":
+		return ""
+	default:
+		return s
+	}
+}
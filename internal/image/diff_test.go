@@ -0,0 +1,123 @@
+package image_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"go.alexhamlin.co/zeroimage/internal/image"
+)
+
+func TestDiff(t *testing.T) {
+	layer := func(content string) image.Layer {
+		return image.Layer{Descriptor: specsv1.Descriptor{
+			MediaType: specsv1.MediaTypeImageLayerGzip,
+			Digest:    digest.FromString(content),
+			Size:      int64(len(content)),
+		}}
+	}
+	base := func() image.Image {
+		var img image.Image
+		img.AppendLayer(layer("a"))
+		img.AppendLayer(layer("b"))
+		img.Config.OS = "linux"
+		img.Config.Architecture = "amd64"
+		img.Config.Config.Entrypoint = []string{"/app"}
+		img.Annotations = map[string]string{"org.example.kept": "yes"}
+		return img
+	}
+
+	testCases := []struct {
+		Description string
+		Change      func(img *image.Image)
+		Want        image.Differences
+	}{
+		{
+			Description: "identical images",
+			Change:      func(img *image.Image) {},
+		},
+		{
+			Description: "extra layer",
+			Change:      func(img *image.Image) { img.AppendLayer(layer("c")) },
+			Want: image.Differences{
+				Layers: []image.LayerDifference{{Index: 2, B: layer("c").Descriptor}},
+			},
+		},
+		{
+			Description: "missing layer",
+			Change:      func(img *image.Image) { img.RemoveLayers(1) },
+			Want: image.Differences{
+				Layers: []image.LayerDifference{{Index: 1, A: layer("b").Descriptor}},
+			},
+		},
+		{
+			Description: "changed layer digest",
+			Change:      func(img *image.Image) { img.Layers[0] = layer("z") },
+			Want: image.Differences{
+				Layers: []image.LayerDifference{{Index: 0, A: layer("a").Descriptor, B: layer("z").Descriptor}},
+			},
+		},
+		{
+			Description: "changed config fields",
+			Change: func(img *image.Image) {
+				img.Config.Architecture = "arm64"
+				img.Config.Config.Entrypoint = []string{"/other"}
+				img.Config.Config.Env = []string{"A=1"}
+			},
+			Want: image.Differences{
+				Config: []image.ValueDifference{
+					{Name: "Architecture", A: `"amd64"`, B: `"arm64"`},
+					{Name: "Entrypoint", A: `["/app"]`, B: `["/other"]`},
+					{Name: "Env", B: `["A=1"]`},
+				},
+			},
+		},
+		{
+			Description: "empty and missing config values",
+			Change:      func(img *image.Image) { img.Config.Config.Env = []string{} },
+		},
+		{
+			Description: "added and removed annotations",
+			Change: func(img *image.Image) {
+				img.Annotations = map[string]string{"org.example.added": "new"}
+			},
+			Want: image.Differences{
+				Annotations: []image.ValueDifference{
+					{Name: "org.example.added", B: `"new"`},
+					{Name: "org.example.kept", A: `"yes"`},
+				},
+			},
+		},
+		{
+			Description: "empty and missing annotations",
+			Change:      func(img *image.Image) { img.Annotations["org.example.empty"] = "" },
+			Want: image.Differences{
+				Annotations: []image.ValueDifference{{Name: "org.example.empty", B: `""`}},
+			},
+		},
+		{
+			Description: "changed annotation",
+			Change:      func(img *image.Image) { img.Annotations["org.example.kept"] = "no" },
+			Want: image.Differences{
+				Annotations: []image.ValueDifference{{Name: "org.example.kept", A: `"yes"`, B: `"no"`}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Description, func(t *testing.T) {
+			a, b := base(), base()
+			tc.Change(&b)
+
+			got := image.Diff(a, b)
+			if diff := cmp.Diff(tc.Want, got); diff != "" {
+				t.Errorf("unexpected differences (-want +got):\n%s", diff)
+			}
+			if got.Empty() != tc.Want.Empty() {
+				t.Errorf("Empty() = %v, want %v", got.Empty(), tc.Want.Empty())
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package image
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Prefixes of the special file names that represent whiteouts in the layers of
+// an OCI image.
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// ErrFileNotFound is the cause of an error resulting from an attempt to open a
+// path that does not exist in the filesystem produced by an image's layers.
+var ErrFileNotFound = errors.New("file not found in image")
+
+// maxSymlinkHops is the maximum number of symbolic links that OpenFile will
+// follow while resolving a path, matching the Linux limit.
+const maxSymlinkHops = 40
+
+// File represents an entry in the filesystem produced by an image's layers.
+type File struct {
+	// Path is the clean path of the file relative to the root of the
+	// filesystem, without a leading slash.
+	Path string
+	// Header is the header of the tar entry that produced the file.
+	Header *tar.Header
+	// Layer is the index of the layer in the image that produced the file.
+	Layer int
+}
+
+// Files returns the entries in the filesystem produced by applying the layers
+// of img in order, respecting whiteouts, sorted by path.
+func (img Image) Files(ctx context.Context) ([]File, error) {
+	files := newFileTree()
+	for i, layer := range img.Layers {
+		if err := files.applyLayer(ctx, i, layer); err != nil {
+			return nil, fmt.Errorf("layer %d: %w", i, err)
+		}
+	}
+
+	result := make([]File, 0, len(files.files))
+	for _, f := range files.files {
+		result = append(result, f)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result, nil
+}
+
+// Lookup returns the final entries at the provided paths in the filesystem
+// produced by the layers of img, keyed by their clean relative paths as in
+// File.Path. Paths without an entry are missing from the result. Like Files,
+// Lookup never returns an entry for the root directory, which is resolved
+// without reading any layer.
+//
+// Unlike Files, Lookup searches the layers from the top down, and stops reading
+// them as soon as every path is resolved, either by an entry or by a whiteout
+// or other entry that hides the path from lower layers.
+func (img Image) Lookup(ctx context.Context, paths ...string) (map[string]File, error) {
+	found := make(map[string]File)
+	pending := make(map[string]bool, len(paths))
+	// covered maps each pending path and each of its parent directories to the
+	// pending paths at or beneath it.
+	covered := make(map[string][]string)
+	for _, p := range paths {
+		p = cleanLayerPath(p)
+		if p == "." || pending[p] {
+			continue
+		}
+		pending[p] = true
+		for dir := p; ; dir = path.Dir(dir) {
+			covered[dir] = append(covered[dir], p)
+			if dir == "." {
+				break
+			}
+		}
+	}
+
+	for i := len(img.Layers) - 1; i >= 0 && len(pending) > 0; i-- {
+		hidden, err := lookupLayer(ctx, img.Layers[i], i, covered, pending, found)
+		if err != nil {
+			return nil, fmt.Errorf("layer %d: %w", i, err)
+		}
+		for p := range hidden {
+			delete(pending, p)
+		}
+		for p := range found {
+			delete(pending, p)
+		}
+	}
+	return found, nil
+}
+
+// lookupLayer adds the entries of a single layer at pending paths to found,
+// and returns the pending paths that the layer hides from lower layers without
+// providing its own entry for them.
+func lookupLayer(ctx context.Context, layer Layer, index int, covered map[string][]string, pending map[string]bool, found map[string]File) (map[string]bool, error) {
+	tr, err := layer.OpenTar(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tr.Close()
+
+	hidden := make(map[string]bool)
+	hide := func(dir string, includeDir bool) {
+		for _, p := range covered[dir] {
+			if pending[p] && (includeDir || p != dir) {
+				hidden[p] = true
+			}
+		}
+	}
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		p := cleanLayerPath(header.Name)
+		if p == "." {
+			continue
+		}
+
+		dir, base := path.Split(p)
+		dir = cleanLayerPath(dir)
+		switch {
+		case base == whiteoutOpaque:
+			hide(dir, false)
+		case strings.HasPrefix(base, whiteoutPrefix):
+			hide(path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)), true)
+		default:
+			if pending[p] {
+				found[p] = File{Path: p, Header: header, Layer: index}
+			}
+			if header.Typeflag != tar.TypeDir {
+				hide(p, false)
+			}
+		}
+	}
+	return hidden, nil
+}
+
+// OpenFile opens the final version of the regular file at path p in the
+// filesystem produced by the layers of img, following any symbolic or hard
+// links at that path. OpenFile does not resolve symbolic links in the parent
+// directories of p. The client must close the returned reader.
+func (img Image) OpenFile(ctx context.Context, p string) (io.ReadCloser, error) {
+	files, err := img.Files(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byPath := make(map[string]File, len(files))
+	for _, f := range files {
+		byPath[f.Path] = f
+	}
+
+	p = cleanLayerPath(p)
+	for hops := 0; ; hops++ {
+		f, ok := byPath[p]
+		if !ok {
+			return nil, fmt.Errorf("%w: /%s", ErrFileNotFound, p)
+		}
+
+		switch f.Header.Typeflag {
+		case tar.TypeReg:
+			return openLayerFile(ctx, img.Layers[f.Layer], f.Path)
+		case tar.TypeSymlink, tar.TypeLink:
+			if hops >= maxSymlinkHops {
+				return nil, fmt.Errorf("/%s: too many levels of links", p)
+			}
+			target := f.Header.Linkname
+			if f.Header.Typeflag == tar.TypeSymlink && !path.IsAbs(target) {
+				target = path.Join(path.Dir(p), target)
+			}
+			p = cleanLayerPath(target)
+		default:
+			return nil, fmt.Errorf("/%s is not a regular file", p)
+		}
+	}
+}
+
+// openLayerFile returns a reader positioned at the content of the entry for p
+// in the tar archive of layer.
+func openLayerFile(ctx context.Context, layer Layer, p string) (io.ReadCloser, error) {
+	tr, err := layer.OpenTar(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			tr.Close()
+			return nil, fmt.Errorf("%w: /%s", ErrFileNotFound, p)
+		} else if err != nil {
+			tr.Close()
+			return nil, err
+		}
+		if cleanLayerPath(header.Name) == p {
+			return tr, nil
+		}
+	}
+}
+
+// fileTree holds the entries of a filesystem along with an index of the
+// children of each directory, so that removing a directory's contents only
+// visits the entries being removed.
+type fileTree struct {
+	files    map[string]File
+	children map[string]map[string]struct{}
+}
+
+func newFileTree() *fileTree {
+	return &fileTree{
+		files:    make(map[string]File),
+		children: make(map[string]map[string]struct{}),
+	}
+}
+
+// add adds f to the tree, replacing any existing entry at the same path.
+func (t *fileTree) add(f File) {
+	t.files[f.Path] = f
+	parent := path.Dir(f.Path)
+	if t.children[parent] == nil {
+		t.children[parent] = make(map[string]struct{})
+	}
+	t.children[parent][f.Path] = struct{}{}
+}
+
+// remove removes the entry at p from the tree, along with everything beneath
+// it.
+func (t *fileTree) remove(p string) {
+	delete(t.files, p)
+	delete(t.children[path.Dir(p)], p)
+	t.removeChildren(p)
+}
+
+// removeChildren removes all entries beneath dir from the tree.
+func (t *fileTree) removeChildren(dir string) {
+	for child := range t.children[dir] {
+		delete(t.files, child)
+		t.removeChildren(child)
+	}
+	delete(t.children, dir)
+}
+
+// applyLayer updates the tree with the entries of a single layer. Whiteouts in
+// the layer only apply to entries from lower layers, so they are processed
+// before the layer's own entries are added.
+func (t *fileTree) applyLayer(ctx context.Context, index int, layer Layer) error {
+	tr, err := layer.OpenTar(ctx)
+	if err != nil {
+		return err
+	}
+	defer tr.Close()
+
+	var (
+		removed []string
+		opaque  []string
+		added   []File
+	)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		p := cleanLayerPath(header.Name)
+		if p == "." {
+			continue
+		}
+
+		dir, base := path.Split(p)
+		dir = cleanLayerPath(dir)
+		switch {
+		case base == whiteoutOpaque:
+			opaque = append(opaque, dir)
+		case strings.HasPrefix(base, whiteoutPrefix):
+			removed = append(removed, path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)))
+		default:
+			added = append(added, File{Path: p, Header: header, Layer: index})
+		}
+	}
+
+	for _, p := range removed {
+		t.remove(p)
+	}
+	for _, dir := range opaque {
+		t.removeChildren(dir)
+	}
+	for _, f := range added {
+		if f.Header.Typeflag != tar.TypeDir {
+			t.removeChildren(f.Path)
+		}
+		t.add(f)
+	}
+	return nil
+}
+
+// cleanLayerPath returns the clean relative form of a path from a layer, where
+// the root is represented as ".".
+func cleanLayerPath(p string) string {
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
@@ -0,0 +1,192 @@
+package image_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	// Required by github.com/opencontainers/go-digest
+	_ "crypto/sha256"
+
+	"github.com/google/go-cmp/cmp"
+
+	"go.alexhamlin.co/zeroimage/internal/image"
+	"go.alexhamlin.co/zeroimage/internal/tarlayer"
+)
+
+func TestFiles(t *testing.T) {
+	layers := [][]string{
+		{"etc/hostname", "etc/passwd", "usr/lib/libfoo.so", "usr/lib/libbar.so", "tmp/scratch"},
+		{"etc/.wh.passwd", ".wh.tmp", "usr/lib/libbaz.so"},
+		{"usr/lib/.wh..wh..opq", "usr/lib/libqux.so", "etc/hostname"},
+	}
+
+	var img image.Image
+	for _, paths := range layers {
+		builder := tarlayer.NewBuilder()
+		for _, p := range paths {
+			builder.AddContent(p, []byte(p))
+		}
+		layer, err := builder.Finish()
+		if err != nil {
+			t.Fatalf("failed to build layer: %v", err)
+		}
+		img.AppendLayer(layer)
+	}
+
+	files, err := img.Files(context.Background())
+	if err != nil {
+		t.Fatalf("failed to read files: %v", err)
+	}
+
+	type fileLayer struct {
+		Path  string
+		Layer int
+	}
+	got := make([]fileLayer, len(files))
+	for i, f := range files {
+		got[i] = fileLayer{f.Path, f.Layer}
+	}
+	want := []fileLayer{
+		{"etc", 2},
+		{"etc/hostname", 2},
+		{"usr", 2},
+		{"usr/lib", 2},
+		{"usr/lib/libqux.so", 2},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected files (-want +got):\n%s", diff)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	layers := [][]string{
+		{"etc/hostname", "etc/passwd", "usr/lib/libfoo.so", "tmp/scratch"},
+		{"etc/.wh.passwd", ".wh.tmp", "usr/lib/libbaz.so"},
+		{"usr/lib/.wh..wh..opq", "usr/lib/libqux.so", "etc/hostname"},
+	}
+
+	var img image.Image
+	for _, paths := range layers {
+		builder := tarlayer.NewBuilder()
+		for _, p := range paths {
+			builder.AddContent(p, []byte(p))
+		}
+		layer, err := builder.Finish()
+		if err != nil {
+			t.Fatalf("failed to build layer: %v", err)
+		}
+		img.AppendLayer(layer)
+	}
+
+	found, err := img.Lookup(context.Background(),
+		"/etc/hostname", "/etc/passwd", "/usr/lib/libfoo.so", "/usr/lib/libqux.so",
+		"/tmp/scratch", "/etc", "/missing")
+	if err != nil {
+		t.Fatalf("failed to look up files: %v", err)
+	}
+	got := make(map[string]int)
+	for p, f := range found {
+		got[p] = f.Layer
+	}
+	want := map[string]int{
+		"etc/hostname":      2,
+		"usr/lib/libqux.so": 2,
+		"etc":               2,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected files (-want +got):\n%s", diff)
+	}
+
+	// Paths resolved by the top layer, along with the root directory, should not
+	// require reading any lower layer.
+	img.Layers[0].OpenBlob = func(context.Context) (io.ReadCloser, error) {
+		return nil, errors.New("lower layer was read")
+	}
+	if _, err := img.Lookup(context.Background(), "/", "/etc/hostname", "/usr/lib/libfoo.so"); err != nil {
+		t.Errorf("failed to look up files from the top layer: %v", err)
+	}
+}
+
+func TestFilesLargeLayer(t *testing.T) {
+	// Ensure that whiteouts and replaced entries in a layer with many entries
+	// only visit the entries they remove, by building a filesystem large enough
+	// that scanning every entry for each change would take far too long.
+	const (
+		dirs        = 100
+		filesPerDir = 400
+	)
+	builder := tarlayer.NewBuilderWithOptions(tarlayer.Options{Compression: tarlayer.Uncompressed})
+	for i := 0; i < dirs; i++ {
+		for j := 0; j < filesPerDir; j++ {
+			builder.AddContent(fmt.Sprintf("d%d/f%d", i, j), nil)
+		}
+	}
+	base, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build layer: %v", err)
+	}
+
+	builder = tarlayer.NewBuilder()
+	builder.AddContent(".wh.d0", nil)
+	builder.AddContent("d1/.wh..wh..opq", nil)
+	builder.AddContent("d2/new", nil)
+	top, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build layer: %v", err)
+	}
+
+	var img image.Image
+	img.AppendLayer(base)
+	img.AppendLayer(top)
+
+	files, err := img.Files(context.Background())
+	if err != nil {
+		t.Fatalf("failed to read files: %v", err)
+	}
+	// d0 and its files are removed, and the files of d1 are hidden, while
+	// d2/new is added.
+	want := dirs + dirs*filesPerDir - (filesPerDir + 1) - filesPerDir + 1
+	if len(files) != want {
+		t.Errorf("got %d files, want %d", len(files), want)
+	}
+	for _, f := range files {
+		if f.Path == "d0" || strings.HasPrefix(f.Path, "d0/") || strings.HasPrefix(f.Path, "d1/") {
+			t.Errorf("found removed file %s", f.Path)
+		}
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	var img image.Image
+	for _, content := range []string{"original", "replaced"} {
+		builder := tarlayer.NewBuilder()
+		builder.AddContent("etc/hostname", []byte(content))
+		layer, err := builder.Finish()
+		if err != nil {
+			t.Fatalf("failed to build layer: %v", err)
+		}
+		img.AppendLayer(layer)
+	}
+
+	file, err := img.OpenFile(context.Background(), "/etc/hostname")
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if string(content) != "replaced" {
+		t.Errorf("got content %q, want %q", content, "replaced")
+	}
+
+	if _, err := img.OpenFile(context.Background(), "/etc/passwd"); !errors.Is(err, image.ErrFileNotFound) {
+		t.Errorf("got error %v opening missing file, want %v", err, image.ErrFileNotFound)
+	}
+}
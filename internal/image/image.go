@@ -5,11 +5,14 @@ package image
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"sort"
+	"time"
 
 	"github.com/containerd/containerd/platforms"
 	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
 	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
@@ -21,6 +24,9 @@ type Index []IndexEntry
 // an OCI image index.
 type IndexEntry struct {
 	Platform specsv1.Platform
+	// Digest represents the digest of the image manifest referenced by this
+	// entry.
+	Digest   digest.Digest
 	GetImage func(context.Context) (Image, error)
 }
 
@@ -28,7 +34,16 @@ type IndexEntry struct {
 // that are compatible with the provided platform, in order of decreasing
 // preference, following standard platform matching rules as defined by
 // https://pkg.go.dev/github.com/containerd/containerd/platforms.
+//
+// As a special case, if idx contains a single image whose platform specifies
+// neither an OS nor an architecture, as with some single image archives of
+// scratch images, SelectByPlatform assumes that the image is compatible with
+// any platform and returns idx unchanged.
 func (idx Index) SelectByPlatform(platform specsv1.Platform) Index {
+	if len(idx) == 1 && idx[0].Platform.OS == "" && idx[0].Platform.Architecture == "" {
+		return idx
+	}
+
 	matcher := platforms.Only(platform)
 
 	var selected Index
@@ -45,6 +60,18 @@ func (idx Index) SelectByPlatform(platform specsv1.Platform) Index {
 	return selected
 }
 
+// SelectByDigest returns a new Index containing the subset of images in idx
+// whose manifests match the provided digest.
+func (idx Index) SelectByDigest(dgst digest.Digest) Index {
+	var selected Index
+	for _, img := range idx {
+		if img.Digest == dgst {
+			selected = append(selected, img)
+		}
+	}
+	return selected
+}
+
 // Image represents a platform specific container image.
 type Image struct {
 	Layers []Layer
@@ -56,6 +83,10 @@ type Image struct {
 	// Annotations represents the "annotations" value for the OCI image manifest
 	// associated with this image.
 	Annotations map[string]string
+	// ArtifactType represents the "artifactType" value for the OCI image
+	// manifest associated with this image, for images that package artifacts
+	// other than container filesystems.
+	ArtifactType string
 }
 
 // Config represents an OCI image configuration structure, extended with
@@ -66,13 +97,53 @@ type Config struct {
 	OSVersion  string   `json:"os.version,omitempty"`
 	OSFeatures []string `json:"os.features,omitempty"`
 	Variant    string   `json:"variant,omitempty"`
+	// Config shadows the Config field of the embedded specsv1.Image to support
+	// Docker extensions to the execution parameters.
+	Config ExecConfig `json:"config,omitempty"`
+}
+
+// ExecConfig represents the execution parameters of an OCI image
+// configuration, extended with properties that Docker defines for its own
+// images.
+type ExecConfig struct {
+	specsv1.ImageConfig
+	Healthcheck *HealthConfig `json:"Healthcheck,omitempty"`
+}
+
+// Manifest represents an OCI image manifest, extended with properties defined
+// by the spec but not implemented in the upstream Go type as of this writing.
+type Manifest struct {
+	specsv1.Manifest
+	ArtifactType string              `json:"artifactType,omitempty"`
+	Subject      *specsv1.Descriptor `json:"subject,omitempty"`
+}
+
+// HealthConfig represents the Docker definition of a container healthcheck.
+type HealthConfig struct {
+	// Test is the command that checks the health of the container. It takes one
+	// of the following forms:
+	//
+	//   {} - Inherit the healthcheck from the base image.
+	//   {"NONE"} - Disable the healthcheck.
+	//   {"CMD", args...} - Execute the command directly.
+	//   {"CMD-SHELL", command} - Run the command with the image's default shell.
+	Test []string `json:",omitempty"`
+
+	Interval    time.Duration `json:",omitempty"`
+	Timeout     time.Duration `json:",omitempty"`
+	StartPeriod time.Duration `json:",omitempty"`
+	Retries     int           `json:",omitempty"`
 }
 
 // Layer represents a single filesystem layer in a container image.
 type Layer struct {
 	Descriptor specsv1.Descriptor
 	DiffID     digest.Digest
-	OpenBlob   func(context.Context) (io.ReadCloser, error)
+	// OpenBlob returns a reader for the layer's blob, which may be compressed.
+	// Clients may call OpenBlob any number of times, for example to retry a
+	// failed upload, so each call must return a new reader positioned at the
+	// start of the blob that is independent of any reader returned earlier.
+	OpenBlob func(context.Context) (io.ReadCloser, error)
 }
 
 // AppendLayer appends layer to img.Layers and updates corresponding values of
@@ -82,9 +153,153 @@ func (img *Image) AppendLayer(layer Layer) {
 	img.Config.RootFS.DiffIDs = append(img.Config.RootFS.DiffIDs, layer.DiffID)
 }
 
-// SetPlatform sets img.Platform and updates corresponding values of img.Config.
+// RemoveLayers removes the top n layers from img.Layers, and updates
+// corresponding values of img.Config.
+//
+// Like BackfillHistory, RemoveLayers assumes that any existing history entries
+// describe the image's layers from the bottom up. It removes the entries for
+// the removed layers, along with any entries marked as empty layers that
+// follow them. If the history describes more layers than the image has, it is
+// unclear which entries belong to the removed layers, so RemoveLayers returns
+// an error without changing img; BackfillHistory can repair such a history
+// first.
+func (img *Image) RemoveLayers(n int) error {
+	if n < 0 || n > len(img.Layers) {
+		return fmt.Errorf("cannot remove %d layer(s) from an image with %d", n, len(img.Layers))
+	}
+
+	covered := 0
+	for _, h := range img.Config.History {
+		if !h.EmptyLayer {
+			covered++
+		}
+	}
+	if covered > len(img.Layers) {
+		return fmt.Errorf("history describes %d layer(s), but the image has %d", covered, len(img.Layers))
+	}
+
+	// Layers beyond those covered by the history have no entries to remove.
+	toRemove := n - (len(img.Layers) - covered)
+	history := img.Config.History
+	for toRemove > 0 && len(history) > 0 {
+		last := history[len(history)-1]
+		history = history[:len(history)-1]
+		if !last.EmptyLayer {
+			toRemove--
+		}
+	}
+	img.Config.History = history
+
+	keep := len(img.Layers) - n
+	img.Layers = img.Layers[:keep]
+	if len(img.Config.RootFS.DiffIDs) > keep {
+		img.Config.RootFS.DiffIDs = img.Config.RootFS.DiffIDs[:keep]
+	}
+	return nil
+}
+
+// BackfillHistory rebuilds img.Config.History so that it has exactly one entry
+// not marked as an empty layer for each of the image's layers, and so will
+// line up with any layers added afterward.
+//
+// Existing entries are assumed to describe the image's layers from the bottom
+// up, and keep their positions, including those marked as empty layers. If the
+// history accounts for too few layers, generic entries for the rest are
+// inserted after the entry for the last layer it accounts for, ahead of any
+// empty layer entries that follow it. If the history accounts for too many
+// layers, the entries beyond the image's layers are marked as empty layers.
+func (img *Image) BackfillHistory() {
+	var (
+		history  = make([]specsv1.History, 0, len(img.Config.History)+len(img.Layers))
+		covered  int
+		insertAt int
+		changed  bool
+	)
+	for _, h := range img.Config.History {
+		if !h.EmptyLayer {
+			if covered < len(img.Layers) {
+				covered++
+				history = append(history, h)
+				insertAt = len(history)
+				continue
+			}
+			h.EmptyLayer = true
+			changed = true
+		}
+		history = append(history, h)
+	}
+
+	if covered < len(img.Layers) {
+		backfill := make([]specsv1.History, 0, len(history)-insertAt+len(img.Layers)-covered)
+		for i := covered; i < len(img.Layers); i++ {
+			backfill = append(backfill, specsv1.History{
+				Comment: fmt.Sprintf("layer %d (no recorded history)", i),
+			})
+		}
+		backfill = append(backfill, history[insertAt:]...)
+		history = append(history[:insertAt], backfill...)
+		changed = true
+	}
+
+	if changed {
+		img.Config.History = history
+	}
+}
+
+// Manifest returns the OCI image manifest for img, which references its
+// configuration blob through the provided descriptor.
+func (img Image) Manifest(configDesc specsv1.Descriptor) Manifest {
+	manifest := Manifest{
+		Manifest: specsv1.Manifest{
+			Versioned:   specs.Versioned{SchemaVersion: 2},
+			MediaType:   specsv1.MediaTypeImageManifest,
+			Config:      configDesc,
+			Annotations: img.Annotations,
+		},
+		ArtifactType: img.ArtifactType,
+	}
+	for _, layer := range img.Layers {
+		manifest.Layers = append(manifest.Layers, layer.Descriptor)
+	}
+	return manifest
+}
+
+// IndexPlatform returns the platform that describes img in an OCI image index.
+// It starts from img.Platform and fills any values left empty, including the
+// OS version, OS features, and variant, from the corresponding values of
+// img.Config.
+func (img Image) IndexPlatform() specsv1.Platform {
+	platform := img.Platform
+	if platform.OS == "" {
+		platform.OS = img.Config.OS
+	}
+	if platform.Architecture == "" {
+		platform.Architecture = img.Config.Architecture
+	}
+	if platform.OSVersion == "" {
+		platform.OSVersion = img.Config.OSVersion
+	}
+	if len(platform.OSFeatures) == 0 && len(img.Config.OSFeatures) > 0 {
+		platform.OSFeatures = append([]string(nil), img.Config.OSFeatures...)
+	}
+	if platform.Variant == "" {
+		platform.Variant = img.Config.Variant
+	}
+	return platform
+}
+
+// SetPlatform sets img.Platform and updates corresponding values of img.Config,
+// including the variant. The OS version and OS features of img.Config are only
+// updated if platform specifies them.
 func (img *Image) SetPlatform(platform specsv1.Platform) {
 	img.Platform = platform
 	img.Config.OS = platform.OS
 	img.Config.Architecture = platform.Architecture
+	img.Config.Variant = platform.Variant
+	if platform.OSVersion != "" {
+		img.Config.OSVersion = platform.OSVersion
+	}
+	if len(platform.OSFeatures) > 0 {
+		img.Config.OSFeatures = append([]string(nil), platform.OSFeatures...)
+	}
 }
@@ -0,0 +1,339 @@
+package image_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"testing"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/google/go-cmp/cmp"
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"go.alexhamlin.co/zeroimage/internal/image"
+)
+
+func TestRemoveLayers(t *testing.T) {
+	testCases := []struct {
+		Description string
+		Layers      int
+		History     []specsv1.History
+		Remove      int
+		WantHistory []specsv1.History
+		WantError   bool
+	}{
+		{
+			Description: "trailing empty layers",
+			Layers:      3,
+			History: []specsv1.History{
+				{Comment: "0"}, {Comment: "env", EmptyLayer: true},
+				{Comment: "1"}, {Comment: "2"}, {Comment: "cmd", EmptyLayer: true},
+			},
+			Remove: 2,
+			WantHistory: []specsv1.History{
+				{Comment: "0"}, {Comment: "env", EmptyLayer: true},
+			},
+		},
+		{
+			Description: "layers without history",
+			Layers:      3,
+			History:     []specsv1.History{{Comment: "0"}, {Comment: "1"}},
+			Remove:      2,
+			WantHistory: []specsv1.History{{Comment: "0"}},
+		},
+		{
+			Description: "all layers",
+			Layers:      2,
+			History:     []specsv1.History{{Comment: "0"}, {Comment: "1"}},
+			Remove:      2,
+			WantHistory: []specsv1.History{},
+		},
+		{
+			Description: "too many layers",
+			Layers:      1,
+			Remove:      2,
+			WantError:   true,
+		},
+		{
+			Description: "history with extra layers",
+			Layers:      2,
+			History:     []specsv1.History{{Comment: "0"}, {Comment: "1"}, {Comment: "2"}},
+			Remove:      1,
+			WantError:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Description, func(t *testing.T) {
+			var img image.Image
+			for i := 0; i < tc.Layers; i++ {
+				img.AppendLayer(image.Layer{DiffID: digest.FromString(string(rune('a' + i)))})
+			}
+			img.Config.History = tc.History
+
+			err := img.RemoveLayers(tc.Remove)
+			if tc.WantError {
+				if err == nil {
+					t.Fatalf("expected error removing %d layer(s)", tc.Remove)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			wantLayers := tc.Layers - tc.Remove
+			if len(img.Layers) != wantLayers || len(img.Config.RootFS.DiffIDs) != wantLayers {
+				t.Errorf("got %d layer(s) and %d diff ID(s), want %d", len(img.Layers), len(img.Config.RootFS.DiffIDs), wantLayers)
+			}
+			if diff := cmp.Diff(tc.WantHistory, img.Config.History); diff != "" {
+				t.Errorf("unexpected history (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBackfillHistory(t *testing.T) {
+	testCases := []struct {
+		Description string
+		Layers      int
+		History     []specsv1.History
+		WantHistory []specsv1.History
+	}{
+		{
+			Description: "complete history",
+			Layers:      2,
+			History: []specsv1.History{
+				{Comment: "0"}, {Comment: "env", EmptyLayer: true}, {Comment: "1"},
+			},
+			WantHistory: []specsv1.History{
+				{Comment: "0"}, {Comment: "env", EmptyLayer: true}, {Comment: "1"},
+			},
+		},
+		{
+			Description: "empty history",
+			Layers:      2,
+			WantHistory: []specsv1.History{
+				{Comment: "layer 0 (no recorded history)"},
+				{Comment: "layer 1 (no recorded history)"},
+			},
+		},
+		{
+			Description: "empty history without layers",
+		},
+		{
+			Description: "interleaved empty layers",
+			Layers:      4,
+			History: []specsv1.History{
+				{Comment: "workdir", EmptyLayer: true}, {Comment: "0"},
+				{Comment: "env", EmptyLayer: true}, {Comment: "1"},
+				{Comment: "cmd", EmptyLayer: true},
+			},
+			WantHistory: []specsv1.History{
+				{Comment: "workdir", EmptyLayer: true}, {Comment: "0"},
+				{Comment: "env", EmptyLayer: true}, {Comment: "1"},
+				{Comment: "layer 2 (no recorded history)"},
+				{Comment: "layer 3 (no recorded history)"},
+				{Comment: "cmd", EmptyLayer: true},
+			},
+		},
+		{
+			Description: "only empty layers",
+			Layers:      1,
+			History:     []specsv1.History{{Comment: "cmd", EmptyLayer: true}},
+			WantHistory: []specsv1.History{
+				{Comment: "layer 0 (no recorded history)"},
+				{Comment: "cmd", EmptyLayer: true},
+			},
+		},
+		{
+			Description: "too long history",
+			Layers:      1,
+			History: []specsv1.History{
+				{Comment: "0"}, {Comment: "env", EmptyLayer: true},
+				{Comment: "1"}, {Comment: "cmd", EmptyLayer: true},
+			},
+			WantHistory: []specsv1.History{
+				{Comment: "0"}, {Comment: "env", EmptyLayer: true},
+				{Comment: "1", EmptyLayer: true}, {Comment: "cmd", EmptyLayer: true},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Description, func(t *testing.T) {
+			var img image.Image
+			for i := 0; i < tc.Layers; i++ {
+				img.AppendLayer(image.Layer{DiffID: digest.FromString(string(rune('a' + i)))})
+			}
+			img.Config.History = tc.History
+
+			img.BackfillHistory()
+			if diff := cmp.Diff(tc.WantHistory, img.Config.History); diff != "" {
+				t.Errorf("unexpected history (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	layer := image.Layer{
+		Descriptor: specsv1.Descriptor{Digest: digest.FromString("blob"), Size: 4},
+		DiffID:     digest.FromString("tar"),
+	}
+	platform := specsv1.Platform{OS: "linux", Architecture: "amd64"}
+
+	testCases := []struct {
+		Description  string
+		Modify       func(img *image.Image)
+		WantProblems int
+	}{
+		{"valid", func(img *image.Image) {}, 0},
+		{"missing diff ID", func(img *image.Image) {
+			img.Config.RootFS.DiffIDs = nil
+		}, 1},
+		{"missing digest and size", func(img *image.Image) {
+			img.Layers[0].Descriptor = specsv1.Descriptor{}
+		}, 2},
+		{"mismatched platform", func(img *image.Image) {
+			img.Config.Architecture = "arm64"
+		}, 1},
+		{"excess history", func(img *image.Image) {
+			img.Config.History = []specsv1.History{{Comment: "0"}, {Comment: "1"}}
+		}, 1},
+		{"empty history entries", func(img *image.Image) {
+			img.Config.History = []specsv1.History{{Comment: "0"}, {Comment: "env", EmptyLayer: true}}
+		}, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Description, func(t *testing.T) {
+			var img image.Image
+			img.SetPlatform(platform)
+			img.AppendLayer(layer)
+			tc.Modify(&img)
+
+			err := img.Validate()
+			if tc.WantProblems == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			verr, ok := err.(image.ValidationError)
+			if !ok {
+				t.Fatalf("got error %v, want ValidationError", err)
+			}
+			if len(verr.Problems) != tc.WantProblems {
+				t.Errorf("got %d problem(s), want %d: %v", len(verr.Problems), tc.WantProblems, err)
+			}
+		})
+	}
+}
+
+func TestCheckConfigBlob(t *testing.T) {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte(`{"architecture":"amd64","os":"linux"}`))
+	zw.Close()
+
+	encoded, err := image.EncodeConfig(image.Config{})
+	if err != nil {
+		t.Fatalf("failed to encode config: %v", err)
+	}
+
+	testCases := []struct {
+		Description string
+		Content     []byte
+		WantError   bool
+	}{
+		{"encoded config", encoded, false},
+		{"JSON object", []byte(` {"os":"linux"}`), false},
+		{"gzip", compressed.Bytes(), true},
+		{"JSON array", []byte(`[]`), true},
+		{"truncated JSON", []byte(`{"os":`), true},
+		{"empty", nil, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Description, func(t *testing.T) {
+			err := image.CheckConfigBlob(tc.Content)
+			if tc.WantError && !errors.Is(err, image.ErrInvalidConfigBlob) {
+				t.Errorf("got error %v, want %v", err, image.ErrInvalidConfigBlob)
+			}
+			if !tc.WantError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestSelectByPlatform(t *testing.T) {
+	var idx image.Index
+	for _, p := range []string{"linux/386", "linux/amd64", "linux/amd64/v2", "linux/amd64/v3", "linux/amd64/v4", "linux/arm64"} {
+		idx = append(idx, image.IndexEntry{Platform: platforms.MustParse(p)})
+	}
+
+	testCases := []struct {
+		Platform string
+		Want     []string
+	}{
+		{"linux/amd64", []string{"linux/amd64", "linux/386"}},
+		{"linux/amd64/v1", []string{"linux/amd64", "linux/386"}},
+		{"linux/amd64/v3", []string{"linux/amd64/v3", "linux/amd64/v2", "linux/amd64", "linux/386"}},
+		{"linux/x86_64/v2", []string{"linux/amd64/v2", "linux/amd64", "linux/386"}},
+		{"linux/aarch64", []string{"linux/arm64"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Platform, func(t *testing.T) {
+			var got []string
+			for _, entry := range idx.SelectByPlatform(platforms.MustParse(tc.Platform)) {
+				got = append(got, platforms.Format(entry.Platform))
+			}
+			if diff := cmp.Diff(tc.Want, got); diff != "" {
+				t.Errorf("unexpected selection (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSelectByPlatformWithoutPlatform(t *testing.T) {
+	platform := platforms.MustParse("linux/arm64")
+
+	idx := image.Index{{Digest: digest.FromString("scratch")}}
+	if got := idx.SelectByPlatform(platform); len(got) != 1 {
+		t.Errorf("selected %d image(s) from a single image without a platform, want 1", len(got))
+	}
+
+	idx = append(idx, image.IndexEntry{Platform: platforms.MustParse("linux/amd64")})
+	if got := idx.SelectByPlatform(platform); len(got) != 0 {
+		t.Errorf("selected %d image(s) from multiple images without a match, want 0", len(got))
+	}
+}
+
+func TestIndexPlatform(t *testing.T) {
+	var img image.Image
+	img.Platform = specsv1.Platform{OS: "windows", Architecture: "amd64"}
+	img.Config.OS = "windows"
+	img.Config.Architecture = "amd64"
+	img.Config.OSVersion = "10.0.17763.1879"
+	img.Config.OSFeatures = []string{"win32k"}
+
+	want := specsv1.Platform{
+		OS:           "windows",
+		Architecture: "amd64",
+		OSVersion:    "10.0.17763.1879",
+		OSFeatures:   []string{"win32k"},
+	}
+	if diff := cmp.Diff(want, img.IndexPlatform()); diff != "" {
+		t.Errorf("unexpected platform filled from config (-want +got):\n%s", diff)
+	}
+
+	img.Platform.OSVersion = "10.0.20348.643"
+	want.OSVersion = img.Platform.OSVersion
+	if diff := cmp.Diff(want, img.IndexPlatform()); diff != "" {
+		t.Errorf("unexpected platform with explicit OS version (-want +got):\n%s", diff)
+	}
+}
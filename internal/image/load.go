@@ -1,6 +1,9 @@
 package image
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
@@ -12,6 +15,17 @@ import (
 	"github.com/opencontainers/go-digest"
 	"github.com/opencontainers/image-spec/specs-go"
 	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sync/errgroup"
+)
+
+// Media types defined by the Docker Image Manifest V2, Schema 2 specification.
+const (
+	MediaTypeDockerManifestList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerManifest         = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerConfig           = "application/vnd.docker.container.image.v1+json"
+	MediaTypeDockerLayer            = "application/vnd.docker.image.rootfs.diff.tar"
+	MediaTypeDockerLayerGzip        = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	MediaTypeDockerForeignLayerGzip = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
 )
 
 // Supported JSON manifest formats. The Docker-specific formats are compatible
@@ -20,11 +34,11 @@ import (
 var (
 	SupportedIndexMediaTypes = []string{
 		specsv1.MediaTypeImageIndex,
-		"application/vnd.docker.distribution.manifest.list.v2+json",
+		MediaTypeDockerManifestList,
 	}
 	SupportedManifestMediaTypes = []string{
 		specsv1.MediaTypeImageManifest,
-		"application/vnd.docker.distribution.manifest.v2+json",
+		MediaTypeDockerManifest,
 	}
 )
 
@@ -66,13 +80,39 @@ type Loader interface {
 // Index, as well as on all Images loaded from the Index, will use the same
 // Loader to access image configuration and filesystem layer blobs.
 func Load(ctx context.Context, l Loader) (Index, error) {
-	loader := loader{Loader: l}
+	return LoadWithOptions(ctx, l, LoadOptions{})
+}
+
+// DefaultLoadConcurrency is the number of manifests in an index whose platforms
+// Load will determine at once, unless LoadOptions.Concurrency selects a
+// different number.
+const DefaultLoadConcurrency = 4
+
+// LoadOptions customizes the behavior of LoadWithOptions.
+type LoadOptions struct {
+	// Concurrency is the maximum number of manifests in an index whose platforms
+	// are determined at once. Determining the platform of a manifest without
+	// one in its index descriptor requires reading the manifest and its
+	// configuration blob, which can be slow for remote sources. The zero value
+	// selects DefaultLoadConcurrency.
+	Concurrency int
+}
+
+// LoadWithOptions builds an image index using the provided Loader like Load,
+// as customized by opts.
+func LoadWithOptions(ctx context.Context, l Loader, opts LoadOptions) (Index, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultLoadConcurrency
+	}
+	loader := loader{Loader: l, concurrency: opts.Concurrency}
 	return loader.buildFullIndex(ctx)
 }
 
 type loader struct {
 	Loader
 
+	concurrency int
+
 	// Only modified during initialization, safe to avoid locking.
 	rootIndex     specsv1.Index
 	nestedIndexes map[digest.Digest]specsv1.Index
@@ -96,19 +136,30 @@ func (l *loader) buildFullIndex(ctx context.Context) (Index, error) {
 		return nil, err
 	}
 
+	// Each goroutine writes only to its own entry, so the index needs no further
+	// synchronization.
 	idx := make(Index, len(manifestDescriptors))
+	eg, ectx := errgroup.WithContext(ctx)
+	eg.SetLimit(l.concurrency)
 	for i, md := range manifestDescriptors {
-		md := md
-		platform, err := l.getPlatformByManifestDescriptor(ctx, md)
-		if err != nil {
-			return nil, err
-		}
-		idx[i] = IndexEntry{
-			Platform: platform,
-			GetImage: func(ctx context.Context) (Image, error) {
-				return l.buildImage(ctx, md)
-			},
-		}
+		i, md := i, md
+		eg.Go(func() error {
+			platform, err := l.getPlatformByManifestDescriptor(ectx, md)
+			if err != nil {
+				return err
+			}
+			idx[i] = IndexEntry{
+				Platform: platform,
+				Digest:   md.Digest,
+				GetImage: func(ctx context.Context) (Image, error) {
+					return l.buildImage(ctx, md)
+				},
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
 	}
 	return idx, nil
 }
@@ -153,7 +204,7 @@ func (l *loader) initRootIndex(ctx context.Context) error {
 }
 
 func (l *loader) synthesizeRootIndexFromManifest(content []byte) error {
-	var manifest specsv1.Manifest
+	var manifest Manifest
 	err := json.Unmarshal(content, &manifest)
 	if err != nil {
 		return err
@@ -275,10 +326,11 @@ func (l *loader) buildImage(ctx context.Context, manifestDescriptor specsv1.Desc
 	}
 
 	return Image{
-		Layers:      layers,
-		Config:      config,
-		Platform:    platform,
-		Annotations: manifest.Annotations,
+		Layers:       layers,
+		Config:       config,
+		Platform:     platform,
+		Annotations:  manifest.Annotations,
+		ArtifactType: manifest.ArtifactType,
 	}, nil
 }
 
@@ -306,9 +358,9 @@ func (l *loader) getPlatformByManifestDescriptor(ctx context.Context, md specsv1
 	}, nil
 }
 
-func (l *loader) getManifest(ctx context.Context, dgst digest.Digest) (specsv1.Manifest, error) {
+func (l *loader) getManifest(ctx context.Context, dgst digest.Digest) (Manifest, error) {
 	if m, ok := l.manifestsByDigest.Load(dgst); ok {
-		return m.(specsv1.Manifest), nil
+		return m.(Manifest), nil
 	}
 
 	// In theory we could deduplicate concurrent reads for the same digest, but
@@ -316,14 +368,14 @@ func (l *loader) getManifest(ctx context.Context, dgst digest.Digest) (specsv1.M
 	// more likely to touch different images at the same time than to touch the
 	// same image multiple times at once.
 
-	var manifest specsv1.Manifest
+	var manifest Manifest
 	err := l.readJSONManifest(ctx, dgst, &manifest)
 	if err != nil {
-		return specsv1.Manifest{}, err
+		return Manifest{}, err
 	}
 
 	m, _ := l.manifestsByDigest.LoadOrStore(dgst, manifest)
-	return m.(specsv1.Manifest), nil
+	return m.(Manifest), nil
 }
 
 func (l *loader) getConfig(ctx context.Context, dgst digest.Digest) (Config, error) {
@@ -333,8 +385,7 @@ func (l *loader) getConfig(ctx context.Context, dgst digest.Digest) (Config, err
 
 	// Above note about deduplication applies here too.
 
-	var config Config
-	err := l.readJSONBlob(ctx, dgst, &config)
+	config, err := l.readConfigBlob(ctx, dgst)
 	if err != nil {
 		return Config{}, err
 	}
@@ -344,6 +395,10 @@ func (l *loader) getConfig(ctx context.Context, dgst digest.Digest) (Config, err
 }
 
 func (l *loader) readJSONManifest(ctx context.Context, dgst digest.Digest, v interface{}) error {
+	if !dgst.Algorithm().Available() {
+		return fmt.Errorf("%w %q for manifest %v", digest.ErrDigestUnsupported, dgst.Algorithm(), dgst)
+	}
+
 	rdr, err := l.OpenManifest(ctx, dgst)
 	if err != nil {
 		return err
@@ -352,7 +407,7 @@ func (l *loader) readJSONManifest(ctx context.Context, dgst digest.Digest, v int
 
 	verifier := dgst.Verifier()
 
-	err = json.NewDecoder(io.TeeReader(rdr, verifier)).Decode(v)
+	err = decodeJSON(io.TeeReader(rdr, verifier), v)
 	if err != nil {
 		return err
 	}
@@ -363,24 +418,72 @@ func (l *loader) readJSONManifest(ctx context.Context, dgst digest.Digest, v int
 	return nil
 }
 
-func (l *loader) readJSONBlob(ctx context.Context, dgst digest.Digest, v interface{}) error {
+// readConfigBlob reads and decodes the configuration blob with the provided
+// digest, after checking its content with CheckConfigBlob.
+func (l *loader) readConfigBlob(ctx context.Context, dgst digest.Digest) (Config, error) {
+	if !dgst.Algorithm().Available() {
+		return Config{}, fmt.Errorf("%w %q for blob %v", digest.ErrDigestUnsupported, dgst.Algorithm(), dgst)
+	}
+
 	rdr, err := l.OpenBlob(ctx, dgst)
 	if err != nil {
-		return err
+		return Config{}, err
 	}
 	defer rdr.Close()
 
 	verifier := dgst.Verifier()
 
-	err = json.NewDecoder(io.TeeReader(rdr, verifier)).Decode(v)
+	content, err := readJSONContent(io.TeeReader(rdr, verifier))
 	if err != nil {
-		return err
+		return Config{}, err
 	}
 
 	if !verifier.Verified() {
-		return fmt.Errorf("content of blob %v does not match digest", dgst)
+		return Config{}, fmt.Errorf("content of blob %v does not match digest", dgst)
 	}
-	return nil
+	if err := CheckConfigBlob(content); err != nil {
+		return Config{}, fmt.Errorf("blob %v: %w", dgst, err)
+	}
+
+	var config Config
+	err = json.Unmarshal(content, &config)
+	return config, err
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decodeJSON decodes a single JSON value from r into v, after decompressing r
+// like readJSONContent.
+func decodeJSON(r io.Reader, v interface{}) error {
+	content, err := readJSONContent(r)
+	if err != nil {
+		return err
+	}
+	return json.NewDecoder(bytes.NewReader(content)).Decode(v)
+}
+
+// readJSONContent returns the content of r, which should be JSON. Some tools
+// compress JSON blobs like image configurations with gzip despite their JSON
+// media types, so readJSONContent transparently decompresses r if it starts
+// with the gzip magic number. readJSONContent reads r to the end, so that a
+// digest verifier teed from r sees all of its raw content.
+func readJSONContent(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(len(gzipMagic)); !bytes.Equal(magic, gzipMagic) {
+		return io.ReadAll(br)
+	}
+
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	content, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(io.Discard, br)
+	return content, err
 }
 
 func normalizeLayerMediaType(mediaType string) string {
@@ -407,15 +510,60 @@ func normalizeLayerMediaType(mediaType string) string {
 	// whiteout files, so I'd assume that runtimes have some general way to handle
 	// this weird situation if it comes up in a crafted image.
 	switch mediaType {
-	case "application/vnd.docker.image.rootfs.diff.tar.gzip":
+	case MediaTypeDockerLayer:
+		return specsv1.MediaTypeImageLayer
+	case MediaTypeDockerLayerGzip:
 		return specsv1.MediaTypeImageLayerGzip
-	case "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip":
+	case MediaTypeDockerForeignLayerGzip:
 		return specsv1.MediaTypeImageLayerNonDistributableGzip
 	default:
 		return mediaType
 	}
 }
 
+// DockerLayerMediaType returns the Docker equivalent of an OCI layer media
+// type, for use in Docker v2 image manifests. It returns an error for layers
+// that Docker manifests cannot represent, such as zstd-compressed layers.
+func DockerLayerMediaType(mediaType string) (string, error) {
+	switch mediaType {
+	case specsv1.MediaTypeImageLayer:
+		return MediaTypeDockerLayer, nil
+	case specsv1.MediaTypeImageLayerGzip:
+		return MediaTypeDockerLayerGzip, nil
+	case specsv1.MediaTypeImageLayerNonDistributableGzip:
+		return MediaTypeDockerForeignLayerGzip, nil
+	default:
+		return "", fmt.Errorf("layer media type %q has no Docker equivalent", mediaType)
+	}
+}
+
+// ToDockerManifest returns the Docker v2 schema 2 equivalent of an OCI image
+// manifest. Docker manifests cannot carry annotations, artifact types, or
+// subjects, so the result has none, either for the manifest itself or for its
+// layers.
+//
+// ToDockerManifest returns an error if any of the manifest's layers has no
+// Docker equivalent.
+func ToDockerManifest(manifest Manifest) (Manifest, error) {
+	docker := manifest
+	docker.MediaType = MediaTypeDockerManifest
+	docker.Config.MediaType = MediaTypeDockerConfig
+	docker.Annotations = nil
+	docker.ArtifactType = ""
+	docker.Subject = nil
+	docker.Layers = make([]specsv1.Descriptor, len(manifest.Layers))
+	for i, layer := range manifest.Layers {
+		mediaType, err := DockerLayerMediaType(layer.MediaType)
+		if err != nil {
+			return Manifest{}, err
+		}
+		docker.Layers[i] = layer
+		docker.Layers[i].MediaType = mediaType
+		docker.Layers[i].Annotations = nil
+	}
+	return docker, nil
+}
+
 func isNondistributableMediaType(mediaType string) bool {
 	// This should also cover the "+gzip" and "+zstd" suffixes. I can't imagine
 	// the spec adding to the media subtype after the ".tar" part.
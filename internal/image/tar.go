@@ -0,0 +1,69 @@
+package image
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// TarReader provides sequential access to the entries of a layer's
+// decompressed tar archive. The client must call Close after reading from a
+// TarReader.
+type TarReader struct {
+	*tar.Reader
+
+	closers []io.Closer
+}
+
+// Close releases the resources associated with the layer's blob.
+func (tr *TarReader) Close() error {
+	var err error
+	for i := len(tr.closers) - 1; i >= 0; i-- {
+		if cerr := tr.closers[i].Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// OpenTar opens the blob of l and returns a TarReader over its decompressed
+// content, streaming the blob rather than loading it into memory. OpenTar
+// selects a decompressor based on the media type of l, and returns an error for
+// media types that it cannot decompress, including zstd-compressed layers.
+func (l Layer) OpenTar(ctx context.Context) (*TarReader, error) {
+	mediaType := l.Descriptor.MediaType
+
+	var compressed bool
+	switch {
+	case mediaType == specsv1.MediaTypeImageLayer,
+		mediaType == MediaTypeDockerLayer,
+		mediaType == specsv1.MediaTypeImageLayerNonDistributable:
+		compressed = false
+	case strings.HasSuffix(mediaType, "+gzip"),
+		mediaType == MediaTypeDockerLayerGzip,
+		mediaType == MediaTypeDockerForeignLayerGzip:
+		compressed = true
+	default:
+		return nil, fmt.Errorf("cannot decompress layer with media type %q", mediaType)
+	}
+
+	blob, err := l.OpenBlob(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !compressed {
+		return &TarReader{tar.NewReader(blob), []io.Closer{blob}}, nil
+	}
+
+	zr, err := gzip.NewReader(blob)
+	if err != nil {
+		blob.Close()
+		return nil, err
+	}
+	return &TarReader{tar.NewReader(zr), []io.Closer{blob, zr}}, nil
+}
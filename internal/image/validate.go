@@ -0,0 +1,101 @@
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidConfigBlob is the cause of an error resulting from image
+// configuration blob content that is not an uncompressed JSON object.
+var ErrInvalidConfigBlob = errors.New("configuration blob is not an uncompressed JSON object")
+
+// ValidationError describes the problems that Validate found with an image.
+type ValidationError struct {
+	Problems []string
+}
+
+func (verr ValidationError) Error() string {
+	return "invalid image: " + strings.Join(verr.Problems, "; ")
+}
+
+// Validate checks img for internal consistency, and returns a ValidationError
+// describing every problem that it finds. In particular, Validate checks that:
+//
+//   - Every layer has a valid digest and a positive size, and its diff ID
+//     matches the corresponding diff ID in the configuration.
+//   - The configuration's operating system and architecture match the image's
+//     platform, if the platform is set.
+//   - The configuration's history does not describe more layers than the image
+//     has. Images commonly omit history entries for some layers, so Validate
+//     does not treat fewer entries as a problem.
+func (img Image) Validate() error {
+	var problems []string
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	diffIDs := img.Config.RootFS.DiffIDs
+	if len(diffIDs) != len(img.Layers) {
+		addProblem("image has %d layer(s) but configuration has %d diff ID(s)", len(img.Layers), len(diffIDs))
+	}
+	for i, layer := range img.Layers {
+		if layer.Descriptor.Digest == "" {
+			addProblem("layer %d has no digest", i)
+		} else if err := layer.Descriptor.Digest.Validate(); err != nil {
+			addProblem("layer %d has invalid digest %q: %v", i, layer.Descriptor.Digest, err)
+		}
+		if layer.Descriptor.Size <= 0 {
+			addProblem("layer %d has invalid size %d", i, layer.Descriptor.Size)
+		}
+		if i < len(diffIDs) && layer.DiffID != diffIDs[i] {
+			addProblem("layer %d has diff ID %s but configuration has %s", i, layer.DiffID, diffIDs[i])
+		}
+	}
+
+	if img.Platform.OS != "" && img.Config.OS != img.Platform.OS {
+		addProblem("configuration OS %q does not match platform OS %q", img.Config.OS, img.Platform.OS)
+	}
+	if img.Platform.Architecture != "" && img.Config.Architecture != img.Platform.Architecture {
+		addProblem("configuration architecture %q does not match platform architecture %q", img.Config.Architecture, img.Platform.Architecture)
+	}
+
+	covered := 0
+	for _, h := range img.Config.History {
+		if !h.EmptyLayer {
+			covered++
+		}
+	}
+	if covered > len(img.Layers) {
+		addProblem("history describes %d layer(s) but image has %d", covered, len(img.Layers))
+	}
+
+	if len(problems) > 0 {
+		return ValidationError{problems}
+	}
+	return nil
+}
+
+// EncodeConfig returns the JSON encoding of config for use as the content of an
+// image configuration blob. The spec requires that configuration blobs are
+// stored as uncompressed JSON, regardless of how they were originally loaded.
+func EncodeConfig(config Config) ([]byte, error) {
+	return json.Marshal(config)
+}
+
+// CheckConfigBlob returns an error caused by ErrInvalidConfigBlob if content
+// is not an uncompressed JSON object, as the spec requires of image
+// configuration blobs. Loaders check configuration blobs from outside sources
+// with CheckConfigBlob, after undoing any compression that they tolerate.
+func CheckConfigBlob(content []byte) error {
+	if bytes.HasPrefix(content, gzipMagic) {
+		return fmt.Errorf("%w: content is compressed with gzip", ErrInvalidConfigBlob)
+	}
+	trimmed := bytes.TrimLeft(content, " \t\r\n")
+	if !bytes.HasPrefix(trimmed, []byte("{")) || !json.Valid(content) {
+		return ErrInvalidConfigBlob
+	}
+	return nil
+}
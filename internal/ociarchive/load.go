@@ -9,7 +9,9 @@ import (
 	"fmt"
 	"io"
 	"path"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/opencontainers/go-digest"
 	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
@@ -17,13 +19,33 @@ import (
 	"go.alexhamlin.co/zeroimage/internal/image"
 )
 
+// LoadOptions customizes the behavior of LoadWithOptions.
+type LoadOptions struct {
+	// Strict causes LoadWithOptions to load every image in the archive up
+	// front, and to return an error if any manifest, configuration, or layer
+	// that they reference is missing from the archive. By default, a missing
+	// blob only causes an error when a client attempts to read it.
+	Strict bool
+	// UnreferencedBlob, if set, is called in strict mode with the digest of
+	// each blob in the archive that no image in the archive references, in
+	// sorted order. Such blobs do not prevent the archive from loading, but
+	// may indicate that the archive was built incorrectly.
+	UnreferencedBlob func(dgst digest.Digest)
+}
+
 // Load loads an image index from a tar archive whose contents comply with the
-// OCI Image Layout Specification.
+// OCI Image Layout Specification, using the default LoadOptions.
 //
 // The current implementation of Load buffers all of the archive's blobs in
 // memory, and requires that all blobs referenced by manifests appear in the
 // archive itself without requiring downloads from URLs.
 func Load(r io.Reader) (image.Index, error) {
+	return LoadWithOptions(r, LoadOptions{})
+}
+
+// LoadWithOptions loads an image index from a tar archive following the
+// semantics of Load, as customized by opts.
+func LoadWithOptions(r io.Reader, opts LoadOptions) (image.Index, error) {
 	var ll loadedLayout
 	if err := ll.populateFromTar(tar.NewReader(r)); err != nil {
 		return nil, fmt.Errorf("invalid archive: %w", err)
@@ -34,7 +56,19 @@ func Load(r io.Reader) (image.Index, error) {
 	if ll.Index == nil {
 		return nil, errors.New("invalid archive: missing index.json")
 	}
-	return image.Load(context.Background(), ll)
+	if !opts.Strict {
+		return image.Load(context.Background(), ll)
+	}
+
+	rl := &referenceLoader{loadedLayout: ll, referenced: make(map[digest.Digest]bool)}
+	index, err := image.Load(context.Background(), rl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive: %w", err)
+	}
+	if err := rl.checkReferences(context.Background(), index, opts.UnreferencedBlob); err != nil {
+		return nil, fmt.Errorf("invalid archive: %w", err)
+	}
+	return index, nil
 }
 
 type loadedLayout struct {
@@ -67,6 +101,60 @@ func (ll loadedLayout) OpenBlob(_ context.Context, dgst digest.Digest) (io.ReadC
 	return io.NopCloser(bytes.NewReader(blob)), nil
 }
 
+// referenceLoader wraps a loadedLayout to record the digest of every manifest
+// and blob that image.Load reads from it.
+type referenceLoader struct {
+	loadedLayout
+
+	mu         sync.Mutex
+	referenced map[digest.Digest]bool
+}
+
+func (rl *referenceLoader) OpenManifest(ctx context.Context, dgst digest.Digest) (io.ReadCloser, error) {
+	return rl.OpenBlob(ctx, dgst)
+}
+
+func (rl *referenceLoader) OpenBlob(ctx context.Context, dgst digest.Digest) (io.ReadCloser, error) {
+	rl.mu.Lock()
+	rl.referenced[dgst] = true
+	rl.mu.Unlock()
+	return rl.loadedLayout.OpenBlob(ctx, dgst)
+}
+
+// checkReferences loads every image in index to ensure that all of the blobs
+// that it references are present in the archive, then calls unreferenced (if
+// set) with the digest of each blob that no image referenced.
+func (rl *referenceLoader) checkReferences(ctx context.Context, index image.Index, unreferenced func(digest.Digest)) error {
+	for _, entry := range index {
+		img, err := entry.GetImage(ctx)
+		if err != nil {
+			return fmt.Errorf("manifest %s: %w", entry.Digest, err)
+		}
+		for i, layer := range img.Layers {
+			dgst := layer.Descriptor.Digest
+			if _, ok := rl.Blobs[dgst]; !ok {
+				return fmt.Errorf("manifest %s: layer %d: archive is missing blob %s", entry.Digest, i, dgst)
+			}
+			rl.referenced[dgst] = true
+		}
+	}
+
+	if unreferenced == nil {
+		return nil
+	}
+	var unused []digest.Digest
+	for dgst := range rl.Blobs {
+		if !rl.referenced[dgst] {
+			unused = append(unused, dgst)
+		}
+	}
+	sort.Slice(unused, func(i, j int) bool { return unused[i] < unused[j] })
+	for _, dgst := range unused {
+		unreferenced(dgst)
+	}
+	return nil
+}
+
 func (ll *loadedLayout) populateFromTar(tr *tar.Reader) error {
 	for {
 		header, err := tr.Next()
@@ -95,9 +183,15 @@ func (ll *loadedLayout) populateFromTar(tr *tar.Reader) error {
 }
 
 func (ll *loadedLayout) populateBlob(name string, r io.Reader) error {
-	pathAlg := path.Base(path.Dir(name))
+	pathAlg := digest.Algorithm(path.Base(path.Dir(name)))
 	pathDigest := path.Base(name)
-	dgst := digest.NewDigestFromEncoded(digest.Algorithm(pathAlg), pathDigest)
+	if !pathAlg.Available() {
+		// This usually means that the algorithm's hash implementation was not
+		// linked into the binary, in which case the digest's Verifier would panic.
+		return fmt.Errorf("%w %q for blob %q", digest.ErrDigestUnsupported, pathAlg, name)
+	}
+
+	dgst := digest.NewDigestFromEncoded(pathAlg, pathDigest)
 	if err := dgst.Validate(); err != nil {
 		return fmt.Errorf("blob name %q does not match any supported digest format: %w", name, err)
 	}
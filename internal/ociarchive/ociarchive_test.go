@@ -1,8 +1,15 @@
 package ociarchive
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"testing"
@@ -14,8 +21,12 @@ import (
 	"github.com/containerd/containerd/platforms"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
 
 	"go.alexhamlin.co/zeroimage/internal/image"
+	"go.alexhamlin.co/zeroimage/internal/tarbuild"
+	"go.alexhamlin.co/zeroimage/internal/tarlayer"
 )
 
 func TestRoundTripExistingArchive(t *testing.T) {
@@ -60,6 +71,463 @@ func TestRoundTripExistingArchive(t *testing.T) {
 	}
 }
 
+func TestWriteWithDigestAlgorithm(t *testing.T) {
+	// Ensure that an archive written with a non-default digest algorithm uses it
+	// for the blobs we generate, and can still be loaded.
+	index, err := loadTestdataArchive("hello-world-linux-arm64.tar")
+	if err != nil {
+		t.Fatalf("failed to load original archive: %v", err)
+	}
+	originalImage, err := index[0].GetImage(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load original image: %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = WriteImageWithOptions(originalImage, &buf, WriteOptions{DigestAlgorithm: digest.SHA512})
+	if err != nil {
+		t.Fatalf("failed to write valid image: %v", err)
+	}
+
+	rewrittenIndex, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("failed to load rewritten archive: %v", err)
+	}
+	if alg := rewrittenIndex[0].Digest.Algorithm(); alg != digest.SHA512 {
+		t.Errorf("manifest written with %s digest, want %s", alg, digest.SHA512)
+	}
+	if _, err := rewrittenIndex[0].GetImage(context.Background()); err != nil {
+		t.Errorf("failed to load rewritten image: %v", err)
+	}
+}
+
+func TestManifestDescriptor(t *testing.T) {
+	// Ensure that ManifestDescriptor describes the manifest that is written to
+	// the archive, with the digest algorithm of the written blobs.
+	index, err := loadTestdataArchive("hello-world-linux-arm64.tar")
+	if err != nil {
+		t.Fatalf("failed to load original archive: %v", err)
+	}
+	originalImage, err := index[0].GetImage(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load original image: %v", err)
+	}
+
+	for _, alg := range []digest.Algorithm{digest.SHA256, digest.SHA512} {
+		opts := WriteOptions{DigestAlgorithm: alg}
+		var buf bytes.Buffer
+		if err := WriteImageWithOptions(originalImage, &buf, opts); err != nil {
+			t.Fatalf("failed to write image with %s: %v", alg, err)
+		}
+		gotIndex := readArchiveIndex(t, &buf)
+
+		_, desc, err := ManifestDescriptor(originalImage, opts)
+		if err != nil {
+			t.Fatalf("failed to describe manifest with %s: %v", alg, err)
+		}
+		written := gotIndex.Manifests[0]
+		if desc.Digest != written.Digest || desc.Size != written.Size || desc.MediaType != written.MediaType {
+			t.Errorf("described manifest %v with %s, but wrote %v", desc, alg, written)
+		}
+	}
+}
+
+func TestRoundTripLayerAnnotations(t *testing.T) {
+	// Ensure that annotations set on a new layer are carried through to the
+	// layer descriptors in the manifest.
+	wantAnnotations := map[string]string{"org.example.step": "entrypoint"}
+	builder := tarlayer.NewBuilderWithOptions(tarlayer.Options{Annotations: wantAnnotations})
+	builder.AddContent("hello.txt", []byte("hello world"))
+	layer, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build layer: %v", err)
+	}
+
+	var img image.Image
+	img.AppendLayer(layer)
+
+	var buf bytes.Buffer
+	if err := WriteImage(img, &buf); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+
+	index, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("failed to load written archive: %v", err)
+	}
+	loadedImage, err := index[0].GetImage(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load written image: %v", err)
+	}
+
+	gotAnnotations := loadedImage.Layers[0].Descriptor.Annotations
+	if diff := cmp.Diff(wantAnnotations, gotAnnotations); diff != "" {
+		t.Errorf("unexpected layer annotations (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteDebugOutput(t *testing.T) {
+	// Ensure that the debug output matches the manifest and config blobs that
+	// the archive actually references.
+	index, err := loadTestdataArchive("hello-world-linux-arm64.tar")
+	if err != nil {
+		t.Fatalf("failed to load original archive: %v", err)
+	}
+	originalImage, err := index[0].GetImage(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load original image: %v", err)
+	}
+
+	var archive, debug bytes.Buffer
+	err = WriteImageWithOptions(originalImage, &archive, WriteOptions{DebugWriter: &debug})
+	if err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+
+	lines := bytes.Split(bytes.TrimSuffix(debug.Bytes(), []byte("\n")), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines of debug output, want 2", len(lines))
+	}
+	var manifest specsv1.Manifest
+	if err := json.Unmarshal(lines[1], &manifest); err != nil {
+		t.Fatalf("failed to decode debug manifest: %v", err)
+	}
+	if got, want := manifest.Config.Digest, digest.FromBytes(lines[0]); got != want {
+		t.Errorf("debug manifest references config %s, want %s", got, want)
+	}
+
+	rewrittenIndex, err := Load(&archive)
+	if err != nil {
+		t.Fatalf("failed to load written archive: %v", err)
+	}
+	if got, want := rewrittenIndex[0].Digest, digest.FromBytes(lines[1]); got != want {
+		t.Errorf("archive references manifest %s, want %s", got, want)
+	}
+}
+
+func TestWriteRefName(t *testing.T) {
+	// Ensure that the ref name annotation appears on the index descriptor only
+	// when requested.
+	index, err := loadTestdataArchive("hello-world-linux-arm64.tar")
+	if err != nil {
+		t.Fatalf("failed to load original archive: %v", err)
+	}
+	originalImage, err := index[0].GetImage(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load original image: %v", err)
+	}
+
+	for _, refName := range []string{"", "latest"} {
+		var buf bytes.Buffer
+		err := WriteImageWithOptions(originalImage, &buf, WriteOptions{RefName: refName})
+		if err != nil {
+			t.Fatalf("failed to write image with ref name %q: %v", refName, err)
+		}
+
+		var wantAnnotations map[string]string
+		if refName != "" {
+			wantAnnotations = map[string]string{specsv1.AnnotationRefName: refName}
+		}
+		gotIndex := readArchiveIndex(t, &buf)
+		if len(gotIndex.Manifests) != 1 {
+			t.Fatalf("got %d manifests in index, want 1", len(gotIndex.Manifests))
+		}
+		if diff := cmp.Diff(wantAnnotations, gotIndex.Manifests[0].Annotations); diff != "" {
+			t.Errorf("unexpected index annotations with ref name %q (-want +got):\n%s", refName, diff)
+		}
+	}
+}
+
+func TestWriteIndexAnnotations(t *testing.T) {
+	index, err := loadTestdataArchive("hello-world-linux-arm64.tar")
+	if err != nil {
+		t.Fatalf("failed to load original archive: %v", err)
+	}
+	originalImage, err := index[0].GetImage(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load original image: %v", err)
+	}
+
+	wantAnnotations := map[string]string{"org.example.tool": "zeroimage"}
+	var buf bytes.Buffer
+	err = WriteImageWithOptions(originalImage, &buf, WriteOptions{IndexAnnotations: wantAnnotations})
+	if err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+
+	gotIndex := readArchiveIndex(t, &buf)
+	if diff := cmp.Diff(wantAnnotations, gotIndex.Annotations); diff != "" {
+		t.Errorf("unexpected index annotations (-want +got):\n%s", diff)
+	}
+	if len(gotIndex.Manifests) == 1 && gotIndex.Manifests[0].Annotations != nil {
+		t.Errorf("index annotations leaked into manifest descriptor: %v", gotIndex.Manifests[0].Annotations)
+	}
+}
+
+func TestWriteConfigMediaType(t *testing.T) {
+	// Ensure that the manifest describes the config blob with a custom media
+	// type when requested, and with the OCI image config media type otherwise.
+	index, err := loadTestdataArchive("hello-world-linux-arm64.tar")
+	if err != nil {
+		t.Fatalf("failed to load original archive: %v", err)
+	}
+	originalImage, err := index[0].GetImage(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load original image: %v", err)
+	}
+
+	testCases := []struct {
+		ConfigMediaType string
+		Want            string
+	}{
+		{ConfigMediaType: "", Want: specsv1.MediaTypeImageConfig},
+		{ConfigMediaType: "application/vnd.example.config.v1+json", Want: "application/vnd.example.config.v1+json"},
+	}
+	for _, tc := range testCases {
+		var archive, debug bytes.Buffer
+		err := WriteImageWithOptions(originalImage, &archive, WriteOptions{
+			DebugWriter:     &debug,
+			ConfigMediaType: tc.ConfigMediaType,
+		})
+		if err != nil {
+			t.Fatalf("failed to write image with config media type %q: %v", tc.ConfigMediaType, err)
+		}
+
+		lines := bytes.Split(bytes.TrimSuffix(debug.Bytes(), []byte("\n")), []byte("\n"))
+		var manifest specsv1.Manifest
+		if err := json.Unmarshal(lines[len(lines)-1], &manifest); err != nil {
+			t.Fatalf("failed to decode debug manifest: %v", err)
+		}
+		if manifest.Config.MediaType != tc.Want {
+			t.Errorf("wrote config media type %q, want %q", manifest.Config.MediaType, tc.Want)
+		}
+	}
+}
+
+func TestWriteDescriptorAnnotations(t *testing.T) {
+	// Ensure that descriptor annotations land on the index descriptor alongside
+	// the ref name, and not in the manifest or the top level of the index.
+	index, err := loadTestdataArchive("hello-world-linux-arm64.tar")
+	if err != nil {
+		t.Fatalf("failed to load original archive: %v", err)
+	}
+	originalImage, err := index[0].GetImage(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load original image: %v", err)
+	}
+
+	var archive, debug bytes.Buffer
+	err = WriteImageWithOptions(originalImage, &archive, WriteOptions{
+		DebugWriter:           &debug,
+		RefName:               "latest",
+		DescriptorAnnotations: map[string]string{"org.example.build": "arm64-runner"},
+	})
+	if err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+
+	wantAnnotations := map[string]string{
+		"org.example.build":       "arm64-runner",
+		specsv1.AnnotationRefName: "latest",
+	}
+	gotIndex := readArchiveIndex(t, &archive)
+	if len(gotIndex.Manifests) != 1 {
+		t.Fatalf("got %d manifests in index, want 1", len(gotIndex.Manifests))
+	}
+	if diff := cmp.Diff(wantAnnotations, gotIndex.Manifests[0].Annotations); diff != "" {
+		t.Errorf("unexpected descriptor annotations (-want +got):\n%s", diff)
+	}
+	if gotIndex.Annotations != nil {
+		t.Errorf("descriptor annotations leaked into index: %v", gotIndex.Annotations)
+	}
+	if bytes.Contains(debug.Bytes(), []byte("org.example.build")) {
+		t.Errorf("descriptor annotations leaked into manifest")
+	}
+}
+
+// readArchiveIndex decodes the index.json file from an archive.
+func readArchiveIndex(t *testing.T, r io.Reader) specsv1.Index {
+	t.Helper()
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			t.Fatalf("failed to find index.json in archive: %v", err)
+		}
+		if header.Name != "index.json" {
+			continue
+		}
+		var index specsv1.Index
+		if err := json.NewDecoder(tr).Decode(&index); err != nil {
+			t.Fatalf("failed to decode index.json: %v", err)
+		}
+		return index
+	}
+}
+
+func TestRoundTripArtifactType(t *testing.T) {
+	// Ensure that the artifact type of an image survives a round trip, and that
+	// manifests without one do not mention it at all.
+	index, err := loadTestdataArchive("hello-world-linux-arm64.tar")
+	if err != nil {
+		t.Fatalf("failed to load original archive: %v", err)
+	}
+	originalImage, err := index[0].GetImage(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load original image: %v", err)
+	}
+
+	var debug bytes.Buffer
+	if err := WriteImageWithOptions(originalImage, io.Discard, WriteOptions{DebugWriter: &debug}); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+	if bytes.Contains(debug.Bytes(), []byte("artifactType")) {
+		t.Errorf("manifest without artifact type mentions it:\n%s", debug.Bytes())
+	}
+
+	const artifactType = "application/vnd.example.module.wasm"
+	originalImage.ArtifactType = artifactType
+	img, err := roundTripImage(originalImage)
+	if err != nil {
+		t.Fatalf("failed to round-trip image: %v", err)
+	}
+	if img.ArtifactType != artifactType {
+		t.Errorf("got artifact type %q, want %q", img.ArtifactType, artifactType)
+	}
+}
+
+func TestRoundTripVariant(t *testing.T) {
+	// Ensure that the variant of a new image is recorded in its configuration
+	// and index entry, so that it survives a round trip.
+	builder := tarlayer.NewBuilder()
+	builder.AddContent("hello.txt", []byte("hello world"))
+	layer, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build layer: %v", err)
+	}
+
+	var img image.Image
+	img.AppendLayer(layer)
+	img.SetPlatform(platforms.MustParse("linux/arm/v7"))
+
+	var buf bytes.Buffer
+	if err := WriteImage(img, &buf); err != nil {
+		t.Fatalf("failed to write image: %v", err)
+	}
+	index, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("failed to load written archive: %v", err)
+	}
+	if got := platforms.Format(index[0].Platform); got != "linux/arm/v7" {
+		t.Errorf("index entry has platform %s, want linux/arm/v7", got)
+	}
+	loadedImage, err := index[0].GetImage(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load written image: %v", err)
+	}
+	if loadedImage.Config.Variant != "v7" {
+		t.Errorf("configuration has variant %q, want v7", loadedImage.Config.Variant)
+	}
+}
+
+func TestRoundTripBaseDirectoryModes(t *testing.T) {
+	// Ensure that a directory with a special mode in a base image keeps that
+	// mode after the image is written, loaded, and extended with a layer that
+	// adds a file beneath the directory.
+	baseBuilder := tarlayer.NewBuilder()
+	baseBuilder.Add("tmp", tarbuild.Dir{Mode: fs.ModeDir | fs.ModeSticky | 0777})
+	baseLayer, err := baseBuilder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build base layer: %v", err)
+	}
+	var base image.Image
+	base.AppendLayer(baseLayer)
+
+	base, err = roundTripImage(base)
+	if err != nil {
+		t.Fatalf("failed to round-trip base image: %v", err)
+	}
+	baseFiles, err := base.Files(context.Background())
+	if err != nil {
+		t.Fatalf("failed to read base image files: %v", err)
+	}
+
+	builder := tarlayer.NewBuilder()
+	builder.ParentHeader = func(path string) *tar.Header {
+		for _, f := range baseFiles {
+			if f.Path == path && f.Header.Typeflag == tar.TypeDir {
+				return f.Header
+			}
+		}
+		return nil
+	}
+	builder.AddContent("tmp/app/cache", []byte("cache"))
+	layer, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build layer: %v", err)
+	}
+	base.AppendLayer(layer)
+
+	img, err := roundTripImage(base)
+	if err != nil {
+		t.Fatalf("failed to round-trip extended image: %v", err)
+	}
+	files, err := img.Files(context.Background())
+	if err != nil {
+		t.Fatalf("failed to read image files: %v", err)
+	}
+
+	gotModes := make(map[string]fs.FileMode)
+	for _, f := range files {
+		gotModes[f.Path] = f.Header.FileInfo().Mode()
+	}
+	wantModes := map[string]fs.FileMode{
+		"tmp":           fs.ModeDir | fs.ModeSticky | 0777,
+		"tmp/app":       fs.ModeDir | 0755,
+		"tmp/app/cache": 0644,
+	}
+	if diff := cmp.Diff(wantModes, gotModes); diff != "" {
+		t.Errorf("unexpected file modes (-want +got):\n%s", diff)
+	}
+}
+
+// roundTripImage writes img to an archive, and loads it back.
+func roundTripImage(img image.Image) (image.Image, error) {
+	var buf bytes.Buffer
+	if err := WriteImage(img, &buf); err != nil {
+		return image.Image{}, err
+	}
+	index, err := Load(&buf)
+	if err != nil {
+		return image.Image{}, err
+	}
+	return index[0].GetImage(context.Background())
+}
+
+func TestWriteMismatchedLayer(t *testing.T) {
+	// Ensure that we refuse to write an archive containing a layer whose content
+	// does not match its descriptor, before writing any of the archive.
+	var img image.Image
+	for _, content := range []string{"hello world", "goodbye world"} {
+		builder := tarlayer.NewBuilder()
+		builder.AddContent("hello.txt", []byte(content))
+		layer, err := builder.Finish()
+		if err != nil {
+			t.Fatalf("failed to build layer: %v", err)
+		}
+		img.AppendLayer(layer)
+	}
+	img.Layers[1].Descriptor.Digest = digest.FromString("something else entirely")
+
+	var buf bytes.Buffer
+	if err := WriteImage(img, &buf); err == nil {
+		t.Errorf("wrote image with mismatched layer digest")
+	}
+	if buf.Len() > 0 {
+		t.Errorf("wrote %d bytes of the archive before finding the mismatched layer", buf.Len())
+	}
+}
+
 func TestLoadMultiarchArchive(t *testing.T) {
 	// Ensure that we can load a multi-platform OCI archive of the Docker
 	// "hello-world" image pulled with Skopeo.
@@ -82,6 +550,191 @@ func TestLoadMultiarchArchive(t *testing.T) {
 	}
 }
 
+func TestSelectFromMultiarchArchive(t *testing.T) {
+	// Ensure that selecting linux/arm64 from the multi-platform archive, as the
+	// build command does for a base image, finds the same manifest as the
+	// single-platform archive pulled for linux/arm64.
+	multiarchIndex, err := loadTestdataArchive("hello-world-multiarch.tar")
+	if err != nil {
+		t.Fatalf("failed to load multi-platform archive: %v", err)
+	}
+	arm64Index, err := loadTestdataArchive("hello-world-linux-arm64.tar")
+	if err != nil {
+		t.Fatalf("failed to load single-platform archive: %v", err)
+	}
+
+	selected := multiarchIndex.SelectByPlatform(platforms.MustParse("linux/arm64"))
+	if len(selected) == 0 {
+		t.Fatalf("no images selected for linux/arm64")
+	}
+	if got, want := selected[0].Digest, arm64Index[0].Digest; got != want {
+		t.Errorf("selected manifest %s, want %s", got, want)
+	}
+
+	img, err := selected[0].GetImage(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load selected image: %v", err)
+	}
+	if img.Config.OS != "linux" || img.Config.Architecture != "arm64" {
+		t.Errorf("selected image for %s/%s, want linux/arm64", img.Config.OS, img.Config.Architecture)
+	}
+}
+
+func TestLoadGzipConfig(t *testing.T) {
+	// Ensure that we can load an image whose config blob was compressed with
+	// gzip despite its JSON media type, as some tools do.
+	var config bytes.Buffer
+	zw := gzip.NewWriter(&config)
+	zw.Write([]byte(`{"architecture":"arm64","os":"linux","rootfs":{"type":"layers","diff_ids":[]}}`))
+	zw.Close()
+	configDigest := digest.FromBytes(config.Bytes())
+
+	manifest := []byte(fmt.Sprintf(
+		`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":%q,"digest":%q,"size":%d},"layers":[]}`,
+		specsv1.MediaTypeImageManifest, specsv1.MediaTypeImageConfig, configDigest, config.Len(),
+	))
+	manifestDigest := digest.FromBytes(manifest)
+
+	var buf bytes.Buffer
+	tb := tarbuild.NewBuilder(&buf)
+	tb.AddContent("oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`))
+	tb.AddContent("index.json", []byte(fmt.Sprintf(
+		`{"schemaVersion":2,"manifests":[{"mediaType":%q,"digest":%q,"size":%d}]}`,
+		specsv1.MediaTypeImageManifest, manifestDigest, len(manifest),
+	)))
+	tb.AddContent("blobs/sha256/"+configDigest.Encoded(), config.Bytes())
+	tb.AddContent("blobs/sha256/"+manifestDigest.Encoded(), manifest)
+	if err := tb.Close(); err != nil {
+		t.Fatalf("failed to build test archive: %v", err)
+	}
+
+	index, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("failed to load archive: %v", err)
+	}
+	img, err := index[0].GetImage(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load image: %v", err)
+	}
+	if img.Config.OS != "linux" || img.Config.Architecture != "arm64" {
+		t.Errorf("loaded config for %s/%s, want linux/arm64", img.Config.OS, img.Config.Architecture)
+	}
+}
+
+func TestLoadInvalidConfig(t *testing.T) {
+	// Ensure that a config blob that is not a JSON object is rejected, even when
+	// it is compressed with gzip.
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte(`["linux"]`))
+	zw.Close()
+
+	for _, config := range [][]byte{[]byte(`["linux"]`), compressed.Bytes()} {
+		configDigest := digest.FromBytes(config)
+		manifest := []byte(fmt.Sprintf(
+			`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":%q,"digest":%q,"size":%d},"layers":[]}`,
+			specsv1.MediaTypeImageManifest, specsv1.MediaTypeImageConfig, configDigest, len(config),
+		))
+		manifestDigest := digest.FromBytes(manifest)
+
+		var buf bytes.Buffer
+		tb := tarbuild.NewBuilder(&buf)
+		tb.AddContent("oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`))
+		tb.AddContent("index.json", []byte(fmt.Sprintf(
+			`{"schemaVersion":2,"manifests":[{"mediaType":%q,"digest":%q,"size":%d}]}`,
+			specsv1.MediaTypeImageManifest, manifestDigest, len(manifest),
+		)))
+		tb.AddContent("blobs/sha256/"+configDigest.Encoded(), config)
+		tb.AddContent("blobs/sha256/"+manifestDigest.Encoded(), manifest)
+		if err := tb.Close(); err != nil {
+			t.Fatalf("failed to build test archive: %v", err)
+		}
+
+		index, err := Load(&buf)
+		if err == nil {
+			_, err = index[0].GetImage(context.Background())
+		}
+		if !errors.Is(err, image.ErrInvalidConfigBlob) {
+			t.Errorf("got error %v, want %v", err, image.ErrInvalidConfigBlob)
+		}
+	}
+}
+
+func TestLoadStrict(t *testing.T) {
+	layer := []byte("not really a layer")
+	layerDigest := digest.FromBytes(layer)
+	config := []byte(fmt.Sprintf(
+		`{"architecture":"arm64","os":"linux","rootfs":{"type":"layers","diff_ids":[%q]}}`,
+		layerDigest,
+	))
+	configDigest := digest.FromBytes(config)
+	manifest := []byte(fmt.Sprintf(
+		`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":%q,"digest":%q,"size":%d},"layers":[{"mediaType":%q,"digest":%q,"size":%d}]}`,
+		specsv1.MediaTypeImageManifest, specsv1.MediaTypeImageConfig, configDigest, len(config),
+		specsv1.MediaTypeImageLayer, layerDigest, len(layer),
+	))
+	manifestDigest := digest.FromBytes(manifest)
+	extra := []byte("left behind by a buggy tool")
+	extraDigest := digest.FromBytes(extra)
+
+	buildArchive := func(withLayer bool) *bytes.Buffer {
+		var buf bytes.Buffer
+		tb := tarbuild.NewBuilder(&buf)
+		tb.AddContent("oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`))
+		tb.AddContent("index.json", []byte(fmt.Sprintf(
+			`{"schemaVersion":2,"manifests":[{"mediaType":%q,"digest":%q,"size":%d}]}`,
+			specsv1.MediaTypeImageManifest, manifestDigest, len(manifest),
+		)))
+		tb.AddContent("blobs/sha256/"+configDigest.Encoded(), config)
+		tb.AddContent("blobs/sha256/"+manifestDigest.Encoded(), manifest)
+		tb.AddContent("blobs/sha256/"+extraDigest.Encoded(), extra)
+		if withLayer {
+			tb.AddContent("blobs/sha256/"+layerDigest.Encoded(), layer)
+		}
+		if err := tb.Close(); err != nil {
+			t.Fatalf("failed to build test archive: %v", err)
+		}
+		return &buf
+	}
+
+	if _, err := Load(buildArchive(false)); err != nil {
+		t.Errorf("failed to load archive with missing layer in non-strict mode: %v", err)
+	}
+	if _, err := LoadWithOptions(buildArchive(false), LoadOptions{Strict: true}); err == nil {
+		t.Error("loaded archive with missing layer in strict mode")
+	}
+
+	var unreferenced []digest.Digest
+	_, err := LoadWithOptions(buildArchive(true), LoadOptions{
+		Strict:           true,
+		UnreferencedBlob: func(dgst digest.Digest) { unreferenced = append(unreferenced, dgst) },
+	})
+	if err != nil {
+		t.Fatalf("failed to load complete archive in strict mode: %v", err)
+	}
+	if diff := cmp.Diff([]digest.Digest{extraDigest}, unreferenced); diff != "" {
+		t.Errorf("unexpected unreferenced blobs (-want +got):\n%s", diff)
+	}
+}
+
+func TestLoadUnsupportedDigestAlgorithm(t *testing.T) {
+	// Ensure that blobs named with a digest algorithm that zeroimage was not
+	// built with are rejected with a clear error, rather than failing obscurely
+	// when we try to verify their contents.
+	var buf bytes.Buffer
+	tb := tarbuild.NewBuilder(&buf)
+	tb.AddContent("oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`))
+	tb.AddContent("blobs/md5/5d41402abc4b2a76b9719d911017c592", []byte("hello"))
+	if err := tb.Close(); err != nil {
+		t.Fatalf("failed to build test archive: %v", err)
+	}
+
+	_, err := Load(&buf)
+	if !errors.Is(err, digest.ErrDigestUnsupported) {
+		t.Errorf("unexpected error loading archive\ngot:  %v\nwant: %v", err, digest.ErrDigestUnsupported)
+	}
+}
+
 func loadTestdataArchive(name string) (image.Index, error) {
 	wd, err := os.Getwd()
 	if err != nil {
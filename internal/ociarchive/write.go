@@ -3,60 +3,168 @@ package ociarchive
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
+	"os"
 
 	"github.com/opencontainers/go-digest"
 	"github.com/opencontainers/image-spec/specs-go"
 	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sync/errgroup"
 
 	"go.alexhamlin.co/zeroimage/internal/image"
 	"go.alexhamlin.co/zeroimage/internal/tarbuild"
 )
 
+// WriteOptions customizes the archives produced by WriteImageWithOptions.
+type WriteOptions struct {
+	// DigestAlgorithm is the algorithm used to compute the digests of the
+	// manifest and configuration blobs generated for the archive. The zero
+	// value selects digest.Canonical.
+	DigestAlgorithm digest.Algorithm
+	// DebugWriter, if set, receives the exact JSON encodings of the image
+	// configuration and manifest written to the archive, in that order, each
+	// followed by a newline. Errors writing to DebugWriter are ignored.
+	DebugWriter io.Writer
+	// RefName, if set, is the value of the "org.opencontainers.image.ref.name"
+	// annotation on the image's descriptor in the archive's index, which tools
+	// like skopeo use to find images by name in an OCI layout.
+	RefName string
+	// IndexAnnotations, if set, are the top-level annotations of the archive's
+	// index, which are separate from the annotations of the image's manifest.
+	IndexAnnotations map[string]string
+	// DescriptorAnnotations, if set, are the annotations of the image's
+	// descriptor in the archive's index, which are separate from both the
+	// annotations of the image's manifest and the top-level annotations of the
+	// index. RefName takes precedence over any ref name annotation here.
+	DescriptorAnnotations map[string]string
+	// ConfigMediaType is the media type of the image's configuration blob, which
+	// artifacts that are not runnable images may customize. The zero value
+	// selects the OCI image configuration media type.
+	ConfigMediaType string
+}
+
 // WriteImage writes a single container image as a tar archive whose contents
-// comply with the OCI Image Layout Specification.
+// comply with the OCI Image Layout Specification, using the default
+// WriteOptions.
 func WriteImage(img image.Image, w io.Writer) error {
+	return WriteImageWithOptions(img, w, WriteOptions{})
+}
+
+// WriteImageWithOptions writes a single container image as a tar archive whose
+// contents comply with the OCI Image Layout Specification, as customized by
+// opts. WriteImageWithOptions checks img with image.Image.Validate before
+// writing anything.
+func WriteImageWithOptions(img image.Image, w io.Writer, opts WriteOptions) error {
+	if err := img.Validate(); err != nil {
+		return err
+	}
 	iw := imageWriter{
 		tar:   tarbuild.NewBuilder(w),
 		image: img,
+		opts:  opts.withDefaults(),
 	}
 	return iw.WriteImage()
 }
 
+// ManifestDescriptor returns the manifest that WriteImageWithOptions writes for
+// img with opts, along with its descriptor, without writing an archive.
+func ManifestDescriptor(img image.Image, opts WriteOptions) (image.Manifest, specsv1.Descriptor, error) {
+	opts = opts.withDefaults()
+	manifest, _, err := encodeManifest(img, opts)
+	if err != nil {
+		return image.Manifest{}, specsv1.Descriptor{}, err
+	}
+	manifestJSON := mustJSONMarshal(manifest)
+	desc := specsv1.Descriptor{
+		MediaType: specsv1.MediaTypeImageManifest,
+		Digest:    opts.DigestAlgorithm.FromBytes(manifestJSON),
+		Size:      int64(len(manifestJSON)),
+	}
+	return manifest, desc, nil
+}
+
+// withDefaults returns a copy of opts with its zero values replaced by
+// defaults.
+func (opts WriteOptions) withDefaults() WriteOptions {
+	if opts.DigestAlgorithm == "" {
+		opts.DigestAlgorithm = digest.Canonical
+	}
+	if opts.ConfigMediaType == "" {
+		opts.ConfigMediaType = specsv1.MediaTypeImageConfig
+	}
+	return opts
+}
+
+// encodeManifest returns the manifest of img as written to an archive with
+// opts, along with the JSON encoding of the configuration blob it refers to.
+func encodeManifest(img image.Image, opts WriteOptions) (image.Manifest, []byte, error) {
+	configJSON, err := image.EncodeConfig(img.Config)
+	if err != nil {
+		return image.Manifest{}, nil, err
+	}
+	manifest := img.Manifest(specsv1.Descriptor{
+		MediaType: opts.ConfigMediaType,
+		Digest:    opts.DigestAlgorithm.FromBytes(configJSON),
+		Size:      int64(len(configJSON)),
+	})
+	return manifest, configJSON, nil
+}
+
 type imageWriter struct {
 	tar   *tarbuild.Builder
 	image image.Image
+	opts  WriteOptions
 }
 
 func (iw *imageWriter) WriteImage() error {
-	for _, layer := range iw.image.Layers {
-		blob, err := layer.OpenBlob(context.TODO())
-		if err != nil {
+	blobs, err := prepareLayerBlobs(context.TODO(), iw.image.Layers)
+	defer blobs.Close()
+	if err != nil {
+		return err
+	}
+
+	written := make(map[digest.Digest]bool)
+	for i, layer := range iw.image.Layers {
+		// An image may repeat a layer, but the archive can only hold one copy of
+		// its blob.
+		if written[layer.Descriptor.Digest] {
+			continue
+		}
+		written[layer.Descriptor.Digest] = true
+
+		if _, err := blobs[i].Seek(0, io.SeekStart); err != nil {
 			return err
 		}
-		err = iw.addBlob(layer.Descriptor, blob)
-		if err != nil {
+		if err := iw.addBlob(layer.Descriptor, blobs[i]); err != nil {
 			return err
 		}
 	}
 
-	manifest := specsv1.Manifest{
-		Versioned:   specs.Versioned{SchemaVersion: 2},
-		MediaType:   specsv1.MediaTypeImageManifest,
-		Config:      iw.addJSONBlob(specsv1.MediaTypeImageConfig, iw.image.Config),
-		Annotations: iw.image.Annotations,
-	}
-	for _, layer := range iw.image.Layers {
-		manifest.Layers = append(manifest.Layers, layer.Descriptor)
+	manifest, configJSON, err := encodeManifest(iw.image, iw.opts)
+	if err != nil {
+		return err
 	}
+	iw.addRawJSONBlob(iw.opts.ConfigMediaType, configJSON)
 
 	manifestDesc := iw.addJSONBlob(specsv1.MediaTypeImageManifest, manifest)
-	manifestDesc.Platform = &iw.image.Platform
+	platform := iw.image.IndexPlatform()
+	manifestDesc.Platform = &platform
+	if len(iw.opts.DescriptorAnnotations) > 0 || iw.opts.RefName != "" {
+		manifestDesc.Annotations = make(map[string]string, len(iw.opts.DescriptorAnnotations)+1)
+		for k, v := range iw.opts.DescriptorAnnotations {
+			manifestDesc.Annotations[k] = v
+		}
+		if iw.opts.RefName != "" {
+			manifestDesc.Annotations[specsv1.AnnotationRefName] = iw.opts.RefName
+		}
+	}
 
 	iw.addJSONFile("index.json", specsv1.Index{
-		Versioned: specs.Versioned{SchemaVersion: 2},
-		MediaType: specsv1.MediaTypeImageIndex,
-		Manifests: []specsv1.Descriptor{manifestDesc},
+		Versioned:   specs.Versioned{SchemaVersion: 2},
+		MediaType:   specsv1.MediaTypeImageIndex,
+		Manifests:   []specsv1.Descriptor{manifestDesc},
+		Annotations: iw.opts.IndexAnnotations,
 	})
 
 	iw.addJSONFile(specsv1.ImageLayoutFile, specsv1.ImageLayout{
@@ -66,9 +174,89 @@ func (iw *imageWriter) WriteImage() error {
 	return iw.tar.Close()
 }
 
+// concurrentBlobPreparations is the number of layer blobs that prepareLayerBlobs
+// will read at once.
+const concurrentBlobPreparations = 3
+
+// preparedBlobs holds local copies of layer blobs in temporary files.
+type preparedBlobs []*os.File
+
+// Close removes the temporary files holding the prepared blobs.
+func (pb preparedBlobs) Close() {
+	for _, f := range pb {
+		if f != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}
+}
+
+// prepareLayerBlobs concurrently copies the blob of each layer into a temporary
+// file, verifying that its content matches the layer's descriptor. This lets
+// the blobs of layers from slow sources, like remote registries, download in
+// parallel before they are written serially into the archive, and surfaces any
+// mismatched layer before the archive is partially written. Since each blob is
+// verified here as it is copied, addBlob does not verify it again. The client
+// must close the result even when prepareLayerBlobs returns an error.
+func prepareLayerBlobs(ctx context.Context, layers []image.Layer) (preparedBlobs, error) {
+	blobs := make(preparedBlobs, len(layers))
+	indexes := make(chan int, len(layers))
+	for i := range layers {
+		indexes <- i
+	}
+	close(indexes)
+
+	eg, ectx := errgroup.WithContext(ctx)
+	for i := 0; i < concurrentBlobPreparations; i++ {
+		eg.Go(func() error {
+			for i := range indexes {
+				f, err := os.CreateTemp("", "zeroimage-blob-*")
+				if err != nil {
+					return err
+				}
+				blobs[i] = f
+				if err := copyLayerBlob(ectx, f, layers[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return blobs, eg.Wait()
+}
+
+// copyLayerBlob copies the blob of layer to w, and returns an error if the
+// content does not match the digest and size of the layer's descriptor.
+func copyLayerBlob(ctx context.Context, w io.Writer, layer image.Layer) error {
+	desc := layer.Descriptor
+	if err := desc.Digest.Validate(); err != nil {
+		return err
+	}
+
+	blob, err := layer.OpenBlob(ctx)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	verifier := desc.Digest.Verifier()
+	n, err := io.Copy(io.MultiWriter(w, verifier), blob)
+	if err != nil {
+		return err
+	}
+	if n != desc.Size {
+		return fmt.Errorf("layer %s has size %d, expected %d", desc.Digest, n, desc.Size)
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("content of layer %s does not match its digest", desc.Digest)
+	}
+	return nil
+}
+
+// addBlob copies the content of a blob that prepareLayerBlobs has already
+// verified into the archive.
 func (iw *imageWriter) addBlob(desc specsv1.Descriptor, blob io.Reader) error {
-	digest := desc.Digest
-	path := "blobs/" + string(digest.Algorithm()) + "/" + digest.Encoded()
+	path := "blobs/" + string(desc.Digest.Algorithm()) + "/" + desc.Digest.Encoded()
 	return iw.tar.Add(path, tarbuild.File{
 		Reader: blob,
 		Mode:   0644,
@@ -82,13 +270,20 @@ func (iw *imageWriter) addBlobContent(digest digest.Digest, content []byte) {
 }
 
 func (iw *imageWriter) addJSONBlob(mediaType string, v interface{}) specsv1.Descriptor {
-	encoded := mustJSONMarshal(v)
+	return iw.addRawJSONBlob(mediaType, mustJSONMarshal(v))
+}
+
+// addRawJSONBlob adds a blob with content that is already encoded as JSON.
+func (iw *imageWriter) addRawJSONBlob(mediaType string, encoded []byte) specsv1.Descriptor {
 	desc := specsv1.Descriptor{
 		MediaType: mediaType,
-		Digest:    digest.FromBytes(encoded),
+		Digest:    iw.opts.DigestAlgorithm.FromBytes(encoded),
 		Size:      int64(len(encoded)),
 	}
 	iw.addBlobContent(desc.Digest, encoded)
+	if iw.opts.DebugWriter != nil {
+		iw.opts.DebugWriter.Write(append(encoded, '\n'))
+	}
 	return desc
 }
 
@@ -0,0 +1,92 @@
+package registry
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultDialRetryWait is the maximum total time that LoadWithOptions waits
+// between retries of requests that fail to connect to a host, unless
+// LoadOptions.DialRetryWait selects a different time.
+const DefaultDialRetryWait = 15 * time.Second
+
+// dialRetryTimeout returns the timeout for an HTTP client whose requests may be
+// retried by a dialRetryTransport with the provided maximum wait. The wait is
+// added to the usual timeout, so that the client's deadline does not cut the
+// retries short and hide the last connection error behind its own.
+func dialRetryTimeout(maxWait time.Duration) time.Duration {
+	if maxWait <= 0 {
+		return httpTimeout
+	}
+	return httpTimeout + maxWait
+}
+
+// Delays between retries of requests that fail to connect, which double after
+// each retry up to the maximum.
+var (
+	initialDialRetryDelay = 250 * time.Millisecond
+	maxDialRetryDelay     = 4 * time.Second
+)
+
+// dialRetryTransport retries requests that fail before connecting to a host,
+// like those in a freshly started container whose DNS is not ready yet, or
+// those sent while a registry is briefly unreachable. Since these requests
+// never reached the host, retrying them is safe regardless of their method.
+// Errors reported by the host itself, including HTTP error statuses, are
+// returned without a retry.
+type dialRetryTransport struct {
+	inner   http.RoundTripper
+	maxWait time.Duration
+	debugf  func(format string, v ...interface{})
+}
+
+func (t dialRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var waited time.Duration
+	delay := initialDialRetryDelay
+	for {
+		resp, err := t.inner.RoundTrip(req)
+		if err == nil || !isDialError(err) || waited+delay > t.maxWait {
+			return resp, err
+		}
+		// A request whose body cannot be replayed may have had its body consumed
+		// by the failed attempt.
+		if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+			return resp, err
+		}
+
+		t.debugf("Retrying %s %s in %v after connection failure: %v", req.Method, req.URL.Redacted(), delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		waited += delay
+		if delay *= 2; delay > maxDialRetryDelay {
+			delay = maxDialRetryDelay
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// isDialError returns true if err represents a failure to resolve or connect to
+// a host, as opposed to a failure after the connection was established.
+func isDialError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
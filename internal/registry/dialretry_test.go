@@ -0,0 +1,74 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type failingDialTransport struct {
+	attempts int
+}
+
+func (t *failingDialTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.attempts++
+	return nil, &net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("attempt %d refused", t.attempts)}
+}
+
+func TestDialRetryReturnsLastError(t *testing.T) {
+	defer func(initial, max time.Duration) {
+		initialDialRetryDelay, maxDialRetryDelay = initial, max
+	}(initialDialRetryDelay, maxDialRetryDelay)
+	initialDialRetryDelay, maxDialRetryDelay = time.Millisecond, 4*time.Millisecond
+
+	inner := &failingDialTransport{}
+	client := http.Client{
+		Transport: dialRetryTransport{inner, 20 * time.Millisecond, func(string, ...interface{}) {}},
+		Timeout:   dialRetryTimeout(20 * time.Millisecond),
+	}
+	_, err := client.Get("http://registry.invalid/v2/")
+	if err == nil {
+		t.Fatal("request succeeded through a failing transport")
+	}
+	if inner.attempts < 2 {
+		t.Fatalf("made %d attempt(s), want retries", inner.attempts)
+	}
+	if !isDialError(err) {
+		t.Errorf("got %v, want a dial error", err)
+	}
+	if want := fmt.Sprintf("attempt %d refused", inner.attempts); !strings.Contains(err.Error(), want) {
+		t.Errorf("got %v, want the error from the last attempt (%s)", err, want)
+	}
+}
+
+func TestDialRetryTimeoutExceedsWait(t *testing.T) {
+	for _, wait := range []time.Duration{-1, 0, time.Second, DefaultDialRetryWait} {
+		if got := dialRetryTimeout(wait); got < httpTimeout || got <= wait {
+			t.Errorf("dialRetryTimeout(%v) = %v, want more than the wait and at least %v", wait, got, httpTimeout)
+		}
+	}
+}
+
+func TestLoadReturnsDialError(t *testing.T) {
+	// Reserve an address that nothing listens on.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve address: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	_, err = LoadWithOptions(context.Background(), addr+"/test/image:latest", LoadOptions{DialRetryWait: 300 * time.Millisecond})
+	if err == nil {
+		t.Fatal("loaded image from a registry that is not listening")
+	}
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "dial" {
+		t.Errorf("got %v, want a dial error", err)
+	}
+}
@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/types"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// DockerConfigKeychain returns a keychain that resolves credentials from the
+// Docker configuration in dir, including any credential helpers that it names.
+// Unlike authn.DefaultKeychain, which prefers the configuration in the user's
+// home directory, the keychain never reads credentials from anywhere else.
+func DockerConfigKeychain(dir string) authn.Keychain {
+	return dockerConfigKeychain{dir}
+}
+
+type dockerConfigKeychain struct {
+	dir string
+}
+
+// Resolve implements authn.Keychain, following the same lookup rules as
+// authn.DefaultKeychain.
+func (k dockerConfigKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	cf, err := config.Load(k.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range []string{target.String(), target.RegistryStr()} {
+		if key == name.DefaultRegistry {
+			key = authn.DefaultAuthKey
+		}
+		cfg, err := cf.GetAuthConfig(key)
+		if err != nil {
+			return nil, err
+		}
+		if cfg != (types.AuthConfig{}) {
+			return authn.FromConfig(authn.AuthConfig{
+				Username:      cfg.Username,
+				Password:      cfg.Password,
+				Auth:          cfg.Auth,
+				IdentityToken: cfg.IdentityToken,
+				RegistryToken: cfg.RegistryToken,
+			}), nil
+		}
+	}
+	return authn.Anonymous, nil
+}
+
+// OIDCTokenFile returns an authenticator that exchanges the OpenID Connect ID
+// token in the file at path for a registry bearer token. The ID token is sent
+// to the registry's token service as an OAuth2 refresh token, along with the
+// service and scope that the registry requested, for registries that trust the
+// token's issuer. The file is read whenever a token is needed, so that tokens
+// rotated by a CI system are picked up.
+func OIDCTokenFile(path string) authn.Authenticator {
+	return oidcTokenFile{path}
+}
+
+type oidcTokenFile struct {
+	path string
+}
+
+// Authorization implements authn.Authenticator.
+func (a oidcTokenFile) Authorization() (*authn.AuthConfig, error) {
+	content, err := os.ReadFile(a.path)
+	if err != nil {
+		return nil, err
+	}
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return nil, fmt.Errorf("OIDC token file %s is empty", a.path)
+	}
+	// The username identifies the password as an identity token, following the
+	// convention of the Docker credential store.
+	return &authn.AuthConfig{Username: "<token>", IdentityToken: token}, nil
+}
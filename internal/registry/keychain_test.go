@@ -0,0 +1,46 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func TestDockerConfigKeychain(t *testing.T) {
+	// Ensure that credentials come from the provided directory, even when the
+	// environment points somewhere else.
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	config := `{"auths":{"registry.example.com":{"auth":"dXNlcjpzZWNyZXQ="}}}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatalf("failed to write Docker config: %v", err)
+	}
+	keychain := DockerConfigKeychain(dir)
+
+	repo, err := name.NewRepository("registry.example.com/app")
+	if err != nil {
+		t.Fatalf("failed to parse repository: %v", err)
+	}
+	auth, err := keychain.Resolve(repo)
+	if err != nil {
+		t.Fatalf("failed to resolve credentials: %v", err)
+	}
+	cfg, err := auth.Authorization()
+	if err != nil {
+		t.Fatalf("failed to read authorization: %v", err)
+	}
+	if cfg.Username != "user" || cfg.Password != "secret" {
+		t.Errorf("got credentials %q:%q, want user:secret", cfg.Username, cfg.Password)
+	}
+
+	other, err := name.NewRepository("other.example.com/app")
+	if err != nil {
+		t.Fatalf("failed to parse repository: %v", err)
+	}
+	if auth, err := keychain.Resolve(other); err != nil || auth != authn.Anonymous {
+		t.Errorf("got %v, %v for unknown registry, want anonymous", auth, err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
@@ -15,35 +16,142 @@ import (
 	"go.alexhamlin.co/zeroimage/internal/image"
 )
 
+// LoadOptions customizes the behavior of LoadWithOptions.
+type LoadOptions struct {
+	// UserAgent is the value of the User-Agent header sent with each request.
+	// The zero value selects the UserAgent of the Client, or DefaultUserAgent.
+	UserAgent string
+	// Concurrency is the maximum number of manifests in an index whose platforms
+	// are determined at once, as described by image.LoadOptions. The zero value
+	// selects image.DefaultLoadConcurrency.
+	Concurrency int
+	// DialRetryWait is the maximum total time to wait between retries of
+	// requests that fail to resolve or connect to a host, with delays that
+	// increase after each retry. Requests that reach the host are not retried,
+	// whatever their outcome, and the timeout of each request is extended by
+	// this time so that it does not expire before the retries do. The zero
+	// value selects DefaultDialRetryWait, and a negative value disables these
+	// retries.
+	DialRetryWait time.Duration
+}
+
 // Load loads an image index identified by a Docker-style reference from a
 // remote OCI registry, using credentials from the local Docker keychain to
-// authenticate to the registry if necessary.
+// authenticate to the registry if necessary, and using the default
+// LoadOptions.
 func Load(ctx context.Context, reference string) (image.Index, error) {
-	name, err := name.ParseReference(reference)
+	return LoadWithOptions(ctx, reference, LoadOptions{})
+}
+
+// LoadWithOptions loads an image index from a remote OCI registry like Load, as
+// customized by opts.
+func LoadWithOptions(ctx context.Context, reference string, opts LoadOptions) (image.Index, error) {
+	return (&Client{}).LoadWithOptions(ctx, reference, opts)
+}
+
+// LoadWithOptions loads an image index like the package-level LoadWithOptions,
+// using the connection settings of c.
+func (c *Client) LoadWithOptions(ctx context.Context, reference string, opts LoadOptions) (image.Index, error) {
+	index, _, err := c.load(ctx, reference, opts)
+	return index, err
+}
+
+// CheckPullAuth validates that the current authentication configuration allows
+// pulling the image named by reference, by loading its image index with the
+// provided options. If reference names an image index, CheckPullAuth returns
+// the number of platforms in the index; if it names a single image manifest,
+// CheckPullAuth returns 0.
+func CheckPullAuth(ctx context.Context, reference string, opts LoadOptions) (platforms int, err error) {
+	return (&Client{}).CheckPullAuth(ctx, reference, opts)
+}
+
+// CheckPullAuth validates pull access to an image like the package-level
+// CheckPullAuth, using the connection settings of c.
+func (c *Client) CheckPullAuth(ctx context.Context, reference string, opts LoadOptions) (platforms int, err error) {
+	index, l, err := c.load(ctx, reference, opts)
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	if !isIndexMediaType(l.rootMediaType) {
+		return 0, nil
+	}
+	return len(index), nil
+}
+
+func (c *Client) load(ctx context.Context, reference string, opts LoadOptions) (image.Index, *loader, error) {
+	socket, reference, err := splitUnixReference(reference)
+	if err != nil {
+		return nil, nil, err
+	}
+	name, err := parseLoadReference(reference)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	transport, err := newTransport(ctx, name, transport.PullScope)
+	if opts.DialRetryWait == 0 {
+		opts.DialRetryWait = DefaultDialRetryWait
+	}
+	transport, err := c.newTransport(ctx, name, socket, opts.UserAgent, opts.DialRetryWait, transport.PullScope)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return image.Load(ctx, loader{
+	l := &loader{
 		Name: name,
 		Client: http.Client{
 			Transport: transport,
-			Timeout:   httpTimeout,
+			Timeout:   dialRetryTimeout(opts.DialRetryWait),
 		},
-	})
+	}
+	index, err := image.LoadWithOptions(ctx, l, image.LoadOptions{Concurrency: opts.Concurrency})
+	return index, l, err
+}
+
+// isIndexMediaType returns true if mediaType, as reported in a Content-Type
+// header, is one of image.SupportedIndexMediaTypes.
+func isIndexMediaType(mediaType string) bool {
+	mediaType, _, _ = strings.Cut(mediaType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	for _, t := range image.SupportedIndexMediaTypes {
+		if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLoadReference parses a reference to an image to load, checking any
+// digest it contains before the registry sees it. A bare digest with no
+// repository would otherwise parse as a tag of a Docker Hub repository named
+// after the digest algorithm, and a truncated digest would fail with an error
+// that does not say what is wrong with it.
+func parseLoadReference(reference string) (name.Reference, error) {
+	if alg, _, ok := strings.Cut(reference, ":"); ok && digest.Algorithm(alg).Available() {
+		return nil, fmt.Errorf("reference %q is a digest without a repository; use REPOSITORY@%s", reference, reference)
+	}
+
+	if _, dgst, ok := strings.Cut(reference, "@"); ok {
+		if _, err := digest.Parse(dgst); err != nil {
+			return nil, fmt.Errorf("invalid digest in reference %q: %w", reference, err)
+		}
+	}
+
+	return name.ParseReference(reference)
 }
 
 type loader struct {
 	Name   name.Reference
 	Client http.Client
+
+	// reportedRootDigest is the digest of the root manifest as reported by the
+	// registry, and is only set after the root manifest has been opened.
+	reportedRootDigest digest.Digest
+	// rootMediaType is the Content-Type of the root manifest as reported by the
+	// registry, and is only set after the root manifest has been opened.
+	rootMediaType string
 }
 
-func (l loader) RootDigest() (dgst digest.Digest, ok bool) {
+func (l *loader) RootDigest() (dgst digest.Digest, ok bool) {
 	if ndgst, ok := l.Name.(name.Digest); ok {
 		dgst, err := digest.Parse(ndgst.DigestStr())
 		if err != nil {
@@ -51,23 +159,55 @@ func (l loader) RootDigest() (dgst digest.Digest, ok bool) {
 		}
 		return dgst, true
 	}
+	if l.reportedRootDigest != "" {
+		return l.reportedRootDigest, true
+	}
 	return digest.FromString(""), false
 }
 
-func (l loader) OpenRootManifest(ctx context.Context) (io.ReadCloser, error) {
+func (l *loader) OpenRootManifest(ctx context.Context) (io.ReadCloser, error) {
 	req := l.newGetRequest(ctx, "manifests", l.Name.Identifier())
 	req.Header.Set("Accept", strings.Join(acceptedManifestTypes, ","))
-	return l.doRequest(req)
+	resp, err := l.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	l.rootMediaType = resp.Header.Get("Content-Type")
+
+	// For a tag reference, the digest reported by the registry is the only way
+	// to verify that the content we received is the content it meant to send.
+	if dgst, ok := l.RootDigest(); ok {
+		err = checkContentDigest(resp, dgst)
+	} else if reported, ok := reportedContentDigest(resp); ok {
+		l.reportedRootDigest = reported
+	}
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
 }
 
-func (l loader) OpenManifest(ctx context.Context, dgst digest.Digest) (io.ReadCloser, error) {
+func (l *loader) OpenManifest(ctx context.Context, dgst digest.Digest) (io.ReadCloser, error) {
 	req := l.newGetRequest(ctx, "manifests", dgst.String())
 	req.Header.Set("Accept", strings.Join(acceptedManifestTypes, ","))
-	return l.doRequest(req)
+	resp, err := l.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkContentDigest(resp, dgst); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
 }
 
-func (l loader) OpenBlob(ctx context.Context, dgst digest.Digest) (io.ReadCloser, error) {
-	return l.doRequest(l.newGetRequest(ctx, "blobs", dgst.String()))
+func (l *loader) OpenBlob(ctx context.Context, dgst digest.Digest) (io.ReadCloser, error) {
+	resp, err := l.doRequest(l.newGetRequest(ctx, "blobs", dgst.String()))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
 }
 
 var acceptedManifestTypes []string
@@ -77,7 +217,7 @@ func init() {
 	acceptedManifestTypes = append(acceptedManifestTypes, image.SupportedManifestMediaTypes...)
 }
 
-func (l loader) newGetRequest(ctx context.Context, kind, identifer string) *http.Request {
+func (l *loader) newGetRequest(ctx context.Context, kind, identifer string) *http.Request {
 	url := l.formatURL(kind, identifer)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url.String(), nil)
 	if err != nil {
@@ -86,7 +226,7 @@ func (l loader) newGetRequest(ctx context.Context, kind, identifer string) *http
 	return req
 }
 
-func (l loader) formatURL(kind, identifier string) url.URL {
+func (l *loader) formatURL(kind, identifier string) url.URL {
 	return url.URL{
 		Scheme: l.Name.Context().Registry.Scheme(),
 		Host:   l.Name.Context().RegistryStr(),
@@ -94,10 +234,14 @@ func (l loader) formatURL(kind, identifier string) url.URL {
 	}
 }
 
-func (l loader) doRequest(req *http.Request) (io.ReadCloser, error) {
+func (l *loader) doRequest(req *http.Request) (*http.Response, error) {
 	resp, err := l.Client.Do(req)
 	if err != nil {
 		return nil, err
 	}
-	return resp.Body, transport.CheckError(resp, http.StatusOK)
+	if err := transport.CheckError(resp, http.StatusOK); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
 }
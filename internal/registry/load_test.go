@@ -0,0 +1,283 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/platforms"
+	"github.com/google/go-cmp/cmp"
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"go.alexhamlin.co/zeroimage/internal/image"
+	"go.alexhamlin.co/zeroimage/internal/registry/registrytest"
+	"go.alexhamlin.co/zeroimage/internal/tarlayer"
+)
+
+func TestPushAndLoad(t *testing.T) {
+	// Ensure that an image pushed to a registry loads back with the same
+	// platform, artifact type, and layers.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	server := httptest.NewServer(registrytest.New())
+	defer server.Close()
+
+	builder := tarlayer.NewBuilder()
+	builder.AddContent("hello.txt", []byte("hello world"))
+	layer, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build test layer: %v", err)
+	}
+	var img image.Image
+	img.SetPlatform(platforms.MustParse("linux/amd64"))
+	img.AppendLayer(layer)
+	img.ArtifactType = "application/vnd.example.test"
+
+	reference := strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+	if err := PushImage(context.Background(), img, reference); err != nil {
+		t.Fatalf("failed to push image: %v", err)
+	}
+
+	index, err := Load(context.Background(), reference)
+	if err != nil {
+		t.Fatalf("failed to load image index: %v", err)
+	}
+	if len(index) != 1 {
+		t.Fatalf("loaded %d image(s), want 1", len(index))
+	}
+	loaded, err := index[0].GetImage(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load image: %v", err)
+	}
+
+	if got, want := platforms.Format(loaded.Platform), "linux/amd64"; got != want {
+		t.Errorf("loaded image for %s, want %s", got, want)
+	}
+	if loaded.ArtifactType != img.ArtifactType {
+		t.Errorf("loaded image with artifact type %q, want %q", loaded.ArtifactType, img.ArtifactType)
+	}
+	if len(loaded.Layers) != 1 || loaded.Layers[0].Descriptor.Digest != layer.Descriptor.Digest {
+		t.Fatalf("loaded layers %v, want only %s", loaded.Layers, layer.Descriptor.Digest)
+	}
+	blob, err := loaded.Layers[0].OpenBlob(context.Background())
+	if err != nil {
+		t.Fatalf("failed to open loaded layer: %v", err)
+	}
+	defer blob.Close()
+	content, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatalf("failed to read loaded layer: %v", err)
+	}
+	if layer.Descriptor.Digest.Algorithm().FromBytes(content) != layer.Descriptor.Digest {
+		t.Errorf("loaded layer content does not match its digest")
+	}
+}
+
+func TestLoadRetriesConnectionFailures(t *testing.T) {
+	// Ensure that a load retries requests to a registry that is not yet
+	// listening, and succeeds once the registry starts, while a load with
+	// retries disabled fails immediately.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	defer func(delay time.Duration) { initialDialRetryDelay = delay }(initialDialRetryDelay)
+	initialDialRetryDelay = 50 * time.Millisecond
+
+	reg := registrytest.New()
+	server := httptest.NewServer(reg)
+	builder := tarlayer.NewBuilder()
+	builder.AddContent("hello.txt", []byte("hello world"))
+	layer, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build test layer: %v", err)
+	}
+	var img image.Image
+	img.AppendLayer(layer)
+	err = PushImage(context.Background(), img, strings.TrimPrefix(server.URL, "http://")+"/test/image:latest")
+	server.Close()
+	if err != nil {
+		t.Fatalf("failed to push image: %v", err)
+	}
+
+	// Reserve an address for the registry that nothing listens on yet.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve address: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	reference := addr + "/test/image:latest"
+
+	if _, err := LoadWithOptions(context.Background(), reference, LoadOptions{DialRetryWait: -1}); err == nil {
+		t.Fatal("loaded image from a registry that is not listening")
+	}
+
+	started := make(chan net.Listener, 1)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			started <- nil
+			return
+		}
+		started <- ln
+		http.Serve(ln, reg)
+	}()
+
+	index, err := Load(context.Background(), reference)
+	ln = <-started
+	if ln == nil {
+		t.Skip("could not start registry at reserved address")
+	}
+	defer ln.Close()
+	if err != nil {
+		t.Fatalf("failed to load image after registry started: %v", err)
+	}
+	if len(index) != 1 || index[0].Digest == "" {
+		t.Errorf("loaded index %v, want a single image", index)
+	}
+}
+
+func TestLoadInvalidDigestReferences(t *testing.T) {
+	// Ensure that malformed digest references fail before any request reaches a
+	// registry, which would not exist at these hosts.
+	full := digest.FromString("zeroimage").String()
+	testCases := []struct {
+		Reference string
+		Err       error
+	}{
+		{Reference: full},
+		{Reference: "registry.invalid/test@" + full[:19], Err: digest.ErrDigestInvalidLength},
+		{Reference: "registry.invalid/test@sha256:" + strings.Repeat("z", 64), Err: digest.ErrDigestInvalidFormat},
+		{Reference: "registry.invalid/test@md5:d41d8cd98f00b204e9800998ecf8427e", Err: digest.ErrDigestUnsupported},
+	}
+
+	for _, tc := range testCases {
+		_, err := Load(context.Background(), tc.Reference)
+		if err == nil {
+			t.Errorf("loaded %q without error", tc.Reference)
+			continue
+		}
+		if tc.Err != nil && !errors.Is(err, tc.Err) {
+			t.Errorf("loading %q: got %v, want %v", tc.Reference, err, tc.Err)
+		}
+	}
+}
+
+func TestLoadIndexWithoutPlatforms(t *testing.T) {
+	// Ensure that the platforms of manifests in an index without platforms in
+	// their descriptors are determined from their configurations, in index
+	// order, regardless of how many are determined at once.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	reg := registrytest.New()
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	want := []string{"linux/amd64", "linux/arm64/v8", "linux/riscv64", "windows/amd64"}
+	var index specsv1.Index
+	index.SchemaVersion = 2
+	index.MediaType = specsv1.MediaTypeImageIndex
+	for i, platform := range want {
+		var img image.Image
+		img.SetPlatform(platforms.MustParse(platform))
+		reference := fmt.Sprintf("%s/test/image:platform-%d", host, i)
+		if err := PushImage(context.Background(), img, reference); err != nil {
+			t.Fatalf("failed to push %s image: %v", platform, err)
+		}
+		m, ok := reg.Manifest("test/image", fmt.Sprintf("platform-%d", i))
+		if !ok {
+			t.Fatalf("registry is missing %s image", platform)
+		}
+		index.Manifests = append(index.Manifests, specsv1.Descriptor{
+			MediaType: m.MediaType,
+			Digest:    digest.FromBytes(m.Content),
+			Size:      int64(len(m.Content)),
+		})
+	}
+	indexJSON, err := json.Marshal(index)
+	if err != nil {
+		t.Fatalf("failed to encode index: %v", err)
+	}
+	reg.PutManifest("test/image", "latest", registrytest.Manifest{
+		MediaType: specsv1.MediaTypeImageIndex,
+		Content:   indexJSON,
+	})
+
+	for _, concurrency := range []int{1, 3} {
+		loaded, err := LoadWithOptions(context.Background(), host+"/test/image:latest", LoadOptions{Concurrency: concurrency})
+		if err != nil {
+			t.Fatalf("concurrency %d: failed to load image index: %v", concurrency, err)
+		}
+		var got []string
+		for _, entry := range loaded {
+			got = append(got, platforms.Format(entry.Platform))
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("concurrency %d: unexpected platforms (-want +got):\n%s", concurrency, diff)
+		}
+	}
+}
+
+func TestCheckPullAuthPlatforms(t *testing.T) {
+	// Ensure that CheckPullAuth reports the platforms of any image index, even
+	// one with a single entry, and reports none for a single manifest.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	reg := registrytest.New()
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	var img image.Image
+	img.SetPlatform(platforms.MustParse("linux/amd64"))
+	if err := PushImage(context.Background(), img, host+"/test/image:manifest"); err != nil {
+		t.Fatalf("failed to push image: %v", err)
+	}
+	m, ok := reg.Manifest("test/image", "manifest")
+	if !ok {
+		t.Fatal("registry is missing pushed image")
+	}
+	indexJSON, err := json.Marshal(specsv1.Index{
+		MediaType: specsv1.MediaTypeImageIndex,
+		Manifests: []specsv1.Descriptor{{
+			MediaType: m.MediaType,
+			Digest:    digest.FromBytes(m.Content),
+			Size:      int64(len(m.Content)),
+			Platform:  &specsv1.Platform{OS: "linux", Architecture: "amd64"},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("failed to encode index: %v", err)
+	}
+	reg.PutManifest("test/image", "index", registrytest.Manifest{
+		MediaType: specsv1.MediaTypeImageIndex,
+		Content:   indexJSON,
+	})
+
+	testCases := []struct {
+		Tag  string
+		Want int
+	}{
+		{"manifest", 0},
+		{"index", 1},
+	}
+	for _, tc := range testCases {
+		got, err := CheckPullAuth(context.Background(), host+"/test/image:"+tc.Tag, LoadOptions{})
+		if err != nil {
+			t.Fatalf("%s: pull check failed: %v", tc.Tag, err)
+		}
+		if got != tc.Want {
+			t.Errorf("%s: got %d platforms, want %d", tc.Tag, got, tc.Want)
+		}
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
@@ -22,36 +24,311 @@ import (
 
 const concurrentLayerUploads = 3
 
+// DefaultMaxRetries is the number of times that a pusher will retry each
+// upload to the registry after a failure that a retry may fix, unless
+// PushOptions.MaxRetries selects a different number.
+//
+// Some registries hand out tokens that expire partway through a push, for
+// example by scoping a token to the request that initiates a blob upload. The
+// authenticating transport refreshes its token and retries the request when
+// this happens, but it cannot replay a request body that it has already
+// consumed. To handle this, we retry the whole upload from the beginning with a
+// fresh body, which picks up the transport's refreshed token.
+const DefaultMaxRetries = 1
+
+// manifestBlobAttempts is the maximum number of times that a pusher will
+// attempt to upload a manifest that the registry rejects for referencing blobs
+// it does not have.
+//
+// Some distributed registries acknowledge a blob upload before the blob is
+// visible to the rest of the registry, so a manifest uploaded immediately
+// afterward can briefly appear to reference missing blobs. Between attempts, the
+// pusher waits for manifestBlobRetryDelay and checks that the registry reports
+// every blob before uploading the manifest again.
+const manifestBlobAttempts = 5
+
+// manifestBlobRetryDelay is the time that a pusher waits between attempts to
+// upload a manifest that references blobs the registry does not yet have.
+var manifestBlobRetryDelay = time.Second
+
+// ManifestFormat selects the format of the manifest that PushImageWithOptions
+// writes to the registry.
+type ManifestFormat string
+
+const (
+	// OCIManifest selects the OCI image manifest format.
+	OCIManifest ManifestFormat = "oci"
+	// DockerManifest selects the Docker v2 schema 2 manifest format, for
+	// registries and tools that do not support OCI media types. Docker manifests
+	// cannot carry annotations, so image annotations are not pushed in this
+	// format.
+	DockerManifest ManifestFormat = "docker"
+)
+
+// ParseManifestFormat returns the ManifestFormat named by s, or an error if s
+// does not name a supported format.
+func ParseManifestFormat(s string) (ManifestFormat, error) {
+	switch f := ManifestFormat(s); f {
+	case OCIManifest, DockerManifest:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unsupported manifest format %q", s)
+	}
+}
+
+// PushOptions customizes the behavior of PushImageWithOptions.
+type PushOptions struct {
+	// DigestAlgorithm is the algorithm used to compute the digest of the image
+	// configuration blob. The zero value selects digest.Canonical.
+	DigestAlgorithm digest.Algorithm
+	// UserAgent is the value of the User-Agent header sent with each request.
+	// The zero value selects the UserAgent of the Client, or DefaultUserAgent.
+	UserAgent string
+	// ManifestFormat is the format of the pushed manifest. The zero value
+	// selects OCIManifest.
+	ManifestFormat ManifestFormat
+	// ConfigMediaType is the media type of the image's configuration blob, which
+	// artifacts that are not runnable images may customize. The zero value
+	// selects the OCI image configuration media type. Docker manifests always
+	// use the Docker configuration media type, so ConfigMediaType cannot be
+	// combined with DockerManifest.
+	ConfigMediaType string
+	// MaxRetries is the maximum number of times that each upload is retried
+	// after a failure that a retry may fix. The zero value selects
+	// DefaultMaxRetries, and a negative value disables retries.
+	MaxRetries int
+	// AlwaysUpload disables the HEAD request that checks whether the repository
+	// already has each blob, and uploads every blob unconditionally. On
+	// registries with high request latency, this can be faster than checking,
+	// especially for small blobs.
+	AlwaysUpload bool
+	// BlobPushed, if set, is called with the outcome of each attempt to push a
+	// blob to a repository, including blobs that the repository already had.
+	// It may be called concurrently from multiple goroutines.
+	BlobPushed func(BlobResult)
+	// ManifestPushed, if set, is called with the outcome of each attempt to push
+	// the image manifest to a tag, including tags that already referenced an
+	// identical manifest.
+	ManifestPushed func(ManifestResult)
+}
+
+// BlobResult describes the outcome of pushing a single blob to a repository.
+type BlobResult struct {
+	// Repository is the name of the repository that the blob was pushed to.
+	Repository string
+	// Descriptor describes the blob.
+	Descriptor specsv1.Descriptor
+	// Skipped is true if the repository already had the blob, so it was not
+	// uploaded.
+	Skipped bool
+	// Retries is the number of times that the upload was retried.
+	Retries int
+	// Err is the error that caused the push to fail, if it failed.
+	Err error
+}
+
+// ManifestResult describes the outcome of pushing the image manifest to a
+// single tag.
+type ManifestResult struct {
+	// Tag is the full reference to the tag that the manifest was pushed to.
+	Tag string
+	// Descriptor describes the manifest.
+	Descriptor specsv1.Descriptor
+	// Skipped is true if the registry already served an identical manifest for
+	// the tag, so it was not uploaded.
+	Skipped bool
+	// Err is the error that caused the push to fail, if it failed.
+	Err error
+}
+
 // PushImage pushes a single container image to a remote OCI registry, using
 // credentials from the local Docker keychain to authenticate to the registry if
-// necessary.
+// necessary, and using the default PushOptions.
 func PushImage(ctx context.Context, img image.Image, reference string) error {
-	tag, err := name.NewTag(reference)
-	if err != nil {
+	return PushImageWithOptions(ctx, img, reference, PushOptions{})
+}
+
+// PushImageWithOptions pushes a single container image to a remote OCI
+// registry like PushImage, as customized by opts.
+func PushImageWithOptions(ctx context.Context, img image.Image, reference string, opts PushOptions) error {
+	return PushImageToTags(ctx, img, []string{reference}, opts)
+}
+
+// PushImageToTags pushes a single container image to each of the tags in
+// references, as customized by opts. The image's blobs are uploaded once to
+// each distinct repository, and each tag is then set with its own manifest
+// upload. PushImageToTags checks img with image.Image.Validate before pushing
+// anything.
+//
+// Layer blobs are uploaded byte for byte as img's layers provide them, and are
+// never decompressed or recompressed, so each layer keeps its digest in every
+// registry it is pushed to. An upload fails without completing if a blob's
+// content does not match the digest and size of its descriptor.
+func PushImageToTags(ctx context.Context, img image.Image, references []string, opts PushOptions) error {
+	return (&Client{}).PushImageToTags(ctx, img, references, opts)
+}
+
+// PushImageToTags pushes an image like the package-level PushImageToTags, using
+// the connection settings of c.
+func (c *Client) PushImageToTags(ctx context.Context, img image.Image, references []string, opts PushOptions) error {
+	if err := img.Validate(); err != nil {
 		return err
 	}
 
-	transport, err := newTransport(ctx, tag, transport.PushScope)
+	pushers, err := c.newPushers(ctx, references, opts)
 	if err != nil {
 		return err
 	}
+	for _, p := range pushers {
+		if err := p.PushImage(ctx, img); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MediaTypeEmptyJSON is the media type of the empty JSON object, which OCI
+// artifacts without a configuration of their own use as their config blob.
+const MediaTypeEmptyJSON = "application/vnd.oci.empty.v1+json"
+
+// Referrer represents an OCI artifact that refers to another manifest in the
+// same repository, such as a software bill of materials for an image.
+type Referrer struct {
+	// ArtifactType is the artifact type of the referrer's manifest.
+	ArtifactType string
+	// MediaType is the media type of Content.
+	MediaType string
+	// Content is the content of the artifact, which is pushed as the only layer
+	// of the referrer's manifest.
+	Content []byte
+	// Annotations are the annotations of the referrer's manifest.
+	Annotations map[string]string
+}
+
+// PushReferrer pushes ref to the repository of each of the tags in
+// references, as an artifact manifest whose subject is the manifest described
+// by subject. The artifact manifest is pushed by digest without changing the
+// tags, so that registries supporting the OCI referrers API list it among the
+// referrers of subject. PushReferrer returns the descriptor of the artifact
+// manifest.
+func (c *Client) PushReferrer(ctx context.Context, references []string, subject specsv1.Descriptor, ref Referrer, opts PushOptions) (specsv1.Descriptor, error) {
+	pushers, err := c.newPushers(ctx, references, opts)
+	if err != nil {
+		return specsv1.Descriptor{}, err
+	}
+	var desc specsv1.Descriptor
+	for _, p := range pushers {
+		desc, err = p.PushReferrer(ctx, subject, ref)
+		if err != nil {
+			return specsv1.Descriptor{}, err
+		}
+	}
+	return desc, nil
+}
 
-	p := pusher{
-		Tag: tag,
-		Client: http.Client{
-			Transport: transport,
-			Timeout:   httpTimeout,
-		},
+// ManifestDescriptor returns the manifest that PushImageWithOptions pushes for
+// img with opts, along with its descriptor, without contacting any registry.
+func ManifestDescriptor(img image.Image, opts PushOptions) (image.Manifest, specsv1.Descriptor, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return image.Manifest{}, specsv1.Descriptor{}, err
+	}
+	p := &pusher{Options: opts}
+	configDesc, _, err := p.encodeConfig(img.Config)
+	if err != nil {
+		return image.Manifest{}, specsv1.Descriptor{}, err
+	}
+	manifest, err := p.buildManifest(img, configDesc)
+	if err != nil {
+		return image.Manifest{}, specsv1.Descriptor{}, err
+	}
+	desc, _, err := encodeManifest(manifest)
+	return manifest, desc, err
+}
+
+// withDefaults returns a copy of opts with its zero values replaced by
+// defaults, or an error if opts cannot be satisfied.
+func (opts PushOptions) withDefaults() (PushOptions, error) {
+	if opts.DigestAlgorithm == "" {
+		opts.DigestAlgorithm = digest.Canonical
+	}
+	if opts.ManifestFormat == "" {
+		opts.ManifestFormat = OCIManifest
+	}
+	if opts.ConfigMediaType == "" {
+		opts.ConfigMediaType = specsv1.MediaTypeImageConfig
+	} else if opts.ManifestFormat == DockerManifest && opts.ConfigMediaType != specsv1.MediaTypeImageConfig {
+		return opts, fmt.Errorf("config media type %q cannot be pushed in a Docker manifest", opts.ConfigMediaType)
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = DefaultMaxRetries
 	}
-	return p.PushImage(ctx, img)
+	return opts, nil
 }
 
+// newPushers returns a pusher for each distinct repository among the tags in
+// references, which uses opts after replacing its zero values with defaults.
+func (c *Client) newPushers(ctx context.Context, references []string, opts PushOptions) ([]*pusher, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	// A registry on a Unix socket has the same name as any other, so the socket
+	// is part of what makes a repository distinct.
+	type repository struct{ socket, name string }
+	var repositories []repository
+	tagsByRepository := make(map[repository][]name.Tag)
+	for _, reference := range references {
+		socket, reference, err := splitUnixReference(reference)
+		if err != nil {
+			return nil, err
+		}
+		tag, err := name.NewTag(reference)
+		if err != nil {
+			return nil, err
+		}
+		repo := repository{socket, tag.Context().Name()}
+		if _, ok := tagsByRepository[repo]; !ok {
+			repositories = append(repositories, repo)
+		}
+		tagsByRepository[repo] = append(tagsByRepository[repo], tag)
+	}
+
+	pushers := make([]*pusher, len(repositories))
+	for i, repo := range repositories {
+		tags := tagsByRepository[repo]
+		transport, err := c.newTransport(ctx, tags[0], repo.socket, opts.UserAgent, 0, transport.PushScope)
+		if err != nil {
+			return nil, err
+		}
+
+		pushers[i] = &pusher{
+			Tags: tags,
+			Client: http.Client{
+				Transport: transport,
+				Timeout:   httpTimeout,
+			},
+			Options: opts,
+		}
+	}
+	return pushers, nil
+}
+
+// pusher pushes an image to one or more tags, all of which must be in the same
+// repository.
 type pusher struct {
-	Tag    name.Tag
-	Client http.Client
+	Tags    []name.Tag
+	Client  http.Client
+	Options PushOptions
 }
 
 func (p *pusher) PushImage(ctx context.Context, img image.Image) error {
+	// Find out whether we can represent the image before uploading anything.
+	if _, err := p.buildManifest(img, specsv1.Descriptor{}); err != nil {
+		return err
+	}
+
 	layersCh := make(chan image.Layer, len(img.Layers))
 	for _, layer := range img.Layers {
 		layersCh <- layer
@@ -83,38 +360,129 @@ func (p *pusher) PushImage(ctx context.Context, img image.Image) error {
 		return err
 	}
 
-	return p.uploadManifest(ctx, img, configDesc)
+	manifest, err := p.buildManifest(img, configDesc)
+	if err != nil {
+		return err
+	}
+	return p.uploadManifest(ctx, manifest)
+}
+
+// PushReferrer uploads ref's content and an empty configuration blob, then
+// uploads a manifest for ref by digest with subject as its subject.
+func (p *pusher) PushReferrer(ctx context.Context, subject specsv1.Descriptor, ref Referrer) (specsv1.Descriptor, error) {
+	emptyJSON := []byte("{}")
+	configDesc := specsv1.Descriptor{
+		MediaType: MediaTypeEmptyJSON,
+		Digest:    p.Options.DigestAlgorithm.FromBytes(emptyJSON),
+		Size:      int64(len(emptyJSON)),
+	}
+	contentDesc := specsv1.Descriptor{
+		MediaType: ref.MediaType,
+		Digest:    p.Options.DigestAlgorithm.FromBytes(ref.Content),
+		Size:      int64(len(ref.Content)),
+	}
+	blobs := []struct {
+		desc    specsv1.Descriptor
+		content []byte
+	}{{configDesc, emptyJSON}, {contentDesc, ref.Content}}
+	for _, blob := range blobs {
+		content := blob.content
+		err := p.pushBlob(ctx, blob.desc, func(_ context.Context) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(content)), nil
+		})
+		if err != nil {
+			return specsv1.Descriptor{}, err
+		}
+	}
+
+	manifest := image.Manifest{
+		Manifest: specsv1.Manifest{
+			Versioned:   specs.Versioned{SchemaVersion: 2},
+			MediaType:   specsv1.MediaTypeImageManifest,
+			Config:      configDesc,
+			Layers:      []specsv1.Descriptor{contentDesc},
+			Annotations: ref.Annotations,
+		},
+		ArtifactType: ref.ArtifactType,
+		Subject:      &subject,
+	}
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return specsv1.Descriptor{}, err
+	}
+
+	desc := specsv1.Descriptor{
+		MediaType: manifest.MediaType,
+		Digest:    digest.FromBytes(manifestJSON),
+		Size:      int64(len(manifestJSON)),
+	}
+	blobDigests := []digest.Digest{configDesc.Digest, contentDesc.Digest}
+	err = p.putManifestWithBlobs(ctx, desc.Digest.String(), desc.MediaType, manifestJSON, blobDigests)
+	if err != nil {
+		return specsv1.Descriptor{}, fmt.Errorf("pushing referrer to %s: %w", p.Tags[0].Context(), err)
+	}
+	return desc, nil
 }
 
 func (p *pusher) uploadConfig(ctx context.Context, config image.Config) (specsv1.Descriptor, error) {
-	configJSON, err := json.Marshal(config)
+	desc, configJSON, err := p.encodeConfig(config)
 	if err != nil {
 		return specsv1.Descriptor{}, err
 	}
+	return desc, p.pushBlob(ctx, desc, func(_ context.Context) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(configJSON)), nil
+	})
+}
 
+// encodeConfig returns the JSON encoding of config and its descriptor as the
+// pusher's options describe it.
+func (p *pusher) encodeConfig(config image.Config) (specsv1.Descriptor, []byte, error) {
+	configJSON, err := image.EncodeConfig(config)
+	if err != nil {
+		return specsv1.Descriptor{}, nil, err
+	}
 	desc := specsv1.Descriptor{
-		MediaType: specsv1.MediaTypeImageConfig,
-		Digest:    digest.FromBytes(configJSON),
+		MediaType: p.Options.ConfigMediaType,
+		Digest:    p.Options.DigestAlgorithm.FromBytes(configJSON),
 		Size:      int64(len(configJSON)),
 	}
-	if p.canSkipBlobUpload(ctx, desc.Digest) {
-		return desc, nil
-	}
-	return desc, p.uploadBlob(ctx, desc.Digest, desc.Size, bytes.NewReader(configJSON))
+	return desc, configJSON, nil
 }
 
 func (p *pusher) uploadLayer(ctx context.Context, layer image.Layer) error {
-	if p.canSkipBlobUpload(ctx, layer.Descriptor.Digest) {
-		return nil
+	return p.pushBlob(ctx, layer.Descriptor, layer.OpenBlob)
+}
+
+// pushBlob uploads the blob described by desc unless the repository already
+// has it, and reports the outcome to the BlobPushed option if it is set.
+func (p *pusher) pushBlob(ctx context.Context, desc specsv1.Descriptor, open func(context.Context) (io.ReadCloser, error)) (err error) {
+	result := BlobResult{
+		Repository: p.Tags[0].Context().Name(),
+		Descriptor: desc,
+	}
+	if p.Options.BlobPushed != nil {
+		defer func() {
+			result.Err = err
+			p.Options.BlobPushed(result)
+		}()
 	}
 
-	r, err := layer.OpenBlob(ctx)
-	if err != nil {
-		return err
+	if !p.Options.AlwaysUpload && p.canSkipBlobUpload(ctx, desc.Digest) {
+		result.Skipped = true
+		return nil
 	}
-	defer r.Close()
 
-	return p.uploadBlob(ctx, layer.Descriptor.Digest, layer.Descriptor.Size, r)
+	// Each attempt consumes the blob's reader, so each must open a new one.
+	result.Retries, err = p.retryUpload(ctx, func() error {
+		r, err := open(ctx)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		return p.uploadBlob(ctx, desc.Digest, desc.Size, newVerifyingReader(r, desc))
+	})
+	return err
 }
 
 func (p *pusher) uploadBlob(ctx context.Context, dgst digest.Digest, size int64, r io.Reader) error {
@@ -135,7 +503,7 @@ func (p *pusher) uploadBlob(ctx context.Context, dgst digest.Digest, size int64,
 		return err
 	}
 	req.Header.Set("Content-Type", "application/octet-stream")
-	req.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+	req.ContentLength = size
 
 	resp, err := p.Client.Do(req)
 	if err != nil {
@@ -146,6 +514,41 @@ func (p *pusher) uploadBlob(ctx context.Context, dgst digest.Digest, size int64,
 	return transport.CheckError(resp, http.StatusCreated)
 }
 
+// errBlobMismatch indicates that the content of a blob did not match the digest
+// or size of its descriptor.
+var errBlobMismatch = errors.New("blob content does not match its descriptor")
+
+// verifyingReader reads the content of a blob for upload, and fails the read
+// that reaches the end of the content if it does not match the blob's
+// descriptor. The failed read aborts the upload request, so content that would
+// change the blob's digest, like a layer recompressed after its descriptor was
+// computed, fails the push even with a registry that does not check uploads.
+type verifyingReader struct {
+	r        io.Reader
+	desc     specsv1.Descriptor
+	verifier digest.Verifier
+	n        int64
+}
+
+func newVerifyingReader(r io.Reader, desc specsv1.Descriptor) io.Reader {
+	// Descriptors with digests we cannot compute are left for the registry to
+	// check.
+	if desc.Digest.Validate() != nil {
+		return r
+	}
+	return &verifyingReader{r: r, desc: desc, verifier: desc.Digest.Verifier()}
+}
+
+func (vr *verifyingReader) Read(p []byte) (int, error) {
+	n, err := vr.r.Read(p)
+	vr.n += int64(n)
+	vr.verifier.Write(p[:n])
+	if err == io.EOF && (vr.n != vr.desc.Size || !vr.verifier.Verified()) {
+		return n, fmt.Errorf("%w: %s", errBlobMismatch, vr.desc.Digest)
+	}
+	return n, err
+}
+
 func (p *pusher) canSkipBlobUpload(ctx context.Context, dgst digest.Digest) (ok bool) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodHead, p.url("/blobs/%s", dgst).String(), nil)
 	if err != nil {
@@ -181,28 +584,139 @@ func (p *pusher) getBlobUploadURL(ctx context.Context) (u *url.URL, err error) {
 	return uploadURL.Parse(resp.Header.Get("Location"))
 }
 
-func (p *pusher) uploadManifest(ctx context.Context, img image.Image, configDesc specsv1.Descriptor) error {
-	manifest := specsv1.Manifest{
-		Versioned:   specs.Versioned{SchemaVersion: 2},
-		MediaType:   specsv1.MediaTypeImageManifest,
-		Config:      configDesc,
-		Annotations: img.Annotations,
-	}
-	for _, layer := range img.Layers {
-		manifest.Layers = append(manifest.Layers, layer.Descriptor)
+// buildManifest returns the manifest for img in the format selected by the
+// pusher's options.
+func (p *pusher) buildManifest(img image.Image, configDesc specsv1.Descriptor) (image.Manifest, error) {
+	manifest := img.Manifest(configDesc)
+	if p.Options.ManifestFormat == DockerManifest {
+		return image.ToDockerManifest(manifest)
 	}
+	return manifest, nil
+}
 
+// encodeManifest returns the JSON encoding of manifest and its descriptor.
+// Registries address manifests by their canonical digest, whatever the
+// algorithm of the blobs they refer to.
+func encodeManifest(manifest image.Manifest) (specsv1.Descriptor, []byte, error) {
 	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return specsv1.Descriptor{}, nil, err
+	}
+	desc := specsv1.Descriptor{
+		MediaType: manifest.MediaType,
+		Digest:    digest.FromBytes(manifestJSON),
+		Size:      int64(len(manifestJSON)),
+	}
+	return desc, manifestJSON, nil
+}
+
+func (p *pusher) uploadManifest(ctx context.Context, manifest image.Manifest) error {
+	desc, manifestJSON, err := encodeManifest(manifest)
 	if err != nil {
 		return err
 	}
 
-	uploadURL := p.url("/manifests/%s", p.Tag.TagStr())
+	blobs := []digest.Digest{manifest.Config.Digest}
+	for _, layer := range manifest.Layers {
+		blobs = append(blobs, layer.Digest)
+	}
+
+	for _, tag := range p.Tags {
+		err := p.pushManifest(ctx, tag, desc, manifestJSON, blobs)
+		if err != nil {
+			return fmt.Errorf("pushing %s: %w", tag, err)
+		}
+	}
+	return nil
+}
+
+// pushManifest uploads a manifest to tag unless the registry already serves
+// identical content for it, and reports the outcome to the ManifestPushed
+// option if it is set.
+func (p *pusher) pushManifest(ctx context.Context, tag name.Tag, desc specsv1.Descriptor, manifestJSON []byte, blobs []digest.Digest) (err error) {
+	result := ManifestResult{Tag: tag.String(), Descriptor: desc}
+	if p.Options.ManifestPushed != nil {
+		defer func() {
+			result.Err = err
+			p.Options.ManifestPushed(result)
+		}()
+	}
+
+	if p.hasManifest(ctx, tag, desc.MediaType, manifestJSON) {
+		result.Skipped = true
+		return nil
+	}
+	return p.putManifestWithBlobs(ctx, tag.TagStr(), desc.MediaType, manifestJSON, blobs)
+}
+
+// hasManifest returns true if the registry reports a digest for the manifest
+// at tag that matches manifestJSON. Registries that omit the digest from their
+// response are assumed not to have the manifest.
+func (p *pusher) hasManifest(ctx context.Context, tag name.Tag, mediaType string, manifestJSON []byte) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, p.url("/manifests/%s", tag.TagStr()).String(), nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Accept", mediaType)
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+
+	reported, ok := reportedContentDigest(resp)
+	return ok && reported.Algorithm().Available() && reported.Algorithm().FromBytes(manifestJSON) == reported
+}
+
+// putManifestWithBlobs uploads a manifest that references blobs to identifier,
+// which may be a tag or a digest, retrying as described by
+// manifestBlobAttempts if the registry reports that any of the blobs are
+// missing.
+func (p *pusher) putManifestWithBlobs(ctx context.Context, identifier string, mediaType string, manifestJSON []byte, blobs []digest.Digest) error {
+	var err error
+	for i := 0; i < manifestBlobAttempts; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(manifestBlobRetryDelay):
+			}
+			if !p.hasBlobs(ctx, blobs) {
+				continue
+			}
+		}
+
+		_, err = p.retryUpload(ctx, func() error {
+			return p.putManifest(ctx, identifier, mediaType, manifestJSON)
+		})
+		if !isMissingBlobError(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// hasBlobs returns true if the registry reports that it has all of blobs.
+func (p *pusher) hasBlobs(ctx context.Context, blobs []digest.Digest) bool {
+	for _, dgst := range blobs {
+		if !p.canSkipBlobUpload(ctx, dgst) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *pusher) putManifest(ctx context.Context, identifier string, mediaType string, manifestJSON []byte) error {
+	uploadURL := p.url("/manifests/%s", identifier)
 	req, err := http.NewRequestWithContext(ctx, http.MethodPut, uploadURL.String(), bytes.NewReader(manifestJSON))
 	if err != nil {
 		return err
 	}
-	req.Header.Add("Content-Type", specsv1.MediaTypeImageManifest)
+	req.Header.Add("Content-Type", mediaType)
 	req.Header.Add("Content-Length", strconv.Itoa(len(manifestJSON)))
 
 	resp, err := p.Client.Do(req)
@@ -211,13 +725,69 @@ func (p *pusher) uploadManifest(ctx context.Context, img image.Image, configDesc
 	}
 	defer resp.Body.Close()
 
-	return transport.CheckError(resp, http.StatusCreated)
+	if err := transport.CheckError(resp, http.StatusCreated); err != nil {
+		return err
+	}
+
+	// Compare against the registry's digest using its own choice of algorithm,
+	// which may not match the one we used elsewhere in the image.
+	if reported, ok := reportedContentDigest(resp); ok {
+		if local := reported.Algorithm().FromBytes(manifestJSON); local != reported {
+			return fmt.Errorf("registry reported digest %s for manifest, expected %s", reported, local)
+		}
+	}
+	return nil
+}
+
+// retryUpload calls upload until it succeeds, returns an error that a retry
+// would not fix, or has been retried the number of times selected by the
+// pusher's options. It returns the number of retries that it made.
+func (p *pusher) retryUpload(ctx context.Context, upload func() error) (retries int, err error) {
+	for {
+		err = upload()
+		if err == nil || ctx.Err() != nil || !isRetryableUploadError(err) || retries >= p.Options.MaxRetries {
+			return retries, err
+		}
+		retries++
+	}
+}
+
+// isRetryableUploadError returns true if err represents an authentication
+// failure reported by the registry, or a failure to complete the HTTP request
+// at all. The latter is what we see when the authenticating transport refreshes
+// an expired token and fails to replay a consumed request body. A blob that
+// does not match its descriptor will not match on a retry either.
+func isRetryableUploadError(err error) bool {
+	if errors.Is(err, errBlobMismatch) {
+		return false
+	}
+	var terr *transport.Error
+	if errors.As(err, &terr) {
+		return terr.StatusCode == http.StatusUnauthorized
+	}
+	var uerr *url.Error
+	return errors.As(err, &uerr)
+}
+
+// isMissingBlobError returns true if err represents the registry's rejection
+// of a manifest that references blobs it does not have.
+func isMissingBlobError(err error) bool {
+	var terr *transport.Error
+	if !errors.As(err, &terr) {
+		return false
+	}
+	for _, diag := range terr.Errors {
+		if diag.Code == transport.ManifestBlobUnknownErrorCode || diag.Code == transport.BlobUnknownErrorCode {
+			return true
+		}
+	}
+	return false
 }
 
 func (p *pusher) url(format string, v ...interface{}) *url.URL {
 	return &url.URL{
-		Scheme: p.Tag.Scheme(),
-		Host:   p.Tag.RegistryStr(),
-		Path:   "/v2/" + p.Tag.RepositoryStr() + fmt.Sprintf(format, v...),
+		Scheme: p.Tags[0].Scheme(),
+		Host:   p.Tags[0].RegistryStr(),
+		Path:   "/v2/" + p.Tags[0].RepositoryStr() + fmt.Sprintf(format, v...),
 	}
 }
@@ -0,0 +1,797 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	// Required by github.com/opencontainers/go-digest
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"go.alexhamlin.co/zeroimage/internal/image"
+	"go.alexhamlin.co/zeroimage/internal/registry/registrytest"
+	"go.alexhamlin.co/zeroimage/internal/tarlayer"
+)
+
+func TestPushWithExpiringToken(t *testing.T) {
+	// Ensure that a push survives a registry that expires its token partway
+	// through a blob upload, after the upload has been initiated but before its
+	// content has been sent.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	reg := newExpiringTokenRegistry()
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	reg.Realm = server.URL + "/token"
+
+	builder := tarlayer.NewBuilder()
+	builder.AddContent("hello.txt", []byte("hello world"))
+	layer, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build test layer: %v", err)
+	}
+	var img image.Image
+	img.AppendLayer(layer)
+
+	reference := strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+	err = PushImage(context.Background(), img, reference)
+	if err != nil {
+		t.Fatalf("failed to push image: %v", err)
+	}
+
+	if !reg.HasBlob(layer.Descriptor.Digest) {
+		t.Errorf("registry is missing layer blob %s", layer.Descriptor.Digest)
+	}
+	if !reg.HasManifest("latest") {
+		t.Errorf("registry is missing manifest for tag")
+	}
+	if reg.TokensIssued < 2 {
+		t.Errorf("registry issued %d token(s), want at least 2", reg.TokensIssued)
+	}
+	if len(reg.UserAgents) != 1 || !reg.UserAgents[DefaultUserAgent] {
+		t.Errorf("registry saw User-Agent values %v, want only %q", reg.UserAgents, DefaultUserAgent)
+	}
+}
+
+func TestPushRetryReopensBlob(t *testing.T) {
+	// Ensure that a retried layer upload reads the blob from a new reader,
+	// rather than reusing the one consumed by the failed attempt.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	reg := newExpiringTokenRegistry()
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	reg.Realm = server.URL + "/token"
+
+	builder := tarlayer.NewBuilder()
+	builder.AddContent("hello.txt", []byte("hello world"))
+	layer, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build test layer: %v", err)
+	}
+	blob, err := layer.OpenBlob(context.Background())
+	if err != nil {
+		t.Fatalf("failed to open test layer: %v", err)
+	}
+	content, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatalf("failed to read test layer: %v", err)
+	}
+
+	var opens int32
+	layer.OpenBlob = func(_ context.Context) (io.ReadCloser, error) {
+		atomic.AddInt32(&opens, 1)
+		return io.NopCloser(&readOnceReader{r: bytes.NewReader(content)}), nil
+	}
+	var img image.Image
+	img.AppendLayer(layer)
+
+	reference := strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+	if err := PushImage(context.Background(), img, reference); err != nil {
+		t.Fatalf("failed to push image: %v", err)
+	}
+
+	if !reg.HasBlob(layer.Descriptor.Digest) {
+		t.Errorf("registry is missing layer blob %s", layer.Descriptor.Digest)
+	}
+	if opens < 2 {
+		t.Errorf("opened layer blob %d time(s), want at least 2", opens)
+	}
+}
+
+// readOnceReader fails any read after its underlying reader reaches EOF.
+type readOnceReader struct {
+	r    io.Reader
+	done bool
+}
+
+func (r *readOnceReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, errors.New("reader already consumed")
+	}
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		r.done = true
+	}
+	return n, err
+}
+
+func TestPushBlobResults(t *testing.T) {
+	// Ensure that the outcome of each blob upload is reported, including the
+	// retry forced by an expiring token, and that disabling retries makes the
+	// same push fail.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	builder := tarlayer.NewBuilder()
+	builder.AddContent("hello.txt", []byte("hello world"))
+	layer, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build test layer: %v", err)
+	}
+	var img image.Image
+	img.AppendLayer(layer)
+
+	push := func(maxRetries int) ([]BlobResult, error) {
+		reg := newExpiringTokenRegistry()
+		server := httptest.NewServer(reg)
+		defer server.Close()
+		reg.Realm = server.URL + "/token"
+
+		var (
+			mu      sync.Mutex
+			results []BlobResult
+		)
+		reference := strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+		err := PushImageWithOptions(context.Background(), img, reference, PushOptions{
+			MaxRetries: maxRetries,
+			BlobPushed: func(result BlobResult) {
+				mu.Lock()
+				defer mu.Unlock()
+				results = append(results, result)
+			},
+		})
+		return results, err
+	}
+
+	results, err := push(0)
+	if err != nil {
+		t.Fatalf("failed to push image: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d blob results, want 2", len(results))
+	}
+	retries := 0
+	for _, result := range results {
+		if result.Err != nil || result.Skipped {
+			t.Errorf("unexpected result for %s: %+v", result.Descriptor.Digest, result)
+		}
+		retries += result.Retries
+	}
+	// The config and layer upload concurrently, so either or both may start
+	// before the token expires.
+	if retries < 1 {
+		t.Errorf("got %d total retries, want at least 1", retries)
+	}
+
+	results, err = push(-1)
+	if err == nil {
+		t.Fatalf("pushed image without retries despite expiring token")
+	}
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	if failed == 0 {
+		t.Errorf("no blob results reported a failure")
+	}
+}
+
+func TestPushToMultipleTags(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	reg := newExpiringTokenRegistry()
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	reg.Realm = server.URL + "/token"
+
+	builder := tarlayer.NewBuilder()
+	builder.AddContent("hello.txt", []byte("hello world"))
+	layer, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build test layer: %v", err)
+	}
+	var img image.Image
+	img.AppendLayer(layer)
+
+	repository := strings.TrimPrefix(server.URL, "http://") + "/test/image"
+	references := []string{repository + ":latest", repository + ":v1.2.3"}
+	err = PushImageToTags(context.Background(), img, references, PushOptions{})
+	if err != nil {
+		t.Fatalf("failed to push image: %v", err)
+	}
+
+	latest, ok := reg.Manifest("test/image", "latest")
+	if !ok {
+		t.Fatalf("registry is missing manifest for latest")
+	}
+	if tagged, _ := reg.Manifest("test/image", "v1.2.3"); string(tagged.Content) != string(latest.Content) {
+		t.Errorf("manifests for latest and v1.2.3 differ")
+	}
+}
+
+func TestPushWithBaseURL(t *testing.T) {
+	// Ensure that a Client with a BaseURL sends all registry requests to it,
+	// including those for the authentication handshake, while leaving the
+	// registry's own token service alone.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	reg := newExpiringTokenRegistry()
+	mux := http.NewServeMux()
+	mux.Handle("/mirror/", http.StripPrefix("/mirror", reg))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	reg.Realm = server.URL + "/mirror/token"
+
+	builder := tarlayer.NewBuilder()
+	builder.AddContent("hello.txt", []byte("hello world"))
+	layer, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build test layer: %v", err)
+	}
+	var img image.Image
+	img.AppendLayer(layer)
+
+	baseURL, err := url.Parse(server.URL + "/mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := Client{BaseURL: baseURL}
+	references := []string{"registry.example.com/test/image:latest"}
+	err = client.PushImageToTags(context.Background(), img, references, PushOptions{})
+	if err != nil {
+		t.Fatalf("failed to push image: %v", err)
+	}
+
+	if !reg.HasBlob(layer.Descriptor.Digest) {
+		t.Errorf("registry is missing layer blob %s", layer.Descriptor.Digest)
+	}
+	if !reg.HasManifest("latest") {
+		t.Errorf("registry is missing manifest for tag")
+	}
+}
+
+func TestPushAndLoadWithAPIPath(t *testing.T) {
+	// Ensure that a Client with an APIPath sends every request for the
+	// distribution API beneath that path instead of /v2.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	reg := registrytest.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.HasPrefix(req.URL.Path, "/v2-proxy/") {
+			t.Errorf("request to %s is not beneath the API path", req.URL.Path)
+			http.NotFound(w, req)
+			return
+		}
+		req.URL.Path = "/v2/" + strings.TrimPrefix(req.URL.Path, "/v2-proxy/")
+		reg.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	builder := tarlayer.NewBuilder()
+	builder.AddContent("hello.txt", []byte("hello world"))
+	layer, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build test layer: %v", err)
+	}
+	var img image.Image
+	img.AppendLayer(layer)
+
+	client := Client{APIPath: "/v2-proxy"}
+	reference := strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+	err = client.PushImageToTags(context.Background(), img, []string{reference}, PushOptions{})
+	if err != nil {
+		t.Fatalf("failed to push image: %v", err)
+	}
+	if _, ok := reg.Manifest("test/image", "latest"); !ok {
+		t.Fatalf("registry is missing manifest for tag")
+	}
+
+	index, err := client.LoadWithOptions(context.Background(), reference, LoadOptions{})
+	if err != nil {
+		t.Fatalf("failed to load image index: %v", err)
+	}
+	loaded, err := index[0].GetImage(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load image: %v", err)
+	}
+	if len(loaded.Layers) != 1 || loaded.Layers[0].Descriptor.Digest != layer.Descriptor.Digest {
+		t.Errorf("loaded layers %v, want only %s", loaded.Layers, layer.Descriptor.Digest)
+	}
+}
+
+func TestPushManifestAfterMissingBlobs(t *testing.T) {
+	// Ensure that a push survives a registry that briefly rejects the manifest
+	// for referencing blobs that it has not yet made visible.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	defer func(delay time.Duration) { manifestBlobRetryDelay = delay }(manifestBlobRetryDelay)
+	manifestBlobRetryDelay = time.Millisecond
+
+	reg := registrytest.New()
+	var rejections int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPut && strings.Contains(req.URL.Path, "/manifests/") && atomic.AddInt32(&rejections, 1) <= 2 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"errors":[{"code":"MANIFEST_BLOB_UNKNOWN","message":"blob unknown to registry"}]}`)
+			return
+		}
+		reg.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	builder := tarlayer.NewBuilder()
+	builder.AddContent("hello.txt", []byte("hello world"))
+	layer, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build test layer: %v", err)
+	}
+	var img image.Image
+	img.AppendLayer(layer)
+
+	reference := strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+	if err := PushImage(context.Background(), img, reference); err != nil {
+		t.Fatalf("failed to push image: %v", err)
+	}
+	if _, ok := reg.Manifest("test/image", "latest"); !ok {
+		t.Errorf("registry is missing manifest for tag")
+	}
+}
+
+func TestPushAlwaysUpload(t *testing.T) {
+	// Ensure that AlwaysUpload uploads blobs that the registry already has
+	// without checking for them first.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	reg := registrytest.New()
+	var blobHeads int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead && strings.Contains(req.URL.Path, "/blobs/") {
+			atomic.AddInt32(&blobHeads, 1)
+		}
+		reg.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	builder := tarlayer.NewBuilder()
+	builder.AddContent("hello.txt", []byte("hello world"))
+	layer, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build test layer: %v", err)
+	}
+	var img image.Image
+	img.AppendLayer(layer)
+	reg.PutBlob(mustReadBlob(t, layer))
+
+	var (
+		mu      sync.Mutex
+		results []BlobResult
+	)
+	reference := strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+	err = PushImageWithOptions(context.Background(), img, reference, PushOptions{
+		AlwaysUpload: true,
+		BlobPushed: func(result BlobResult) {
+			mu.Lock()
+			defer mu.Unlock()
+			results = append(results, result)
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to push image: %v", err)
+	}
+
+	if heads := atomic.LoadInt32(&blobHeads); heads != 0 {
+		t.Errorf("got %d blob HEAD requests, want 0", heads)
+	}
+	for _, result := range results {
+		if result.Skipped {
+			t.Errorf("skipped upload of %s", result.Descriptor.Digest)
+		}
+	}
+}
+
+func TestCopyPreservesLayerBlobs(t *testing.T) {
+	// Ensure that pushing an image loaded from one registry to another uploads
+	// each layer blob exactly as the first registry served it, so that layers
+	// keep their digests across registries.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	srcReg := registrytest.New()
+	srcServer := httptest.NewServer(srcReg)
+	defer srcServer.Close()
+	dstReg := registrytest.New()
+	dstServer := httptest.NewServer(dstReg)
+	defer dstServer.Close()
+
+	var img image.Image
+	for _, compression := range []tarlayer.Compression{tarlayer.Gzip, tarlayer.Uncompressed} {
+		builder := tarlayer.NewBuilderWithOptions(tarlayer.Options{Compression: compression})
+		builder.AddContent("hello.txt", []byte("hello "+string(compression)))
+		layer, err := builder.Finish()
+		if err != nil {
+			t.Fatalf("failed to build test layer: %v", err)
+		}
+		img.AppendLayer(layer)
+	}
+
+	srcReference := strings.TrimPrefix(srcServer.URL, "http://") + "/test/image:latest"
+	if err := PushImage(context.Background(), img, srcReference); err != nil {
+		t.Fatalf("failed to push source image: %v", err)
+	}
+	index, err := Load(context.Background(), srcReference)
+	if err != nil {
+		t.Fatalf("failed to load source image index: %v", err)
+	}
+	loaded, err := index[0].GetImage(context.Background())
+	if err != nil {
+		t.Fatalf("failed to load source image: %v", err)
+	}
+
+	dstReference := strings.TrimPrefix(dstServer.URL, "http://") + "/copy/image:latest"
+	if err := PushImage(context.Background(), loaded, dstReference); err != nil {
+		t.Fatalf("failed to copy image: %v", err)
+	}
+	for i, layer := range img.Layers {
+		if loaded.Layers[i].Descriptor.Digest != layer.Descriptor.Digest || loaded.Layers[i].Descriptor.MediaType != layer.Descriptor.MediaType {
+			t.Errorf("layer %d loaded as %v, want %v", i, loaded.Layers[i].Descriptor, layer.Descriptor)
+		}
+		copied, ok := dstReg.Blob(layer.Descriptor.Digest)
+		if !ok {
+			t.Errorf("destination registry is missing layer blob %s", layer.Descriptor.Digest)
+			continue
+		}
+		if !bytes.Equal(copied, mustReadBlob(t, layer)) {
+			t.Errorf("layer %d blob changed while copying", i)
+		}
+	}
+}
+
+func TestPushRejectsMismatchedBlob(t *testing.T) {
+	// Ensure that a layer whose blob does not match its descriptor, as if it
+	// were recompressed after the descriptor was computed, fails the push
+	// without leaving the blob in the registry.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	reg := registrytest.New()
+	server := httptest.NewServer(reg)
+	defer server.Close()
+
+	builder := tarlayer.NewBuilder()
+	builder.AddContent("hello.txt", []byte("hello world"))
+	layer, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build test layer: %v", err)
+	}
+	content := mustReadBlob(t, layer)
+	content[len(content)-1] ^= 0xff
+	layer.OpenBlob = func(context.Context) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(content)), nil
+	}
+	var img image.Image
+	img.AppendLayer(layer)
+
+	reference := strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+	err = PushImage(context.Background(), img, reference)
+	if !errors.Is(err, errBlobMismatch) {
+		t.Fatalf("push returned %v, want blob mismatch error", err)
+	}
+	if _, ok := reg.Blob(layer.Descriptor.Digest); ok {
+		t.Errorf("registry has mismatched layer blob %s", layer.Descriptor.Digest)
+	}
+	if _, ok := reg.Blob(digest.FromBytes(content)); ok {
+		t.Errorf("registry has recompressed layer blob")
+	}
+}
+
+// mustReadBlob returns the content of the blob for layer.
+func mustReadBlob(t *testing.T, layer image.Layer) []byte {
+	t.Helper()
+	blob, err := layer.OpenBlob(context.Background())
+	if err != nil {
+		t.Fatalf("failed to open layer blob: %v", err)
+	}
+	defer blob.Close()
+	content, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatalf("failed to read layer blob: %v", err)
+	}
+	return content
+}
+
+func TestPushSkipsExistingManifest(t *testing.T) {
+	// Ensure that pushing an identical image again skips the manifest upload,
+	// while pushing a changed image does not.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	reg := registrytest.New()
+	var manifestPuts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPut && strings.Contains(req.URL.Path, "/manifests/") {
+			atomic.AddInt32(&manifestPuts, 1)
+		}
+		reg.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	builder := tarlayer.NewBuilder()
+	builder.AddContent("hello.txt", []byte("hello world"))
+	layer, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build test layer: %v", err)
+	}
+	var img image.Image
+	img.AppendLayer(layer)
+
+	reference := strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+	push := func() ManifestResult {
+		var results []ManifestResult
+		err := PushImageWithOptions(context.Background(), img, reference, PushOptions{
+			ManifestPushed: func(result ManifestResult) { results = append(results, result) },
+		})
+		if err != nil {
+			t.Fatalf("failed to push image: %v", err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("got %d manifest results, want 1", len(results))
+		}
+		return results[0]
+	}
+
+	if result := push(); result.Skipped {
+		t.Errorf("skipped manifest on first push")
+	}
+	if result := push(); !result.Skipped {
+		t.Errorf("did not skip identical manifest on second push")
+	}
+	if puts := atomic.LoadInt32(&manifestPuts); puts != 1 {
+		t.Errorf("got %d manifest uploads, want 1", puts)
+	}
+
+	img.Annotations = map[string]string{"org.example.changed": "true"}
+	if result := push(); result.Skipped {
+		t.Errorf("skipped changed manifest")
+	}
+	if puts := atomic.LoadInt32(&manifestPuts); puts != 2 {
+		t.Errorf("got %d manifest uploads, want 2", puts)
+	}
+}
+
+func TestManifestDescriptor(t *testing.T) {
+	// Ensure that ManifestDescriptor describes the manifest that is pushed,
+	// which registries address by its canonical digest even when the config
+	// blob uses another algorithm.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	server := httptest.NewServer(registrytest.New())
+	defer server.Close()
+
+	builder := tarlayer.NewBuilder()
+	builder.AddContent("hello.txt", []byte("hello world"))
+	layer, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build test layer: %v", err)
+	}
+	var img image.Image
+	img.AppendLayer(layer)
+
+	reference := strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+	for _, format := range []ManifestFormat{OCIManifest, DockerManifest} {
+		opts := PushOptions{DigestAlgorithm: digest.SHA512, ManifestFormat: format}
+		_, want, err := ManifestDescriptor(img, opts)
+		if err != nil {
+			t.Fatalf("failed to describe %s manifest: %v", format, err)
+		}
+
+		var got specsv1.Descriptor
+		opts.ManifestPushed = func(result ManifestResult) { got = result.Descriptor }
+		if err := PushImageWithOptions(context.Background(), img, reference, opts); err != nil {
+			t.Fatalf("failed to push %s manifest: %v", format, err)
+		}
+		if got.Digest != want.Digest || got.Size != want.Size || got.MediaType != want.MediaType {
+			t.Errorf("described %s manifest %v, but pushed %v", format, want, got)
+		}
+		if alg := got.Digest.Algorithm(); alg != digest.Canonical {
+			t.Errorf("pushed %s manifest with %s digest, want %s", format, alg, digest.Canonical)
+		}
+	}
+}
+
+func TestPushReferrer(t *testing.T) {
+	// Ensure that a referrer is pushed by digest with the pushed image as its
+	// subject, without changing the image's tag.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	reg := registrytest.New()
+	server := httptest.NewServer(reg)
+	defer server.Close()
+
+	builder := tarlayer.NewBuilder()
+	builder.AddContent("hello.txt", []byte("hello world"))
+	layer, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build test layer: %v", err)
+	}
+	var img image.Image
+	img.AppendLayer(layer)
+
+	reference := strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+	var subject specsv1.Descriptor
+	err = PushImageWithOptions(context.Background(), img, reference, PushOptions{
+		ManifestPushed: func(result ManifestResult) { subject = result.Descriptor },
+	})
+	if err != nil {
+		t.Fatalf("failed to push image: %v", err)
+	}
+
+	content := []byte(`{"spdxVersion":"SPDX-2.3"}`)
+	desc, err := (&Client{}).PushReferrer(context.Background(), []string{reference}, subject, Referrer{
+		ArtifactType: "application/spdx+json",
+		MediaType:    "application/spdx+json",
+		Content:      content,
+	}, PushOptions{})
+	if err != nil {
+		t.Fatalf("failed to push referrer: %v", err)
+	}
+
+	if _, ok := reg.Blob(digest.FromBytes(content)); !ok {
+		t.Errorf("registry is missing referrer content")
+	}
+	if latest, _ := reg.Manifest("test/image", "latest"); digest.FromBytes(latest.Content) != subject.Digest {
+		t.Errorf("pushing referrer changed the manifest for latest")
+	}
+	stored, ok := reg.Manifest("test/image", desc.Digest.String())
+	if !ok {
+		t.Fatalf("registry is missing referrer manifest %s", desc.Digest)
+	}
+	var manifest image.Manifest
+	if err := json.Unmarshal(stored.Content, &manifest); err != nil {
+		t.Fatalf("failed to decode referrer manifest: %v", err)
+	}
+	if manifest.Subject == nil || manifest.Subject.Digest != subject.Digest {
+		t.Errorf("referrer has subject %v, want %s", manifest.Subject, subject.Digest)
+	}
+	if manifest.ArtifactType != "application/spdx+json" {
+		t.Errorf("referrer has artifact type %q, want application/spdx+json", manifest.ArtifactType)
+	}
+	if manifest.Config.MediaType != MediaTypeEmptyJSON {
+		t.Errorf("referrer has config media type %q, want %q", manifest.Config.MediaType, MediaTypeEmptyJSON)
+	}
+}
+
+func TestPushAndLoadOverUnixSocket(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	socket := filepath.Join(t.TempDir(), "registry.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen on Unix socket: %v", err)
+	}
+	reg := registrytest.New()
+	server := httptest.NewUnstartedServer(reg)
+	server.Listener.Close()
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	builder := tarlayer.NewBuilder()
+	builder.AddContent("hello.txt", []byte("hello world"))
+	layer, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build test layer: %v", err)
+	}
+	var img image.Image
+	img.AppendLayer(layer)
+
+	reference := "unix://" + socket + "/test/image:latest"
+	if err := CheckPushAuth(context.Background(), reference); err != nil {
+		t.Fatalf("failed to check push access: %v", err)
+	}
+	if err := PushImage(context.Background(), img, reference); err != nil {
+		t.Fatalf("failed to push image: %v", err)
+	}
+	if _, ok := reg.Manifest("test/image", "latest"); !ok {
+		t.Fatalf("registry is missing manifest for latest")
+	}
+
+	index, err := Load(context.Background(), reference)
+	if err != nil {
+		t.Fatalf("failed to load image index: %v", err)
+	}
+	if len(index) != 1 {
+		t.Fatalf("loaded %d image(s), want 1", len(index))
+	}
+
+	if _, err := Load(context.Background(), "unix://"+filepath.Dir(socket)+"/missing.sock/test/image:latest"); err == nil {
+		t.Errorf("loaded image from missing socket without error")
+	}
+}
+
+// expiringTokenRegistry wraps a registrytest.Registry with bearer token
+// authentication, and invalidates all outstanding tokens immediately after the
+// first blob upload is initiated.
+type expiringTokenRegistry struct {
+	*registrytest.Registry
+	Realm string
+
+	mu           sync.Mutex
+	TokensIssued int
+	UserAgents   map[string]bool
+	validTokens  map[string]bool
+	expired      bool
+}
+
+func newExpiringTokenRegistry() *expiringTokenRegistry {
+	return &expiringTokenRegistry{
+		Registry:    registrytest.New(),
+		UserAgents:  make(map[string]bool),
+		validTokens: make(map[string]bool),
+	}
+}
+
+func (r *expiringTokenRegistry) HasBlob(dgst digest.Digest) bool {
+	_, ok := r.Blob(dgst)
+	return ok
+}
+
+func (r *expiringTokenRegistry) HasManifest(tag string) bool {
+	_, ok := r.Manifest("test/image", tag)
+	return ok
+}
+
+func (r *expiringTokenRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	r.UserAgents[req.Header.Get("User-Agent")] = true
+
+	if req.URL.Path == "/token" {
+		r.TokensIssued++
+		token := fmt.Sprintf("token-%d", r.TokensIssued)
+		r.validTokens[token] = true
+		fmt.Fprintf(w, `{"token": %q}`, token)
+		r.mu.Unlock()
+		return
+	}
+
+	if !r.validTokens[strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")] {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q,service="test"`, r.Realm))
+		w.WriteHeader(http.StatusUnauthorized)
+		r.mu.Unlock()
+		return
+	}
+
+	if req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/blobs/uploads/") && !r.expired {
+		r.expired = true
+		r.validTokens = make(map[string]bool)
+	}
+	r.mu.Unlock()
+
+	r.Registry.ServeHTTP(w, req)
+}
@@ -7,17 +7,181 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"runtime/debug"
+	"strings"
 	"time"
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/opencontainers/go-digest"
 )
 
 const httpTimeout = 10 * time.Second
 
-func newTransport(ctx context.Context, name name.Reference, scopes ...string) (http.RoundTripper, error) {
-	authenticator, err := authn.DefaultKeychain.Resolve(name.Context())
+// DefaultUserAgent is the value of the User-Agent header sent with registry
+// requests when no other value is provided. It identifies zeroimage along with
+// its module version, when that version is available.
+var DefaultUserAgent = "zeroimage"
+
+func init() {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		DefaultUserAgent += "/" + info.Main.Version
+	}
+}
+
+// Client customizes how the functions of this package connect to registries.
+// The zero value of Client connects to each registry at the address given by
+// the image reference, using http.DefaultTransport. The package-level
+// functions use the zero value.
+//
+// References of the form "unix:///path/to/registry.sock/REPOSITORY[:TAG]"
+// select a registry that listens on a Unix domain socket. Requests for such a
+// registry use plain HTTP over the socket, without the Transport of the
+// Client.
+type Client struct {
+	// BaseURL, if set, replaces the scheme and host of the registry for every
+	// request sent to it, and prefixes the path of every such request with its
+	// own path, unless the path of the request already has that prefix. For
+	// example, with a BaseURL of "http://127.0.0.1:5000/mirror", requests for
+	// "registry.example.com/app" are sent to
+	// "http://127.0.0.1:5000/mirror/v2/app/...". Requests to other hosts, such
+	// as token services, are not affected.
+	BaseURL *url.URL
+	// APIPath, if set, replaces the "/v2" path at which the registry is
+	// expected to serve the distribution API, for registries mounted beneath a
+	// path prefix by a reverse proxy. For example, with an APIPath of
+	// "/v2-proxy", requests for "registry.example.com/app" are sent to
+	// "https://registry.example.com/v2-proxy/app/...", while paths that already
+	// begin with APIPath, such as upload locations reported by the registry, are
+	// left alone. APIPath is applied before BaseURL, and does not affect
+	// requests to other hosts.
+	APIPath string
+	// Transport is used to send all requests, beneath the authentication
+	// performed by the Client. The zero value selects http.DefaultTransport.
+	Transport http.RoundTripper
+	// Keychain resolves the credentials used to authenticate to each registry.
+	// The zero value selects authn.DefaultKeychain.
+	Keychain authn.Keychain
+	// UserAgent is the value of the User-Agent header sent with each request,
+	// unless the options of an operation select a different value. The zero
+	// value selects DefaultUserAgent.
+	UserAgent string
+	// Debugf, if set, receives debugging messages, such as failures of
+	// best-effort requests that do not affect the result of an operation.
+	Debugf func(format string, v ...interface{})
+}
+
+func (c *Client) debugf(format string, v ...interface{}) {
+	if c.Debugf != nil {
+		c.Debugf(format, v...)
+	}
+}
+
+// baseURLTransport sends requests for a registry to a different API path or
+// base URL, as described by Client.
+type baseURLTransport struct {
+	inner    http.RoundTripper
+	registry string
+	apiPath  string
+	base     *url.URL
+}
+
+func (t baseURLTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.registry {
+		return t.inner.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	if apiPath := strings.TrimSuffix(t.apiPath, "/"); apiPath != "" && !strings.HasPrefix(req.URL.Path, apiPath+"/") {
+		if req.URL.Path == "/v2" || strings.HasPrefix(req.URL.Path, "/v2/") {
+			req.URL.Path = apiPath + strings.TrimPrefix(req.URL.Path, "/v2")
+			req.URL.RawPath = ""
+		}
+	}
+	if t.base != nil {
+		req.URL.Scheme = t.base.Scheme
+		req.URL.Host = t.base.Host
+		req.Host = t.base.Host
+		if prefix := strings.TrimSuffix(t.base.Path, "/"); !strings.HasPrefix(req.URL.Path, prefix+"/") {
+			req.URL.Path = prefix + req.URL.Path
+			req.URL.RawPath = ""
+		}
+	}
+	return t.inner.RoundTrip(req)
+}
+
+// userAgentTransport sets the User-Agent header on all requests that it sends.
+type userAgentTransport struct {
+	inner     http.RoundTripper
+	userAgent string
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.inner.RoundTrip(req)
+}
+
+// contentDigestHeader is the name of the response header that Docker-compatible
+// registries use to report the digest of a manifest.
+const contentDigestHeader = "Docker-Content-Digest"
+
+// reportedContentDigest returns the digest reported in the Docker-Content-Digest
+// header of resp, if the header is present and its value is a digest that we
+// can verify.
+func reportedContentDigest(resp *http.Response) (digest.Digest, bool) {
+	dgst, err := digest.Parse(resp.Header.Get(contentDigestHeader))
+	if err != nil {
+		return "", false
+	}
+	return dgst, true
+}
+
+// checkContentDigest returns an error if the registry reported a digest for
+// resp that conflicts with the expected digest. Registries are free to report a
+// digest computed with a different algorithm, in which case the check is
+// skipped, as the two cannot be compared without the content.
+func checkContentDigest(resp *http.Response, expected digest.Digest) error {
+	reported, ok := reportedContentDigest(resp)
+	if !ok || reported.Algorithm() != expected.Algorithm() || reported == expected {
+		return nil
+	}
+	return fmt.Errorf("registry reported digest %s for manifest, expected %s", reported, expected)
+}
+
+// newTransport returns an authenticating transport for requests to the
+// registry of name. If socket is not empty, requests for the registry are sent
+// over the Unix domain socket at that path, in place of c.Transport. If
+// dialRetryWait is positive, requests that fail to connect are retried for up
+// to that total time.
+func (c *Client) newTransport(ctx context.Context, name name.Reference, socket, userAgent string, dialRetryWait time.Duration, scopes ...string) (http.RoundTripper, error) {
+	if userAgent == "" {
+		userAgent = c.UserAgent
+	}
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+
+	inner := c.Transport
+	if inner == nil {
+		inner = http.DefaultTransport
+	}
+	if socket != "" {
+		inner = unixSocketTransport(socket)
+	}
+	if dialRetryWait > 0 {
+		inner = dialRetryTransport{inner, dialRetryWait, c.debugf}
+	}
+	if c.BaseURL != nil || c.APIPath != "" {
+		inner = baseURLTransport{inner, name.Context().RegistryStr(), c.APIPath, c.BaseURL}
+	}
+
+	keychain := c.Keychain
+	if keychain == nil {
+		keychain = authn.DefaultKeychain
+	}
+	authenticator, err := keychain.Resolve(name.Context())
 	if err != nil {
 		// TODO: Report that we hit this fallback?
 		authenticator = authn.Anonymous
@@ -32,7 +196,7 @@ func newTransport(ctx context.Context, name name.Reference, scopes ...string) (h
 		ctx,
 		name.Context().Registry,
 		authenticator,
-		http.DefaultTransport,
+		userAgentTransport{inner, userAgent},
 		imgScopes,
 	)
 }
@@ -41,12 +205,22 @@ func newTransport(ctx context.Context, name name.Reference, scopes ...string) (h
 // pushing blobs to a given repository. It returns a non-nil error if an upload
 // could not be initiated for any reason.
 func CheckPushAuth(ctx context.Context, reference string) error {
+	return (&Client{}).CheckPushAuth(ctx, reference)
+}
+
+// CheckPushAuth validates push access to a repository like the package-level
+// CheckPushAuth, using the connection settings of c.
+func (c *Client) CheckPushAuth(ctx context.Context, reference string) error {
+	socket, reference, err := splitUnixReference(reference)
+	if err != nil {
+		return err
+	}
 	name, err := name.ParseReference(reference)
 	if err != nil {
 		return err
 	}
 
-	tport, err := newTransport(ctx, name, transport.PushScope)
+	tport, err := c.newTransport(ctx, name, socket, "", 0, transport.PushScope)
 	if err != nil {
 		return err
 	}
@@ -60,6 +234,8 @@ func CheckPushAuth(ctx context.Context, reference string) error {
 	// the /v1/remote package itself since it pulls in a lot of dependencies that
 	// aren't relevant to us.
 
+	// Every request, including the cancellation below, must go through the
+	// authenticating transport so that it can answer the registry's challenges.
 	client := http.Client{
 		Transport: tport,
 		Timeout:   httpTimeout,
@@ -88,18 +264,27 @@ func CheckPushAuth(ctx context.Context, reference string) error {
 	// All of the following is a best-effort attempt to cancel the upload. This is
 	// technically not part of the OCI distribution spec, but it is an explicit
 	// part of the Docker registry API, so it's relevant for at least Docker Hub.
-	location, err := uploadURL.Parse(resp.Header.Get("Location"))
+	if err := cancelUpload(ctx, &client, &uploadURL, resp.Header.Get("Location")); err != nil {
+		c.debugf("Unable to cancel upload for push check of %s: %v", reference, err)
+	}
+	return nil
+}
+
+// cancelUpload deletes the upload at location, which may be relative to
+// uploadURL.
+func cancelUpload(ctx context.Context, client *http.Client, uploadURL *url.URL, location string) error {
+	locationURL, err := uploadURL.Parse(location)
 	if err != nil {
-		return nil
+		return err
 	}
-	req, err = http.NewRequest(http.MethodDelete, location.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, locationURL.String(), nil)
 	if err != nil {
-		return nil
+		return err
 	}
-	resp, err = client.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return nil
+		return err
 	}
-	resp.Body.Close()
-	return nil
+	defer resp.Body.Close()
+	return transport.CheckError(resp, http.StatusNoContent, http.StatusAccepted, http.StatusOK)
 }
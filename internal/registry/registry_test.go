@@ -0,0 +1,172 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+
+	"go.alexhamlin.co/zeroimage/internal/registry/registrytest"
+)
+
+func TestCheckPushAuthCancelsUpload(t *testing.T) {
+	// Ensure that the upload initiated by the check is cancelled with an
+	// authenticated request.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	reg := registrytest.New()
+	var (
+		mu      sync.Mutex
+		deletes []string
+	)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/token" {
+			fmt.Fprint(w, `{"token": "valid"}`)
+			return
+		}
+		if req.Header.Get("Authorization") != "Bearer valid" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q,service="test"`, server.URL+"/token"))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if req.Method == http.MethodDelete {
+			mu.Lock()
+			deletes = append(deletes, req.URL.Path)
+			mu.Unlock()
+		}
+		reg.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	reference := strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+	if err := CheckPushAuth(context.Background(), reference); err != nil {
+		t.Fatalf("failed to check push access: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(deletes) != 1 || !strings.HasPrefix(deletes[0], "/v2/test/image/blobs/uploads/") {
+		t.Errorf("got authenticated DELETE requests %q, want one for the upload", deletes)
+	}
+}
+
+func TestCheckPushAuthUserAgent(t *testing.T) {
+	// Ensure that every request of the check carries the User-Agent of the
+	// Client.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	reg := registrytest.New()
+	var (
+		mu         sync.Mutex
+		userAgents = make(map[string]bool)
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		userAgents[req.Header.Get("User-Agent")] = true
+		mu.Unlock()
+		reg.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	const userAgent = "example-ci/1.0"
+	client := Client{UserAgent: userAgent}
+	reference := strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+	if err := client.CheckPushAuth(context.Background(), reference); err != nil {
+		t.Fatalf("failed to check push access: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(userAgents) != 1 || !userAgents[userAgent] {
+		t.Errorf("registry saw User-Agent values %v, want only %q", userAgents, userAgent)
+	}
+}
+
+func TestCheckPushAuthReportsFailedCancel(t *testing.T) {
+	// Ensure that a failure to cancel the upload is reported for debugging,
+	// but does not fail the check.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	reg := registrytest.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		reg.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	var messages []string
+	client := Client{
+		Debugf: func(format string, v ...interface{}) {
+			messages = append(messages, fmt.Sprintf(format, v...))
+		},
+	}
+	reference := strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+	if err := client.CheckPushAuth(context.Background(), reference); err != nil {
+		t.Fatalf("failed to check push access: %v", err)
+	}
+	if len(messages) != 1 || !strings.Contains(messages[0], "cancel upload") {
+		t.Errorf("got debug messages %q, want one about the failed cancellation", messages)
+	}
+}
+
+func TestCheckPushAuthWithOIDCTokenFile(t *testing.T) {
+	// Ensure that the ID token is exchanged at the token service, with the
+	// requested service and scope, for the token that the registry accepts.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("id-token\n"), 0600); err != nil {
+		t.Fatalf("failed to write token file: %v", err)
+	}
+
+	reg := registrytest.New()
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/token" {
+			req.ParseForm()
+			if req.Method != http.MethodPost ||
+				req.PostForm.Get("grant_type") != "refresh_token" ||
+				req.PostForm.Get("refresh_token") != "id-token" ||
+				req.PostForm.Get("service") != "test" ||
+				!strings.HasPrefix(req.PostForm.Get("scope"), "repository:test/image:") {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"access_token": "exchanged"}`)
+			return
+		}
+		if req.Header.Get("Authorization") != "Bearer exchanged" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q,service="test"`, server.URL+"/token"))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		reg.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	client := Client{Keychain: staticKeychain{OIDCTokenFile(tokenFile)}}
+	reference := strings.TrimPrefix(server.URL, "http://") + "/test/image:latest"
+	if err := client.CheckPushAuth(context.Background(), reference); err != nil {
+		t.Fatalf("failed to check push access: %v", err)
+	}
+}
+
+// staticKeychain resolves the same credentials for every registry.
+type staticKeychain struct {
+	auth authn.Authenticator
+}
+
+func (k staticKeychain) Resolve(authn.Resource) (authn.Authenticator, error) {
+	return k.auth, nil
+}
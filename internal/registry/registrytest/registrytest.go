@@ -0,0 +1,274 @@
+// Package registrytest provides an in-memory implementation of the OCI
+// Distribution Specification for use in tests.
+package registrytest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// Registry is an http.Handler that implements the subset of the OCI
+// distribution API used to push and pull images, storing all content in
+// memory. It supports monolithic and chunked blob uploads, cross-repository
+// blob mounts, and manifest uploads by tag or digest. It does not require
+// authentication.
+//
+// For simplicity, Registry stores blobs without regard to the repository that
+// they were uploaded to, so any blob is available from every repository. The
+// zero value is not usable; use New to create a Registry.
+type Registry struct {
+	mu         sync.Mutex
+	blobs      map[digest.Digest][]byte
+	manifests  map[string]map[string]Manifest
+	uploads    map[string]*bytes.Buffer
+	nextUpload int
+}
+
+// Manifest represents a manifest stored in a Registry.
+type Manifest struct {
+	MediaType string
+	Content   []byte
+}
+
+// New returns an empty Registry.
+func New() *Registry {
+	return &Registry{
+		blobs:     make(map[digest.Digest][]byte),
+		manifests: make(map[string]map[string]Manifest),
+		uploads:   make(map[string]*bytes.Buffer),
+	}
+}
+
+// Blob returns the content of the blob with the provided digest, if the
+// registry has it.
+func (r *Registry) Blob(dgst digest.Digest) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	content, ok := r.blobs[dgst]
+	return content, ok
+}
+
+// PutBlob adds content to the registry as a blob, and returns its canonical
+// digest.
+func (r *Registry) PutBlob(content []byte) digest.Digest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dgst := digest.FromBytes(content)
+	r.blobs[dgst] = content
+	return dgst
+}
+
+// Manifest returns the manifest in repository with the provided reference,
+// which may be a tag or a digest, if the registry has it.
+func (r *Registry) Manifest(repository, reference string) (Manifest, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.manifests[repository][reference]
+	return m, ok
+}
+
+// PutManifest adds a manifest to repository under its canonical digest, along
+// with tag if it is not empty, and returns the digest.
+func (r *Registry) PutManifest(repository, tag string, m Manifest) digest.Digest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.putManifest(repository, tag, m)
+}
+
+func (r *Registry) putManifest(repository, reference string, m Manifest) digest.Digest {
+	if r.manifests[repository] == nil {
+		r.manifests[repository] = make(map[string]Manifest)
+	}
+	dgst := digest.FromBytes(m.Content)
+	r.manifests[repository][dgst.String()] = m
+	if reference != "" {
+		r.manifests[repository][reference] = m
+	}
+	return dgst
+}
+
+// ServeHTTP implements the OCI distribution API.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if req.URL.Path == "/v2/" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	if i := strings.LastIndex(path, "/blobs/uploads/"); i > 0 {
+		r.serveUpload(w, req, path[:i], strings.TrimPrefix(path[i:], "/blobs/uploads/"))
+		return
+	}
+	if i := strings.LastIndex(path, "/blobs/"); i > 0 {
+		r.serveBlob(w, req, strings.TrimPrefix(path[i:], "/blobs/"))
+		return
+	}
+	if i := strings.LastIndex(path, "/manifests/"); i > 0 {
+		r.serveManifest(w, req, path[:i], strings.TrimPrefix(path[i:], "/manifests/"))
+		return
+	}
+	writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "unknown route")
+}
+
+func (r *Registry) serveBlob(w http.ResponseWriter, req *http.Request, reference string) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "unsupported method")
+		return
+	}
+
+	content, ok := r.blobs[digest.Digest(reference)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown to registry")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Header().Set("Docker-Content-Digest", reference)
+	w.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodGet {
+		w.Write(content)
+	}
+}
+
+func (r *Registry) serveUpload(w http.ResponseWriter, req *http.Request, repository, id string) {
+	query := req.URL.Query()
+
+	if id == "" {
+		if req.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "unsupported method")
+			return
+		}
+		if mount := digest.Digest(query.Get("mount")); mount != "" {
+			if _, ok := r.blobs[mount]; ok {
+				w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repository, mount))
+				w.Header().Set("Docker-Content-Digest", mount.String())
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+		r.nextUpload++
+		id = strconv.Itoa(r.nextUpload)
+		r.uploads[id] = new(bytes.Buffer)
+		if query.Get("digest") != "" {
+			r.finishUpload(w, req, repository, id)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repository, id))
+		w.Header().Set("Range", "0-0")
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	buf, ok := r.uploads[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "blob upload unknown to registry")
+		return
+	}
+
+	switch req.Method {
+	case http.MethodPatch:
+		if _, err := io.Copy(buf, req.Body); err != nil {
+			writeError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID", err.Error())
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repository, id))
+		w.Header().Set("Range", fmt.Sprintf("0-%d", buf.Len()-1))
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		r.finishUpload(w, req, repository, id)
+	case http.MethodDelete:
+		delete(r.uploads, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "unsupported method")
+	}
+}
+
+// finishUpload completes an upload with the final content in the body of req,
+// verifying the digest given in the query.
+func (r *Registry) finishUpload(w http.ResponseWriter, req *http.Request, repository, id string) {
+	buf := r.uploads[id]
+	delete(r.uploads, id)
+
+	if _, err := io.Copy(buf, req.Body); err != nil {
+		writeError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID", err.Error())
+		return
+	}
+
+	dgst, err := digest.Parse(req.URL.Query().Get("digest"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "DIGEST_INVALID", err.Error())
+		return
+	}
+	if dgst.Algorithm().FromBytes(buf.Bytes()) != dgst {
+		writeError(w, http.StatusBadRequest, "DIGEST_INVALID", "provided digest did not match uploaded content")
+		return
+	}
+
+	r.blobs[dgst] = buf.Bytes()
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repository, dgst))
+	w.Header().Set("Docker-Content-Digest", dgst.String())
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (r *Registry) serveManifest(w http.ResponseWriter, req *http.Request, repository, reference string) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		m, ok := r.manifests[repository][reference]
+		if !ok {
+			writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown to registry")
+			return
+		}
+		w.Header().Set("Content-Type", m.MediaType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(m.Content)))
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(m.Content).String())
+		w.WriteHeader(http.StatusOK)
+		if req.Method == http.MethodGet {
+			w.Write(m.Content)
+		}
+
+	case http.MethodPut:
+		content, err := io.ReadAll(req.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+			return
+		}
+		if dgst, err := digest.Parse(reference); err == nil {
+			if dgst.Algorithm().FromBytes(content) != dgst {
+				writeError(w, http.StatusBadRequest, "DIGEST_INVALID", "provided digest did not match manifest content")
+				return
+			}
+		}
+		dgst := r.putManifest(repository, reference, Manifest{
+			MediaType: req.Header.Get("Content-Type"),
+			Content:   content,
+		})
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", repository, dgst))
+		w.Header().Set("Docker-Content-Digest", dgst.String())
+		w.WriteHeader(http.StatusCreated)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "unsupported method")
+	}
+}
+
+// writeError writes an error response in the format defined by the OCI
+// distribution spec.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
@@ -0,0 +1,58 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// unixReferencePrefix marks a reference to an image in a registry that listens
+// on a Unix domain socket rather than a TCP port, like
+// "unix:///run/registry.sock/app:latest".
+const unixReferencePrefix = "unix://"
+
+// unixSocketHost is the registry host that stands in for a Unix domain socket
+// in parsed references. The port makes go-containerregistry parse it as a
+// registry rather than as part of a Docker Hub repository name, and as a
+// localhost name, it also selects plain HTTP for the requests sent over the
+// socket.
+const unixSocketHost = "localhost:80"
+
+// splitUnixReference splits a reference to an image in a registry that listens
+// on a Unix domain socket into the path to the socket and an equivalent
+// reference whose registry is unixSocketHost. The socket is the longest leading
+// part of the reference's path that names an existing socket. References
+// without the unix:// prefix are returned unchanged with an empty socket path.
+func splitUnixReference(reference string) (socket, rest string, err error) {
+	path := strings.TrimPrefix(reference, unixReferencePrefix)
+	if path == reference {
+		return "", reference, nil
+	}
+
+	for i := strings.LastIndex(path, "/"); i > 0; i = strings.LastIndex(path[:i], "/") {
+		if stat, err := os.Stat(path[:i]); err == nil && stat.Mode()&fs.ModeSocket != 0 {
+			return path[:i], unixSocketHost + path[i:], nil
+		}
+	}
+	return "", "", fmt.Errorf("reference %q does not begin with the path to a Unix socket", reference)
+}
+
+// unixSocketTransport returns a transport that sends requests for
+// unixSocketHost over connections to the Unix domain socket at path, and sends
+// all other requests, such as those for token services, as usual.
+func unixSocketTransport(path string) http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	dial := t.DialContext
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == unixSocketHost {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+		return dial(ctx, network, addr)
+	}
+	return t
+}
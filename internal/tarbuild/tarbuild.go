@@ -9,6 +9,7 @@ import (
 	"io"
 	"io/fs"
 	"path"
+	"sort"
 	"strings"
 	"time"
 )
@@ -25,6 +26,10 @@ var ErrDuplicateEntry = errors.New("duplicate entry")
 // attempt to add an entry at or above the root of the archive.
 var ErrEntryOutsideOfArchive = errors.New("entry outside of archive")
 
+// ErrUnsafePath is the cause of an AddError resulting from an attempt to add an
+// entry using a path with ".." segments, when the Builder rejects such paths.
+var ErrUnsafePath = errors.New("path contains \"..\" segments")
+
 // AddError represents an error that occurred while adding an entry to an
 // archive using the given path.
 type AddError struct {
@@ -54,11 +59,48 @@ func (aerr AddError) Unwrap() error {
 type Builder struct {
 	DefaultModTime time.Time
 
+	// FixedModTime causes the Builder to set the modification time of every
+	// entry that it adds, other than those added with AddHeader, to
+	// DefaultModTime, rather than to the modification time of the added file.
+	// This makes the archive independent of when its files were last changed.
+	FixedModTime bool
+
+	// RejectUnsafePaths causes the Builder to reject any entry whose path
+	// contains ".." segments, with an AddError caused by ErrUnsafePath. By
+	// default, the Builder resolves ".." segments as if the path were rooted,
+	// so that "../../etc/passwd" becomes "etc/passwd". Rejecting such paths
+	// instead is safer when building archives from untrusted lists of files,
+	// where a ".." segment more likely indicates an attempted traversal than a
+	// path that the caller intended to clamp to the root of the archive.
+	RejectUnsafePaths bool
+
+	// ParentHeader, if set, is called with the normalized path of each parent
+	// directory that the Builder adds implicitly. If it returns a non-nil
+	// header, the directory's entry takes its mode, modification time, and
+	// ownership from that header rather than the defaults described above. This
+	// allows the entries of a container image layer to match the directories of
+	// lower layers, so that they do not replace the metadata of those
+	// directories when the layers are applied.
+	ParentHeader func(path string) *tar.Header
+
 	tw      *tar.Writer
+	cw      *countingWriter
 	err     error
 	entries map[npath]tarTypeflag
 }
 
+// countingWriter counts the bytes written to an underlying io.Writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
 // tarTypeflag matches the type of the Typeflag field in tar.Header.
 type tarTypeflag = byte
 
@@ -78,25 +120,100 @@ func normalizePath(p string) npath {
 	return npath(p)
 }
 
+// hasDotDotSegment returns true if any slash-separated segment of p is "..".
+func hasDotDotSegment(p string) bool {
+	for _, segment := range strings.Split(p, "/") {
+		if segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
 // NewBuilder returns a Builder that writes a tar archive to w, and whose
 // DefaultModTime is initialized to the current UTC time.
 func NewBuilder(w io.Writer) *Builder {
+	cw := &countingWriter{w: w}
 	return &Builder{
 		DefaultModTime: time.Now().UTC(),
-		tw:             tar.NewWriter(w),
+		tw:             tar.NewWriter(cw),
+		cw:             cw,
 		entries:        make(map[npath]tarTypeflag),
 	}
 }
 
+// NewBuilderAppend returns a Builder that appends entries to the existing tar
+// archive in rw, and whose DefaultModTime is initialized to the current UTC
+// time. NewBuilderAppend reads all existing entries from the start of rw, so
+// that the Builder treats them as previously added entries, then seeks to the
+// end of the last entry so that new entries overwrite the original footer.
+//
+// NewBuilderAppend does not support archives containing sparse files. It does
+// not truncate rw, so any padding that followed the original footer may remain
+// beyond the new footer after the client calls Close.
+func NewBuilderAppend(rw io.ReadWriteSeeker) (*Builder, error) {
+	if _, err := rw.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var (
+		tr      = tar.NewReader(rw)
+		entries = make(map[npath]tarTypeflag)
+		end     int64
+	)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("tarbuild: reading existing archive: %w", err)
+		}
+
+		// tar.Reader does not buffer reads beyond the headers of the current
+		// entry, so the content of the entry starts at the current offset and
+		// continues for its size rounded up to a full block.
+		start, err := rw.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		end = start + (header.Size+blockSize-1)/blockSize*blockSize
+
+		if np := normalizePath(header.Name); np != "." {
+			entries[np] = header.Typeflag
+		}
+	}
+
+	if _, err := rw.Seek(end, io.SeekStart); err != nil {
+		return nil, err
+	}
+	cw := &countingWriter{w: rw, n: end}
+	return &Builder{
+		DefaultModTime: time.Now().UTC(),
+		tw:             tar.NewWriter(cw),
+		cw:             cw,
+		entries:        entries,
+	}, nil
+}
+
+// blockSize is the size of each block in a tar archive. Headers, and the
+// content of each entry, are padded to a multiple of the block size.
+const blockSize = 512
+
 // AddContent adds the provided content to the archive as a file following the
 // semantics of Add, with mode 644 and the Builder's DefaultModTime as the
 // modification time.
 func (b *Builder) AddContent(path string, content []byte) error {
+	return b.AddContentWithModTime(path, content, b.DefaultModTime)
+}
+
+// AddContentWithModTime adds the provided content to the archive as a file
+// following the semantics of AddContent, with the provided modification time.
+func (b *Builder) AddContentWithModTime(path string, content []byte, modTime time.Time) error {
 	return b.Add(path, File{
 		Reader:  bytes.NewReader(content),
 		Size:    int64(len(content)),
 		Mode:    0644,
-		ModTime: b.DefaultModTime,
+		ModTime: modTime,
 	})
 }
 
@@ -129,6 +246,10 @@ func (b *Builder) Add(path string, file fs.File) (err error) {
 		}
 	}()
 
+	if b.RejectUnsafePaths && hasDotDotSegment(path) {
+		return ErrUnsafePath
+	}
+
 	np := normalizePath(path)
 	if np == "." {
 		return ErrEntryOutsideOfArchive
@@ -137,14 +258,19 @@ func (b *Builder) Add(path string, file fs.File) (err error) {
 	if _, ok := b.entries[np]; ok {
 		return ErrDuplicateEntry
 	}
-	b.entries[np] = tar.TypeReg
 
-	err = b.ensureParentDirectory(np)
+	stat, err := file.Stat()
 	if err != nil {
 		return err
 	}
 
-	stat, err := file.Stat()
+	if stat.IsDir() {
+		b.entries[np] = tar.TypeDir
+	} else {
+		b.entries[np] = tar.TypeReg
+	}
+
+	err = b.ensureParentDirectory(np)
 	if err != nil {
 		return err
 	}
@@ -163,6 +289,9 @@ func (b *Builder) Add(path string, file fs.File) (err error) {
 	header.Gid = 0
 	header.Uname = ""
 	header.Gname = ""
+	if b.FixedModTime {
+		header.ModTime = b.DefaultModTime
+	}
 	if err := b.tw.WriteHeader(header); err != nil {
 		return err
 	}
@@ -174,6 +303,133 @@ func (b *Builder) Add(path string, file fs.File) (err error) {
 	return err
 }
 
+// AddHeader adds an entry to the archive with a copy of the provided header,
+// following the semantics of Add for the entry's path and missing parent
+// directories. Unlike Add, AddHeader preserves every other field of the header,
+// including its type, ownership, and link target, which makes it suitable for
+// copying entries from existing archives. If the header describes a regular
+// file, AddHeader copies its content from r, which must provide exactly
+// header.Size bytes. Otherwise, r may be nil.
+func (b *Builder) AddHeader(header *tar.Header, r io.Reader) (err error) {
+	if b.err != nil {
+		return b.err
+	}
+
+	path := header.Name
+	defer func() {
+		if err != nil {
+			if aerr, ok := err.(AddError); ok {
+				b.err = aerr
+			} else {
+				b.err = AddError{path, err}
+			}
+		}
+	}()
+
+	if b.RejectUnsafePaths && hasDotDotSegment(path) {
+		return ErrUnsafePath
+	}
+
+	np := normalizePath(path)
+	if np == "." {
+		return ErrEntryOutsideOfArchive
+	}
+
+	if _, ok := b.entries[np]; ok {
+		return ErrDuplicateEntry
+	}
+
+	isDir := header.Typeflag == tar.TypeDir
+	b.entries[np] = header.Typeflag
+
+	err = b.ensureParentDirectory(np)
+	if err != nil {
+		return err
+	}
+
+	copied := *header
+	copied.Name = string(np)
+	if isDir {
+		copied.Name += "/"
+	}
+	if err := b.tw.WriteHeader(&copied); err != nil {
+		return err
+	}
+
+	if copied.Typeflag == tar.TypeReg && copied.Size > 0 {
+		_, err = io.CopyN(b.tw, r, copied.Size)
+	}
+	return err
+}
+
+// AddFS adds the contents of fsys to the archive under the provided directory,
+// following the semantics of Add for each file and directory in fsys. The
+// directory itself is added with the metadata of the root of fsys, unless it is
+// the root of the archive.
+//
+// AddFS adds the targets of symbolic links in place of the links themselves,
+// where fsys follows links when opening files. It does not descend into linked
+// directories, and skips any links to directories that it finds.
+func (b *Builder) AddFS(dir string, fsys fs.FS) error {
+	if b.err != nil {
+		return b.err
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." && normalizePath(dir) == "." {
+			return nil
+		}
+
+		file, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			stat, err := file.Stat()
+			if err != nil {
+				return err
+			}
+			if stat.IsDir() {
+				return nil
+			}
+		}
+
+		return b.Add(path.Join(dir, name), file)
+	})
+	if err != nil && b.err == nil {
+		b.err = AddError{dir, err}
+	}
+	return b.err
+}
+
+// Written returns the number of bytes of headers and content that the Builder
+// has written to the underlying writer so far. For a Builder created by
+// NewBuilderAppend, this includes the existing entries of the archive.
+//
+// The padding that follows the content of an entry is only written once the
+// next entry is added or the Builder is closed, and the tar footer is only
+// written by Close.
+func (b *Builder) Written() int64 {
+	return b.cw.n
+}
+
+// Entries returns the normalized paths of all entries that have been added to
+// the archive so far, including parent directories added implicitly, in sorted
+// order.
+func (b *Builder) Entries() []string {
+	entries := make([]string, 0, len(b.entries))
+	for np := range b.entries {
+		entries = append(entries, string(np))
+	}
+	sort.Strings(entries)
+	return entries
+}
+
 func (b *Builder) ensureParentDirectory(np npath) error {
 	// This function operates entirely on the *parent* of np, to ensure that the
 	// caller can handle the b.entries checks for np itself as it sees fit. As
@@ -205,11 +461,22 @@ func (b *Builder) ensureParentDirectory(np npath) error {
 	}
 
 	b.entries[parent] = tar.TypeDir
-	return b.tw.WriteHeader(&tar.Header{
+	header := &tar.Header{
 		Name:    string(parent) + "/",
 		Mode:    0755,
 		ModTime: b.DefaultModTime,
-	})
+	}
+	if b.ParentHeader != nil {
+		if template := b.ParentHeader(string(parent)); template != nil {
+			header.Mode = template.Mode
+			header.ModTime = template.ModTime
+			header.Uid = template.Uid
+			header.Gid = template.Gid
+			header.Uname = template.Uname
+			header.Gname = template.Gname
+		}
+	}
+	return b.tw.WriteHeader(header)
 }
 
 // Close finishes writing the tar archive if all entries were added
@@ -6,8 +6,11 @@ import (
 	"errors"
 	"io"
 	"io/fs"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
@@ -16,6 +19,13 @@ import (
 
 var defaultModTime = time.Date(2021, time.October, 24, 2, 36, 42, 0, time.UTC)
 
+// timedContent represents file content added to a Builder with an explicit
+// modification time.
+type timedContent struct {
+	Content string
+	ModTime time.Time
+}
+
 func TestBuilder(t *testing.T) {
 	type testEntry struct {
 		Path    string
@@ -62,6 +72,27 @@ func TestBuilder(t *testing.T) {
 				{Typeflag: tar.TypeDir, Name: "home/", Mode: 0755, ModTime: defaultModTime},
 			},
 		},
+		{
+			Description: "explicit directory with contents",
+			Entries: []testEntry{
+				{"etc", Dir{Mode: fs.ModeDir | 0700, ModTime: defaultModTime}},
+				{"etc/hostname", "zeroimage"},
+			},
+			WantHeaders: []tar.Header{
+				{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0700, ModTime: defaultModTime},
+				{Typeflag: tar.TypeReg, Name: "etc/hostname", Size: 9, Mode: 0644, ModTime: defaultModTime},
+			},
+		},
+		{
+			Description: "content with explicit modification time",
+			Entries: []testEntry{
+				{"etc/hostname", timedContent{"zeroimage", time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)}},
+			},
+			WantHeaders: []tar.Header{
+				{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0755, ModTime: defaultModTime},
+				{Typeflag: tar.TypeReg, Name: "etc/hostname", Size: 9, Mode: 0644, ModTime: time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)},
+			},
+		},
 		{
 			Description: "explicit duplicate file",
 			Entries:     []testEntry{{"test.txt", "test"}, {"test.txt", "oops"}},
@@ -96,6 +127,8 @@ func TestBuilder(t *testing.T) {
 				switch content := entry.Content.(type) {
 				case string:
 					builder.AddContent(entry.Path, []byte(content))
+				case timedContent:
+					builder.AddContentWithModTime(entry.Path, []byte(content.Content), content.ModTime)
 				case fs.File:
 					builder.Add(entry.Path, content)
 				default:
@@ -136,3 +169,277 @@ func TestBuilder(t *testing.T) {
 		})
 	}
 }
+
+func TestBuilderEntries(t *testing.T) {
+	builder := NewBuilder(io.Discard)
+	builder.AddContent("/usr/bin/hello", []byte("hello"))
+	builder.AddContent("/etc/hostname", []byte("localhost"))
+	if err := builder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"etc", "etc/hostname", "usr", "usr/bin", "usr/bin/hello"}
+	if diff := cmp.Diff(want, builder.Entries()); diff != "" {
+		t.Errorf("unexpected entries (-want +got):\n%s", diff)
+	}
+}
+
+func TestBuilderAppend(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "layer.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	builder := NewBuilder(f)
+	builder.DefaultModTime = defaultModTime
+	builder.AddContent("etc/hostname", []byte("localhost"))
+	if err := builder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	builder, err = NewBuilderAppend(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder.DefaultModTime = defaultModTime
+	builder.AddContent("etc/hosts", []byte("127.0.0.1 localhost"))
+	builder.AddContent("usr/bin/hello", []byte("hello"))
+	if err := builder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		tr       = tar.NewReader(f)
+		gotNames []string
+	)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("error reading archive: %v", err)
+		}
+		gotNames = append(gotNames, header.Name)
+	}
+
+	wantNames := []string{"etc/", "etc/hostname", "etc/hosts", "usr/", "usr/bin/", "usr/bin/hello"}
+	if diff := cmp.Diff(wantNames, gotNames); diff != "" {
+		t.Errorf("unexpected archive contents (-want +got):\n%s", diff)
+	}
+
+	builder, err = NewBuilderAppend(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder.AddContent("etc/hostname", []byte("example"))
+	if err := builder.Close(); !errors.Is(err, ErrDuplicateEntry) {
+		t.Errorf("got error %v, want %v", err, ErrDuplicateEntry)
+	}
+}
+
+func TestBuilderWritten(t *testing.T) {
+	var buf bytes.Buffer
+	builder := NewBuilder(&buf)
+	builder.AddContent("etc/hostname", []byte("localhost"))
+	if got := builder.Written(); got != int64(buf.Len()) {
+		t.Errorf("Written() = %d after add, want %d", got, buf.Len())
+	}
+
+	if err := builder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := builder.Written(); got != int64(buf.Len()) {
+		t.Errorf("Written() = %d after close, want %d", got, buf.Len())
+	}
+}
+
+func TestBuilderParentHeader(t *testing.T) {
+	var buf bytes.Buffer
+	builder := NewBuilder(&buf)
+	builder.DefaultModTime = defaultModTime
+	builder.ParentHeader = func(path string) *tar.Header {
+		if path == "tmp" {
+			return &tar.Header{Mode: 01777, ModTime: defaultModTime.Add(-time.Hour), Uid: 1, Gid: 2}
+		}
+		return nil
+	}
+	builder.AddContent("/tmp/cache/data", []byte("data"))
+	if err := builder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var gotHeaders []tar.Header
+	tr := tar.NewReader(&buf)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("error reading archive: %v", err)
+		}
+		gotHeaders = append(gotHeaders, *header)
+	}
+
+	wantHeaders := []tar.Header{
+		{Typeflag: tar.TypeDir, Name: "tmp/", Mode: 01777, ModTime: defaultModTime.Add(-time.Hour), Uid: 1, Gid: 2},
+		{Typeflag: tar.TypeDir, Name: "tmp/cache/", Mode: 0755, ModTime: defaultModTime},
+		{Typeflag: tar.TypeReg, Name: "tmp/cache/data", Size: 4, Mode: 0644, ModTime: defaultModTime},
+	}
+	diff := cmp.Diff(
+		wantHeaders, gotHeaders,
+		cmpopts.IgnoreFields(tar.Header{}, "Format"),
+	)
+	if diff != "" {
+		t.Errorf("unexpected archive contents (-want +got):\n%s", diff)
+	}
+}
+
+func TestBuilderFixedModTime(t *testing.T) {
+	var buf bytes.Buffer
+	builder := NewBuilder(&buf)
+	builder.DefaultModTime = time.Unix(0, 0).UTC()
+	builder.FixedModTime = true
+
+	builder.Add("/bin/app", File{
+		Reader:  strings.NewReader("app"),
+		Size:    3,
+		Mode:    0755,
+		ModTime: defaultModTime,
+	})
+	builder.AddContentWithModTime("/etc/app.conf", []byte("conf"), defaultModTime)
+	if err := builder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tar.NewReader(&buf)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("error reading archive: %v", err)
+		}
+		if !header.ModTime.Equal(builder.DefaultModTime) {
+			t.Errorf("%s has modification time %v, want %v", header.Name, header.ModTime, builder.DefaultModTime)
+		}
+	}
+}
+
+func TestBuilderAddHeader(t *testing.T) {
+	var buf bytes.Buffer
+	builder := NewBuilder(&buf)
+	builder.DefaultModTime = defaultModTime
+
+	wantHeaders := []tar.Header{
+		{Typeflag: tar.TypeDir, Name: "home/", Mode: 0755, ModTime: defaultModTime},
+		{Typeflag: tar.TypeDir, Name: "home/nonroot/", Mode: 0700, ModTime: defaultModTime, Uid: 65532, Gid: 65532},
+		{Typeflag: tar.TypeReg, Name: "home/nonroot/.profile", Size: 5, Mode: 0600, ModTime: defaultModTime, Uid: 65532, Gid: 65532},
+		{Typeflag: tar.TypeSymlink, Name: "home/nonroot/profile", Linkname: ".profile", Mode: 0777, ModTime: defaultModTime, Uid: 65532, Gid: 65532},
+	}
+	builder.AddHeader(&tar.Header{
+		Typeflag: tar.TypeDir, Name: "/home/nonroot", Mode: 0700, ModTime: defaultModTime, Uid: 65532, Gid: 65532,
+	}, nil)
+	builder.AddHeader(&wantHeaders[2], strings.NewReader("hello"))
+	builder.AddHeader(&wantHeaders[3], nil)
+	if err := builder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var gotHeaders []tar.Header
+	tr := tar.NewReader(&buf)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("error reading archive: %v", err)
+		}
+		gotHeaders = append(gotHeaders, *header)
+	}
+
+	diff := cmp.Diff(
+		wantHeaders, gotHeaders,
+		cmpopts.IgnoreFields(tar.Header{}, "Format"),
+	)
+	if diff != "" {
+		t.Errorf("unexpected archive contents (-want +got):\n%s", diff)
+	}
+}
+
+func TestBuilderRejectUnsafePaths(t *testing.T) {
+	testCases := []struct {
+		Path      string
+		WantError error
+	}{
+		{"etc/passwd", nil},
+		{"/etc/../etc/passwd", ErrUnsafePath},
+		{"../../etc/passwd", ErrUnsafePath},
+		{"etc/..passwd", nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Path, func(t *testing.T) {
+			builder := NewBuilder(io.Discard)
+			builder.RejectUnsafePaths = true
+			builder.AddContent(tc.Path, []byte("root:x:0:0:root:/root:/bin/sh"))
+
+			err := builder.Close()
+			if !errors.Is(err, tc.WantError) {
+				t.Errorf("got error %v, want %v", err, tc.WantError)
+			}
+			var aerr AddError
+			if tc.WantError != nil && (!errors.As(err, &aerr) || aerr.Path != tc.Path) {
+				t.Errorf("got error %v, want AddError for %q", err, tc.Path)
+			}
+		})
+	}
+}
+
+func TestBuilderAddFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		".":                   {Mode: fs.ModeDir | 0755, ModTime: defaultModTime},
+		"UTC":                 {Data: []byte("utc"), Mode: 0644, ModTime: defaultModTime},
+		"America":             {Mode: fs.ModeDir | 0755, ModTime: defaultModTime},
+		"America/Los_Angeles": {Data: []byte("pacific"), Mode: 0644, ModTime: defaultModTime},
+	}
+
+	var archive bytes.Buffer
+	builder := NewBuilder(&archive)
+	builder.DefaultModTime = defaultModTime
+	builder.AddFS("/usr/share/zoneinfo", fsys)
+	if err := builder.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tr := tar.NewReader(&archive)
+	var gotHeaders []tar.Header
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("error reading archive: %v", err)
+		}
+		gotHeaders = append(gotHeaders, *header)
+	}
+
+	wantHeaders := []tar.Header{
+		{Typeflag: tar.TypeDir, Name: "usr/", Mode: 0755, ModTime: defaultModTime},
+		{Typeflag: tar.TypeDir, Name: "usr/share/", Mode: 0755, ModTime: defaultModTime},
+		{Typeflag: tar.TypeDir, Name: "usr/share/zoneinfo/", Mode: 0755, ModTime: defaultModTime},
+		{Typeflag: tar.TypeDir, Name: "usr/share/zoneinfo/America/", Mode: 0755, ModTime: defaultModTime},
+		{Typeflag: tar.TypeReg, Name: "usr/share/zoneinfo/America/Los_Angeles", Size: 7, Mode: 0644, ModTime: defaultModTime},
+		{Typeflag: tar.TypeReg, Name: "usr/share/zoneinfo/UTC", Size: 3, Mode: 0644, ModTime: defaultModTime},
+	}
+	diff := cmp.Diff(
+		wantHeaders, gotHeaders,
+		cmpopts.IgnoreFields(tar.Header{}, "Format"),
+	)
+	if diff != "" {
+		t.Errorf("unexpected archive contents (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,103 @@
+package tarlayer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+
+	"github.com/opencontainers/go-digest"
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"go.alexhamlin.co/zeroimage/internal/image"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	tarMagic  = []byte("ustar")
+)
+
+// tarMagicOffset is the offset of the magic field in the header of a tar
+// archive in the POSIX (ustar) or GNU format.
+const tarMagicOffset = 257
+
+// MinSniffLength is the number of bytes from the start of a layer blob that
+// DetectMediaType needs to examine.
+const MinSniffLength = tarMagicOffset + 5
+
+// ErrUnknownLayerFormat is returned when the content of a layer blob does not
+// match any supported layer format.
+var ErrUnknownLayerFormat = errors.New("tarlayer: content is not a gzip, zstd, or uncompressed tar archive")
+
+// DetectMediaType returns the OCI media type of a layer blob based on the
+// content at its start, which should include at least MinSniffLength bytes if
+// the blob is that long.
+func DetectMediaType(content []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(content, gzipMagic):
+		return specsv1.MediaTypeImageLayerGzip, nil
+	case bytes.HasPrefix(content, zstdMagic):
+		return specsv1.MediaTypeImageLayerZstd, nil
+	case len(content) >= MinSniffLength && bytes.Equal(content[tarMagicOffset:MinSniffLength], tarMagic):
+		return specsv1.MediaTypeImageLayer, nil
+	default:
+		return "", ErrUnknownLayerFormat
+	}
+}
+
+// Import creates a container image layer from the existing layer blob read
+// from r, detecting its media type with DetectMediaType. Import reads the
+// entire blob into memory. It uses the DigestAlgorithm and Annotations of opts,
+// and ignores opts.Compression.
+//
+// Import cannot compute the diff ID of a zstd-compressed blob, and returns an
+// error for one.
+func Import(r io.Reader, opts Options) (image.Layer, error) {
+	alg := opts.DigestAlgorithm
+	if alg == "" {
+		alg = digest.Canonical
+	}
+
+	blob, err := io.ReadAll(r)
+	if err != nil {
+		return image.Layer{}, err
+	}
+
+	mediaType, err := DetectMediaType(blob)
+	if err != nil {
+		return image.Layer{}, err
+	}
+
+	blobDigest := alg.FromBytes(blob)
+	var diffID digest.Digest
+	switch mediaType {
+	case specsv1.MediaTypeImageLayer:
+		diffID = blobDigest
+	case specsv1.MediaTypeImageLayerGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(blob))
+		if err != nil {
+			return image.Layer{}, err
+		}
+		diffID, err = alg.FromReader(zr)
+		if err != nil {
+			return image.Layer{}, err
+		}
+	default:
+		return image.Layer{}, errors.New("tarlayer: cannot import zstd-compressed layers")
+	}
+
+	return image.Layer{
+		Descriptor: specsv1.Descriptor{
+			MediaType:   mediaType,
+			Digest:      blobDigest,
+			Size:        int64(len(blob)),
+			Annotations: opts.Annotations,
+		},
+		DiffID: diffID,
+		OpenBlob: func(_ context.Context) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(blob)), nil
+		},
+	}, nil
+}
@@ -0,0 +1,70 @@
+package tarlayer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	specsv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestImport(t *testing.T) {
+	// Ensure that importing a layer built by a Builder, in any supported
+	// compression, reproduces the original layer's identity.
+	for _, compression := range []Compression{Gzip, Uncompressed} {
+		t.Run(string(compression), func(t *testing.T) {
+			builder := NewBuilderWithOptions(Options{Compression: compression})
+			builder.AddContent("hello.txt", []byte("hello world"))
+			want, err := builder.Finish()
+			if err != nil {
+				t.Fatalf("failed to build layer: %v", err)
+			}
+
+			blob, err := want.OpenBlob(context.Background())
+			if err != nil {
+				t.Fatalf("failed to open layer blob: %v", err)
+			}
+			defer blob.Close()
+
+			got, err := Import(blob, Options{})
+			if err != nil {
+				t.Fatalf("failed to import layer: %v", err)
+			}
+			if got.Descriptor.MediaType != want.Descriptor.MediaType {
+				t.Errorf("imported media type %q, want %q", got.Descriptor.MediaType, want.Descriptor.MediaType)
+			}
+			if got.Descriptor.Digest != want.Descriptor.Digest {
+				t.Errorf("imported digest %s, want %s", got.Descriptor.Digest, want.Descriptor.Digest)
+			}
+			if got.DiffID != want.DiffID {
+				t.Errorf("imported diff ID %s, want %s", got.DiffID, want.DiffID)
+			}
+		})
+	}
+}
+
+func TestDetectMediaType(t *testing.T) {
+	testCases := []struct {
+		Description   string
+		Content       []byte
+		WantMediaType string
+		WantError     error
+	}{
+		{"gzip", []byte{0x1f, 0x8b, 0x08, 0x00}, specsv1.MediaTypeImageLayerGzip, nil},
+		{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00}, specsv1.MediaTypeImageLayerZstd, nil},
+		{"unknown", []byte("definitely not a layer"), "", ErrUnknownLayerFormat},
+		{"empty", nil, "", ErrUnknownLayerFormat},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Description, func(t *testing.T) {
+			mediaType, err := DetectMediaType(tc.Content)
+			if !errors.Is(err, tc.WantError) {
+				t.Fatalf("got error %v, want %v", err, tc.WantError)
+			}
+			if mediaType != tc.WantMediaType {
+				t.Errorf("got media type %q, want %q", mediaType, tc.WantMediaType)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package tarlayer
+
+import (
+	"archive/tar"
+	"io/fs"
+	"path"
+	"time"
+
+	"go.alexhamlin.co/zeroimage/internal/image"
+)
+
+// SplitBuilder creates a sequence of container image layers from a single set
+// of entries, starting a new layer whenever the uncompressed size of the
+// current layer reaches a maximum. A SplitBuilder never splits a single entry
+// across layers, so a layer containing a large file may exceed the maximum.
+//
+// Each layer is built by its own Builder, so the parent directories of an entry
+// are added to every layer that needs them with the metadata described by
+// tarbuild.Builder, and duplicate entries are only detected within a layer.
+// Clients that add directories with custom metadata should add their contents
+// immediately after them.
+type SplitBuilder struct {
+	// DefaultModTime is the DefaultModTime of each layer's Builder. It is
+	// initialized to the current UTC time.
+	DefaultModTime time.Time
+	// ParentHeader is the ParentHeader of each layer's Builder.
+	ParentHeader func(path string) *tar.Header
+	// FixedModTime is the FixedModTime of each layer's Builder.
+	FixedModTime bool
+
+	maxSize int64
+	opts    Options
+	current *Builder
+	layers  []image.Layer
+	err     error
+}
+
+// NewSplitBuilder initializes a SplitBuilder that starts a new layer whenever
+// the current layer holds at least maxSize bytes of uncompressed tar archive,
+// or never if maxSize is 0. Each layer is built as customized by opts.
+func NewSplitBuilder(maxSize int64, opts Options) *SplitBuilder {
+	sb := &SplitBuilder{
+		DefaultModTime: time.Now().UTC(),
+		maxSize:        maxSize,
+		opts:           opts,
+	}
+	sb.current = sb.newBuilder()
+	return sb
+}
+
+func (sb *SplitBuilder) newBuilder() *Builder {
+	b := NewBuilderWithOptions(sb.opts)
+	b.DefaultModTime = sb.DefaultModTime
+	b.ParentHeader = sb.ParentHeader
+	b.FixedModTime = sb.FixedModTime
+	return b
+}
+
+// rollover finishes the current layer and starts a new one if the current
+// layer has reached the maximum size.
+func (sb *SplitBuilder) rollover() error {
+	if sb.err != nil {
+		return sb.err
+	}
+
+	sb.current.DefaultModTime = sb.DefaultModTime
+	sb.current.ParentHeader = sb.ParentHeader
+	sb.current.FixedModTime = sb.FixedModTime
+	written := sb.current.Written()
+	if sb.maxSize <= 0 || written == 0 || written < sb.maxSize {
+		return nil
+	}
+
+	layer, err := sb.current.Finish()
+	if err != nil {
+		sb.err = err
+		return err
+	}
+	sb.layers = append(sb.layers, layer)
+	sb.current = sb.newBuilder()
+	return nil
+}
+
+// Add adds a file to the current layer following the semantics of
+// tarbuild.Builder.Add, after starting a new layer if necessary.
+func (sb *SplitBuilder) Add(path string, file fs.File) error {
+	if err := sb.rollover(); err != nil {
+		return err
+	}
+	return sb.current.Add(path, file)
+}
+
+// AddContent adds the provided content to the current layer following the
+// semantics of tarbuild.Builder.AddContent, after starting a new layer if
+// necessary.
+func (sb *SplitBuilder) AddContent(path string, content []byte) error {
+	if err := sb.rollover(); err != nil {
+		return err
+	}
+	return sb.current.AddContent(path, content)
+}
+
+// AddFS adds the contents of fsys under the provided directory following the
+// semantics of tarbuild.Builder.AddFS, starting new layers between files as
+// necessary.
+func (sb *SplitBuilder) AddFS(dir string, fsys fs.FS) error {
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." && path.Clean("/"+dir) == "/" {
+			return nil
+		}
+
+		file, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			stat, err := file.Stat()
+			if err != nil {
+				return err
+			}
+			if stat.IsDir() {
+				return nil
+			}
+		}
+
+		return sb.Add(path.Join(dir, name), file)
+	})
+}
+
+// Finish finishes the current layer, and returns all of the layers built by
+// the SplitBuilder in order if all entries were successfully added. Finish
+// always returns at least one layer on success, even if it is empty.
+func (sb *SplitBuilder) Finish() ([]image.Layer, error) {
+	if sb.err != nil {
+		return nil, sb.err
+	}
+	if sb.current.Written() > 0 || len(sb.layers) == 0 {
+		layer, err := sb.current.Finish()
+		if err != nil {
+			sb.err = err
+			return nil, err
+		}
+		sb.layers = append(sb.layers, layer)
+	}
+	return sb.layers, nil
+}
@@ -0,0 +1,49 @@
+package tarlayer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSplitBuilder(t *testing.T) {
+	// Each entry takes one 512-byte header block plus its content, so the first
+	// layer reaches the maximum size after its second entry.
+	builder := NewSplitBuilder(1024, Options{})
+	for _, name := range []string{"a", "b", "c"} {
+		builder.AddContent(name, []byte(strings.Repeat(name, 300)))
+	}
+	layers, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("failed to build layers: %v", err)
+	}
+
+	var got [][]string
+	for _, layer := range layers {
+		tr, err := layer.OpenTar(context.Background())
+		if err != nil {
+			t.Fatalf("failed to open layer: %v", err)
+		}
+		var names []string
+		for {
+			header, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				t.Fatalf("failed to read layer: %v", err)
+			}
+			names = append(names, header.Name)
+		}
+		tr.Close()
+		got = append(got, names)
+	}
+
+	want := [][]string{{"a", "b"}, {"c"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected layer contents (-want +got):\n%s", diff)
+	}
+}
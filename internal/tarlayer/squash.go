@@ -0,0 +1,88 @@
+package tarlayer
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"go.alexhamlin.co/zeroimage/internal/image"
+)
+
+// Squash creates a single container image layer containing the filesystem
+// produced by applying layers in order, as customized by opts. The entries of
+// the squashed layer keep the headers of the entries that produced them,
+// including their ownership and modification times. Because the squashed layer
+// describes a complete filesystem, it contains no whiteouts.
+//
+// Squash writes every directory before any other entry, so that each directory
+// appears with its final metadata before its contents. Hard links are written
+// last, after the files that they refer to.
+func Squash(ctx context.Context, layers []image.Layer, opts Options) (image.Layer, error) {
+	files, err := image.Image{Layers: layers}.Files(ctx)
+	if err != nil {
+		return image.Layer{}, err
+	}
+
+	b := NewBuilderWithOptions(opts)
+
+	var links []image.File
+	contentLayers := make(map[string]int)
+	for _, f := range files {
+		switch f.Header.Typeflag {
+		case tar.TypeDir:
+			if err := b.AddHeader(f.Header, nil); err != nil {
+				return image.Layer{}, err
+			}
+		case tar.TypeLink:
+			links = append(links, f)
+		default:
+			contentLayers[f.Path] = f.Layer
+		}
+	}
+
+	for i, layer := range layers {
+		if err := squashLayerContent(ctx, b, i, layer, contentLayers); err != nil {
+			return image.Layer{}, fmt.Errorf("layer %d: %w", i, err)
+		}
+	}
+
+	for _, f := range links {
+		if err := b.AddHeader(f.Header, nil); err != nil {
+			return image.Layer{}, err
+		}
+	}
+
+	return b.Finish()
+}
+
+// squashLayerContent adds the entries of layer whose paths are mapped to index
+// in contentLayers, removing each path from contentLayers once it is added.
+func squashLayerContent(ctx context.Context, b *Builder, index int, layer image.Layer, contentLayers map[string]int) error {
+	tr, err := layer.OpenTar(ctx)
+	if err != nil {
+		return err
+	}
+	defer tr.Close()
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		p := strings.TrimPrefix(path.Clean("/"+header.Name), "/")
+		if li, ok := contentLayers[p]; !ok || li != index {
+			continue
+		}
+		delete(contentLayers, p)
+		if err := b.AddHeader(header, tr); err != nil {
+			return err
+		}
+	}
+}
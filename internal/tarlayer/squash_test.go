@@ -0,0 +1,98 @@
+package tarlayer
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"go.alexhamlin.co/zeroimage/internal/image"
+)
+
+func TestSquash(t *testing.T) {
+	modTime := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	type layerEntry struct {
+		Header  tar.Header
+		Content string
+	}
+	buildLayer := func(entries ...layerEntry) image.Layer {
+		builder := NewBuilder()
+		for _, e := range entries {
+			header := e.Header
+			header.ModTime = modTime
+			header.Size = int64(len(e.Content))
+			builder.AddHeader(&header, strings.NewReader(e.Content))
+		}
+		layer, err := builder.Finish()
+		if err != nil {
+			t.Fatalf("failed to build layer: %v", err)
+		}
+		return layer
+	}
+
+	layers := []image.Layer{
+		buildLayer(
+			layerEntry{tar.Header{Typeflag: tar.TypeDir, Name: "tmp/", Mode: 01777}, ""},
+			layerEntry{tar.Header{Typeflag: tar.TypeReg, Name: "etc/passwd", Mode: 0644}, "old"},
+			layerEntry{tar.Header{Typeflag: tar.TypeReg, Name: "etc/group", Mode: 0644}, "group"},
+			layerEntry{tar.Header{Typeflag: tar.TypeReg, Name: "bin/app", Mode: 0755}, "app"},
+		),
+		buildLayer(
+			layerEntry{tar.Header{Typeflag: tar.TypeReg, Name: "etc/.wh.group", Mode: 0644}, ""},
+			layerEntry{tar.Header{Typeflag: tar.TypeReg, Name: "etc/passwd", Mode: 0600}, "new"},
+			layerEntry{tar.Header{Typeflag: tar.TypeLink, Name: "bin/app2", Linkname: "bin/app"}, ""},
+			layerEntry{tar.Header{Typeflag: tar.TypeDir, Name: "home/nonroot/", Mode: 0700, Uid: 65532, Gid: 65532}, ""},
+		),
+	}
+
+	squashed, err := Squash(context.Background(), layers, Options{})
+	if err != nil {
+		t.Fatalf("failed to squash layers: %v", err)
+	}
+
+	type entry struct {
+		Name     string
+		Mode     int64
+		Uid      int
+		Linkname string
+		Content  string
+	}
+	var got []entry
+	tr, err := squashed.OpenTar(context.Background())
+	if err != nil {
+		t.Fatalf("failed to open squashed layer: %v", err)
+	}
+	defer tr.Close()
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("failed to read squashed layer: %v", err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", header.Name, err)
+		}
+		got = append(got, entry{header.Name, header.Mode, header.Uid, header.Linkname, string(content)})
+	}
+
+	want := []entry{
+		{Name: "bin/", Mode: 0755},
+		{Name: "etc/", Mode: 0755},
+		{Name: "home/", Mode: 0755},
+		{Name: "home/nonroot/", Mode: 0700, Uid: 65532},
+		{Name: "tmp/", Mode: 01777},
+		{Name: "bin/app", Mode: 0755, Content: "app"},
+		{Name: "etc/passwd", Mode: 0600, Content: "new"},
+		{Name: "bin/app2", Linkname: "bin/app"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected squashed layer contents (-want +got):\n%s", diff)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"fmt"
 	"hash"
 	"io"
 
@@ -15,29 +16,99 @@ import (
 	"go.alexhamlin.co/zeroimage/internal/tarbuild"
 )
 
-// Builder wraps a tarbuild.Builder to create a compressed container image
-// layer, computing the digest and diff ID of the layer as it is built.
+// Builder wraps a tarbuild.Builder to create a container image layer, computing
+// the digest and diff ID of the layer as it is built.
 type Builder struct {
 	*tarbuild.Builder
 
-	buf      bytes.Buffer
-	zw       *gzip.Writer
-	tarHash  hash.Hash
-	gzipHash hash.Hash
+	alg         digest.Algorithm
+	mediaType   string
+	annotations map[string]string
+	buf         bytes.Buffer
+	zw          io.WriteCloser
+	tarHash     hash.Hash
+	blobHash    hash.Hash
+}
+
+// Compression represents a method of compressing a layer's tar archive.
+type Compression string
+
+// Supported layer compression methods.
+const (
+	Gzip         Compression = "gzip"
+	Uncompressed Compression = "none"
+)
+
+// ParseCompression returns the Compression named by s, or an error if s does
+// not name a supported compression method.
+func ParseCompression(s string) (Compression, error) {
+	switch c := Compression(s); c {
+	case Gzip, Uncompressed:
+		return c, nil
+	default:
+		return "", fmt.Errorf("unsupported layer compression %q", s)
+	}
+}
+
+// Options customizes the layers produced by a Builder.
+type Options struct {
+	// DigestAlgorithm is the algorithm used to compute the digest and diff ID of
+	// the layer. The zero value selects digest.Canonical.
+	DigestAlgorithm digest.Algorithm
+	// Compression is the method used to compress the layer. The zero value
+	// selects Gzip.
+	Compression Compression
+	// Annotations are set on the descriptor of the layer, for example to record
+	// the step of a build that produced it.
+	Annotations map[string]string
 }
 
 // NewBuilder initializes a Builder that writes a compressed tar archive to an
-// in memory buffer.
+// in memory buffer, using the default Options.
 func NewBuilder() *Builder {
+	return NewBuilderWithOptions(Options{})
+}
+
+// NewBuilderWithOptions initializes a Builder that writes a tar archive to an
+// in memory buffer, as customized by opts. NewBuilderWithOptions panics if
+// opts.Compression is not a supported compression method.
+func NewBuilderWithOptions(opts Options) *Builder {
+	alg := opts.DigestAlgorithm
+	if alg == "" {
+		alg = digest.Canonical
+	}
+
 	b := &Builder{
-		tarHash:  digest.Canonical.Hash(),
-		gzipHash: digest.Canonical.Hash(),
+		alg:         alg,
+		annotations: opts.Annotations,
+		tarHash:     alg.Hash(),
+		blobHash:    alg.Hash(),
 	}
-	b.zw = gzip.NewWriter(io.MultiWriter(&b.buf, b.gzipHash))
+
+	blobWriter := io.MultiWriter(&b.buf, b.blobHash)
+	switch opts.Compression {
+	case Gzip, "":
+		b.mediaType = specsv1.MediaTypeImageLayerGzip
+		b.zw = gzip.NewWriter(blobWriter)
+	case Uncompressed:
+		b.mediaType = specsv1.MediaTypeImageLayer
+		b.zw = nopWriteCloser{blobWriter}
+	default:
+		panic(fmt.Errorf("tarlayer: unsupported layer compression %q", opts.Compression))
+	}
+
 	b.Builder = tarbuild.NewBuilder(io.MultiWriter(b.zw, b.tarHash))
 	return b
 }
 
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
 // Finish closes the embedded tarbuild.Builder, and returns a container image
 // layer if all entries were successfully added to the tar archive.
 func (b *Builder) Finish() (image.Layer, error) {
@@ -50,11 +121,12 @@ func (b *Builder) Finish() (image.Layer, error) {
 
 	return image.Layer{
 		Descriptor: specsv1.Descriptor{
-			MediaType: specsv1.MediaTypeImageLayerGzip,
-			Digest:    digest.NewDigest(digest.Canonical, b.gzipHash),
-			Size:      int64(b.buf.Len()),
+			MediaType:   b.mediaType,
+			Digest:      digest.NewDigest(b.alg, b.blobHash),
+			Size:        int64(b.buf.Len()),
+			Annotations: b.annotations,
 		},
-		DiffID: digest.NewDigest(digest.Canonical, b.tarHash),
+		DiffID: digest.NewDigest(b.alg, b.tarHash),
 		OpenBlob: func(_ context.Context) (io.ReadCloser, error) {
 			return io.NopCloser(bytes.NewReader(b.buf.Bytes())), nil
 		},